@@ -8,14 +8,63 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterAdminRoutes(r *gin.Engine, keysSvc *services.KeysService, entriesSvc *services.EntriesService) {
+func RegisterAdminRoutes(r *gin.Engine,
+	keysSvc *services.KeysService,
+	entriesSvc *services.EntriesService,
+	trackingSvc *services.TrackingService,
+	notifySvc *services.NotificationService,
+	hooks *services.WebhookService,
+	analytics *services.AnalyticsService,
+	exports *services.ExportService,
+	imports *services.ImportService,
+	backups *services.BackupService,
+	scheduler *services.BackupScheduler,
+	lifecycle *services.LifecycleService,
+	checkpoints *services.CheckpointService,
+	audit *services.AuditLog,
+) {
 	admin := r.Group("/admin", middleware.RequireLogin())
 	{
 		admin.GET("/keys/generate", func(c *gin.Context) {
 			c.HTML(http.StatusOK, "key_gen.html", gin.H{})
 		})
-		admin.POST("/keys/generate", KeysGenerate(keysSvc))
+		admin.POST("/keys/generate", KeysGenerate(keysSvc, hooks, audit))
 		admin.GET("/keys/status/:key", KeyStatus(keysSvc, entriesSvc))
 		admin.GET("/keys", KeysList(keysSvc, entriesSvc))
+		admin.GET("/entries", EntrySearch(entriesSvc))
+		admin.POST("/keys/:key/revoke", KeyRevoke(keysSvc, entriesSvc, hooks, audit))
+		admin.POST("/keys/:key/archive", KeyArchive(lifecycle, audit))
+		admin.POST("/keys/:key/unarchive", KeyUnarchive(lifecycle, audit))
+		admin.POST("/keys/:key/delete", KeyDelete(lifecycle, audit))
+		admin.POST("/keys/:key/restore", KeyRestore(lifecycle, audit))
+		admin.POST("/keys/:key/purge", KeyPurge(lifecycle, audit))
+		admin.GET("/trash", TrashList(lifecycle, keysSvc, entriesSvc))
+		admin.GET("/audit", AuditPage(audit))
+		admin.POST("/entries/:key/status", EntryStatusUpdate(entriesSvc, audit))
+		admin.POST("/entries/:key/tracking/sync", TrackingSync(trackingSvc, audit))
+		admin.POST("/entries/:key/visitors/erase", VisitorsErase(entriesSvc, audit))
+		admin.GET("/checkpoints", CheckpointsList(checkpoints))
+		admin.POST("/checkpoints", CheckpointIssue(checkpoints, audit))
+		admin.POST("/checkpoints/:id/revoke", CheckpointRevoke(checkpoints, audit))
+		admin.GET("/notifications", NotificationsList(notifySvc))
+		admin.POST("/notifications", NotificationsSubscribe(notifySvc, audit))
+		admin.POST("/notifications/:id/delete", NotificationsUnsubscribe(notifySvc, audit))
+		admin.GET("/webhooks", WebhooksPage(hooks))
+		admin.POST("/webhooks", WebhookAdd(hooks, audit))
+		admin.POST("/webhooks/:id/delete", WebhookRemove(hooks, audit))
+		admin.POST("/webhooks/deliveries/:id/redeliver", WebhookRedeliver(hooks, audit))
+		admin.GET("/analytics", AnalyticsPage(analytics))
+		admin.GET("/analytics/export/:table", AnalyticsCSV(analytics, audit))
+		admin.GET("/export", Export(exports, audit))
+		admin.POST("/export", Export(exports, audit))
+		admin.GET("/import", ImportPage())
+		admin.POST("/import", ImportUpload(imports))
+		admin.GET("/import/:id", ImportPreview(imports))
+		admin.POST("/import/:id/commit", ImportCommit(imports, audit))
+		admin.POST("/import/:id/discard", ImportDiscard(imports, audit))
+		admin.GET("/backup", BackupPage(scheduler))
+		admin.GET("/backup/download", BackupDownload(backups, audit))
+		admin.POST("/backup/run", BackupRun(scheduler, audit))
+		admin.GET("/backup/files/:id", BackupFileDownload(scheduler, audit))
 	}
 }
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"log"
+	"mailtrackerProject/services"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultStaleDays = 30
+
+// analyticsFilter 从查询参数读取 from/to（YYYY-MM-DD）与 stale（天）
+func analyticsFilter(c *gin.Context) services.AnalyticsFilter {
+	f := services.AnalyticsFilter{StaleDays: defaultStaleDays}
+	if t, err := time.ParseInLocation("2006-01-02", c.Query("from"), time.Local); err == nil {
+		f.From = t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", c.Query("to"), time.Local); err == nil {
+		f.To = t
+	}
+	if n, err := strconv.Atoi(c.Query("stale")); err == nil && n > 0 {
+		f.StaleDays = n
+	}
+	return f
+}
+
+// AnalyticsPage GET /admin/analytics 投递统计面板
+func AnalyticsPage(analytics *services.AnalyticsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f := analyticsFilter(c)
+		rep, err := analytics.Report(f)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "analytics.html", gin.H{"error": err.Error(), "Filter": f})
+			return
+		}
+		c.HTML(http.StatusOK, "analytics.html", gin.H{
+			"Report": rep,
+			"Filter": f,
+			"From":   c.Query("from"),
+			"To":     c.Query("to"),
+		})
+	}
+}
+
+// AnalyticsCSV GET /admin/analytics/export/:table 按同样的筛选条件导出 CSV
+func AnalyticsCSV(analytics *services.AnalyticsService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		table := c.Param("table")
+		if !slices.Contains(services.AnalyticsTables, table) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown table"})
+			return
+		}
+		rep, err := analytics.Report(analyticsFilter(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditExport, Target: "analytics/" + table, Detail: "投递统计 CSV " + c.Request.URL.RawQuery})
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="analytics-`+table+"-"+time.Now().Format("20060102")+`.csv"`)
+		if err := rep.WriteCSV(c.Writer, table); err != nil {
+			log.Printf("export analytics %s: %v", table, err)
+		}
+	}
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterAuthRoutes(r *gin.Engine) {
+func RegisterAuthRoutes(r *gin.Engine, audit *services.AuditLog) {
 	r.GET("/login", func(c *gin.Context) {
 		helper.RenderHTML(c, http.StatusOK, "login.html", gin.H{"Redirect": c.Query("go")})
 	})
@@ -35,10 +35,12 @@ func RegisterAuthRoutes(r *gin.Engine) {
 		}
 
 		if subtle.ConstantTimeCompare([]byte(password), []byte(adminToken)) != 1 {
+			recordAudit(c, audit, services.AuditEvent{Action: services.AuditLoginFailed, Actor: services.AuditActorAnonymous, Detail: c.Request.UserAgent()})
 			helper.RenderHTML(c, http.StatusUnauthorized, "login.html", gin.H{"Error": "账号或密码错误"})
 			return
 		}
 
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditLogin, Actor: services.AuditActorAdmin, Detail: c.Request.UserAgent()})
 		c.SetCookie("X-Admin-Token", adminToken, 3600*24, "/", "", false, true)
 		c.Redirect(http.StatusSeeOther, target)
 	})
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"mailtrackerProject/helper"
+	"mailtrackerProject/middleware"
+	"mailtrackerProject/services"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkpointsPage 中转凭证页：签发表单与签发记录
+func checkpointsPage(c *gin.Context, checkpoints *services.CheckpointService, status int, data gin.H) {
+	data["Tokens"] = checkpoints.List()
+	c.HTML(status, "checkpoint_gen.html", data)
+}
+
+// CheckpointsList GET /admin/checkpoints
+func CheckpointsList(checkpoints *services.CheckpointService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkpointsPage(c, checkpoints, http.StatusOK, gin.H{})
+	}
+}
+
+// CheckpointIssue POST /admin/checkpoints 签发中转凭证，返回登记链接。
+// 凭证放在链接的 # 之后，不会出现在服务器与代理的访问日志或 Referer 中
+func CheckpointIssue(checkpoints *services.CheckpointService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := strings.TrimSpace(c.PostForm("name"))
+		days, _ := strconv.Atoi(c.PostForm("days"))
+		if name == "" {
+			checkpointsPage(c, checkpoints, http.StatusBadRequest, gin.H{"error": "请填写经手人名称"})
+			return
+		}
+		if days <= 0 || days > 365 {
+			checkpointsPage(c, checkpoints, http.StatusBadRequest, gin.H{"error": "有效期需在 1~365 天之间"})
+			return
+		}
+		tok, rec, err := checkpoints.Issue(name, time.Duration(days)*24*time.Hour)
+		if err != nil {
+			checkpointsPage(c, checkpoints, http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditCheckpointIssue, Target: rec.ID,
+			After: name + "，有效期至 " + rec.ExpiresAt.Format("2006-01-02 15:04")})
+		checkpointsPage(c, checkpoints, http.StatusOK, gin.H{
+			"Name":      name,
+			"ExpiresAt": rec.ExpiresAt.Format("2006-01-02 15:04"),
+			"Link":      "/checkpoint/enroll#t=" + tok,
+		})
+	}
+}
+
+// CheckpointRevoke POST /admin/checkpoints/:id/revoke 撤销中转凭证
+func CheckpointRevoke(checkpoints *services.CheckpointService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		rec, err := checkpoints.Revoke(id)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, services.ErrCheckpointNotFound) {
+				status = http.StatusNotFound
+			}
+			checkpointsPage(c, checkpoints, status, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditCheckpointRevoke, Target: id, Before: rec.Name})
+		c.Redirect(http.StatusSeeOther, "/admin/checkpoints")
+	}
+}
+
+// CheckpointEnrollPage GET /checkpoint/enroll#t= 登记页，由页面脚本从 # 之后取出凭证提交
+func CheckpointEnrollPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.HTML(http.StatusOK, "checkpoint.html", gin.H{"Enroll": true})
+	}
+}
+
+// CheckpointEnroll POST /checkpoint/enroll 经手人打开登记链接后，本设备进入中转模式
+func CheckpointEnroll(checkpoints *services.CheckpointService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tok := c.PostForm("t")
+		cl, err := checkpoints.Parse(tok)
+		if err != nil {
+			helper.RenderHTML(c, http.StatusForbidden, "view_check.html", gin.H{"error": "中转凭证无效、已过期或已被撤销"})
+			return
+		}
+		services.SetCheckpointCookie(c, tok, cl.ExpiresAt.Time)
+		c.HTML(http.StatusOK, "checkpoint.html", gin.H{"Name": cl.Name, "Enrolled": true})
+	}
+}
+
+// CheckpointLeave POST /checkpoint/leave 退出中转模式
+func CheckpointLeave() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		services.SetCheckpointCookie(c, "", time.Time{})
+		c.Redirect(http.StatusSeeOther, "/")
+	}
+}
+
+// checkpointForm 持有中转凭证的人扫码 /s/:key 时显示记录表单，而不是查询页
+func checkpointForm(c *gin.Context, entries *services.EntriesService, key string, cl *services.CheckpointClaims) {
+	st := entries.Status(key)
+	c.HTML(http.StatusOK, "checkpoint.html", gin.H{
+		"Key":     key,
+		"Name":    cl.Name,
+		"Status":  st,
+		"Allowed": services.CanTransition(st, services.StatusInTransit, services.RoleCheckpoint),
+	})
+}
+
+// PostCheckpoint POST /s/:key/checkpoint 记录一次中转事件。
+// 位置优先使用浏览器定位，拿不到时用 GeoIP 粗略估计。
+func PostCheckpoint(entries *services.EntriesService, geo *services.GeoService, checkpoints *services.CheckpointService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		cl := checkpoints.Read(c)
+		if cl == nil || middleware.IsAdmin(c) {
+			c.Redirect(http.StatusSeeOther, "/s/"+key)
+			return
+		}
+
+		ev := services.TrackingEvent{
+			Status:   services.StatusInTransit,
+			Actor:    services.RoleCheckpoint,
+			By:       cl.Name,
+			Note:     strings.TrimSpace(c.PostForm("note")),
+			Location: strings.TrimSpace(c.PostForm("location")),
+		}
+		if lat, lon, ok := parseLatLon(c.PostForm("lat"), c.PostForm("lon")); ok {
+			ev.Lat, ev.Lon = &lat, &lon
+			if ev.Location == "" {
+				ev.Location = strconv.FormatFloat(lat, 'f', 4, 64) + ", " + strconv.FormatFloat(lon, 'f', 4, 64)
+			}
+		} else if info, err := geo.Lookup(c.ClientIP()); err == nil && info != nil {
+			if ev.Location == "" {
+				ev.Location = geoLabel(info)
+			}
+			if info.HasCoords() {
+				ev.Lat, ev.Lon = &info.Latitude, &info.Longitude
+			}
+		}
+
+		if _, err := entries.Transition(key, ev); err != nil {
+			if errors.Is(err, services.ErrTransitionNotAllowed) {
+				helper.RenderHTML(c, http.StatusConflict, "view_check.html", gin.H{"Key": key, "error": "当前状态不能记录中转"})
+				return
+			}
+			log.Printf("record checkpoint %s: %v", key, err)
+			helper.RenderHTML(c, http.StatusBadRequest, "view_check.html", gin.H{"Key": key, "error": "无效的Key"})
+			return
+		}
+		c.HTML(http.StatusOK, "checkpoint.html", gin.H{"Key": key, "Name": cl.Name, "Recorded": true, "Location": ev.Location})
+	}
+}
+
+// geoLabel 城市, 省份, 国家（省略空项）
+func geoLabel(info *services.IPInfo) string {
+	var parts []string
+	for _, p := range []string{info.City, info.Region, info.Country} {
+		if p != "" && (len(parts) == 0 || parts[len(parts)-1] != p) {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func RegisterCheckpointRoutes(r *gin.Engine, entriesSvc *services.EntriesService, geoSvc *services.GeoService, checkpoints *services.CheckpointService) {
+	r.GET("/checkpoint/enroll", CheckpointEnrollPage())
+	r.POST("/checkpoint/enroll", CheckpointEnroll(checkpoints))
+	r.POST("/checkpoint/leave", CheckpointLeave())
+	r.POST("/s/:key/checkpoint", requireAvailable(entriesSvc), PostCheckpoint(entriesSvc, geoSvc, checkpoints))
+}
@@ -2,15 +2,17 @@ package controllers
 
 import (
 	"crypto/subtle"
+	"io"
 	"log"
 	"mailtrackerProject/helper"
 	"mailtrackerProject/middleware"
-	"mailtrackerProject/models"
 	"mailtrackerProject/services"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,96 +20,62 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// PostEntry create 路由
-func PostEntry(entries *services.EntriesService, files *services.FilesService, keys *services.KeysService) gin.HandlerFunc {
+// PostEntry create 路由；校验与保存逻辑在 EntryCreateService，与批量导入共用
+func PostEntry(creator *services.EntryCreateService, entries *services.EntriesService, audit *services.AuditLog) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		key := c.PostForm("entryId")
-		if !models.ValidKey(key) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key format"})
-			log.Print("invalid key format: ", key)
-			return
+		form := services.EntryForm{
+			Key:                  key,
+			RecipientName:        c.PostForm("recipientName"),
+			Remarks:              c.PostForm("remarks"),
+			OriginLocation:       c.PostForm("originLocation"),
+			PostDate:             c.PostForm("postDate"),
+			EncryptMethod:        c.PostForm("encryptMethod"),
+			EncryptPassword:      c.PostForm("encryptPassword"),
+			LookupLimitType:      c.PostForm("lookupLimitType"),
+			LookupAvailableAfter: c.PostForm("lookupLimitAvailableAfterDate"),
+			TrackingNumber:       c.PostForm("trackingNumber"),
+			Carrier:              c.PostForm("carrier"),
+			Draft:                c.PostForm("draft") == "on",
 		}
-		if _, ok := keys.Get(key); !ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "key not found"})
-			return
+		// 寄出地坐标可选，两者都有且在范围内才保存
+		if lat, lon, ok := parseLatLon(c.PostForm("originLat"), c.PostForm("originLon")); ok {
+			form.OriginLat, form.OriginLon = &lat, &lon
 		}
-
-		recipientName := strings.TrimSpace(c.PostForm("recipientName"))
-		remarks := c.PostForm("remarks")
-		originLocation := c.PostForm("originLocation")
-
-		postDate := c.PostForm("postDate")
-		lookupLimitType := c.PostForm("lookupLimitType")
-		lookupLimitAvailableAfterDate := c.PostForm("lookupLimitAvailableAfterDate")
-		//enableLookupDate := c.PostForm("enableLookupDate")
-		encryptMethod := c.PostForm("encryptMethod")
-		encryptPassword := strings.TrimSpace(c.PostForm("encryptPassword"))
+		form.OriginPlaceID, _ = strconv.Atoi(c.PostForm("originPlaceId"))
 
 		//处理图片上传
 		// 解析 multipart 表单并拿到所有同名字段 file
-		form, err := c.MultipartForm()
+		mf, err := c.MultipartForm()
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form"})
 			return
 		}
-		filesFH := form.File["files"]
-
-		if len(filesFH) > 9 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "too many images (max 9)"})
-			return
-		}
-
+		filesFH := mf.File["files"]
 		log.Printf("image count: %d", len(filesFH))
 
-		var imageIDs []string
-		for _, fh := range filesFH {
-			f, err := fh.Open()
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				return
+		// 图片说明与 files 按顺序一一对应
+		captions := mf.Value["captions"]
+		uploads := make([]services.ImageUpload, len(filesFH))
+		for i, fh := range filesFH {
+			uploads[i] = services.ImageUpload{Name: fh.Filename, Open: func() (io.ReadCloser, error) { return fh.Open() }}
+			if i < len(captions) {
+				uploads[i].Caption = captions[i]
 			}
-			log.Println("uploadedFileName", fh.Filename)
-			fileName, origName, err := files.SaveImage(key, f, fh)
-			_ = f.Close() // 立即关闭，避免在循环里 defer 堆积
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				log.Print("save image Failed", err)
-				return
-			}
-
-			imageIDs = append(imageIDs, fileName)
-			if origName != "" {
-				imageIDs = append(imageIDs, origName)
-			}
-		}
-
-		data := services.EntryData{
-			RecipientName:  &recipientName,
-			Remarks:        &remarks,
-			Images:         &imageIDs,
-			OriginLocation: &originLocation,
-			PostDate:       &postDate,
-		}
-		if encryptMethod == "password" {
-			if encryptPassword == "" {
-				encryptPassword, _ = helper.RandKey(4)
-			}
-		}
-
-		data.Encrypt = &services.Encrypt{
-			Method:   &encryptMethod,
-			Password: &encryptPassword,
 		}
 
-		data.LookupLimit = &services.LookupLimit{
-			Type:           &lookupLimitType,
-			AvailableAfter: &lookupLimitAvailableAfterDate,
-		}
-
-		if err := entries.SaveData(key, data); err != nil {
+		before, _ := entries.LoadData(key)
+		env, existed, err := creator.Create(form, uploads)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			log.Printf("create entry %s: %v", key, err)
 			return
 		}
+		action := services.AuditEntryCreate
+		if existed {
+			action = services.AuditEntryUpdate
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: action, Target: key, Before: before.AuditSummary(), After: env.AuditSummary()})
 
 		//重定向到目标页面
 		c.Redirect(http.StatusSeeOther, "/view/"+key)
@@ -115,7 +83,7 @@ func PostEntry(entries *services.EntriesService, files *services.FilesService, k
 }
 
 // GetEntryView view 展示数据页，用中间件鉴权
-func GetEntryView(entries *services.EntriesService, service *services.GeoService) gin.HandlerFunc {
+func GetEntryView(entries *services.EntriesService, service *services.GeoService, visitors *services.VisitorFilter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		key := c.Param("key")
 		admin := c.GetBool("isAdmin")
@@ -127,33 +95,36 @@ func GetEntryView(entries *services.EntriesService, service *services.GeoService
 			return
 		}
 
-		//获取访问记录
-		records, _ := entries.ReadUARecords(key)
-		for i := range records {
-			records[i].UAObj = helper.ParseUA(records[i].UA)
-			records[i].IPObj, _ = service.Lookup(records[i].IP)
-			records[i].Timestamp = records[i].Time.UnixMilli()
+		//获取第一页访问记录，更多的由页面通过 /view/:key/history 加载
+		page, err := historyPage(entries, service, visitors, key, "", historyPageSize)
+		if err != nil {
+			log.Printf("read history %s: %v", key, err)
 		}
-		grouped := map[string]map[string]string{} // baseName -> {ext: filename}
-		if data.Data.Images != nil && len(*data.Data.Images) > 0 {
-			for _, f := range *data.Data.Images {
-				ext := strings.ToLower(filepath.Ext(f)) // .heic / .webp
-				base := strings.TrimSuffix(f, ext)
-				if grouped[base] == nil {
-					grouped[base] = make(map[string]string)
-				}
-				grouped[base][ext] = f
-			}
+		visits, err := visitors.Summary(key, entries.HistoryVersion(key), func(fn func(services.HistoryRecord) bool) error {
+			return entries.ScanHistory(key, fn)
+		})
+		if err != nil {
+			log.Printf("history summary %s: %v", key, err)
 		}
-
 		if data != nil {
 			helper.RenderHTML(c, http.StatusOK, "view.html", gin.H{
-				"Key":       key,
-				"Admin":     admin,
-				"CreatedAt": data.CreatedAt.UnixMilli(),
-				"data":      data.Data,
-				"records":   records,
-				"Images":    grouped,
+				"Key":          key,
+				"Admin":        admin,
+				"Lifecycle":    entries.Lifecycle(key),
+				"CreatedAt":    data.CreatedAt.UnixMilli(),
+				"data":         data.Data,
+				"records":      page.Records,
+				"NextCursor":   page.Next,
+				"Visits":       visits,
+				"Images":       data.Data.Images.Sorted(),
+				"Status":       data.CurrentStatus(),
+				"Timeline":     data.TimelineDesc(),
+				"NextStatuses": services.NextStatuses(data.CurrentStatus(), services.RoleAdmin),
+				"Receipt":      data.Receipt,
+				"CanConfirm":   data.CanConfirmReceipt(),
+				"Today":        time.Now().Format("2006-01-02"),
+				"MapTiles":     mapTileURL(),
+				"MapCredit":    os.Getenv("MAP_ATTRIBUTION"),
 			})
 			return
 		}
@@ -161,8 +132,80 @@ func GetEntryView(entries *services.EntriesService, service *services.GeoService
 	}
 }
 
+// GetImage 图片读取；blob 在条目间共享，只允许读取该条目引用了的文件。
+// 启用水印时对外输出加水印的派生图，管理员可用 ?original=1 下载原图。
+func GetImage(entries *services.EntriesService, files *services.FilesService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		img := c.Param("imgName")
+
+		if _, ok := services.BlobHash(img); ok {
+			data, err := entries.LoadData(key)
+			if err != nil || !slices.Contains(data.ImageNames(), img) {
+				c.Status(http.StatusNotFound)
+				return
+			}
+		}
+
+		original := c.Query("original") == "1" && middleware.IsAdmin(c)
+		if files.Watermarking() && !original {
+			obj, err := files.EnsureWatermarked(key, img)
+			if err != nil {
+				log.Printf("watermark %s/%s failed: %v", key, img, err)
+				c.Status(http.StatusNotFound)
+				return
+			}
+			if u, err := files.ObjectURL(obj); err == nil && u != "" {
+				c.Redirect(http.StatusFound, u)
+				return
+			}
+			f, info, err := files.OpenObject(obj)
+			if err != nil {
+				c.Status(http.StatusNotFound)
+				return
+			}
+			defer f.Close()
+			// 水印配置可能调整，不做永久缓存
+			c.Header("Cache-Control", "private, max-age=86400")
+			serveObject(c, f, info, strings.TrimSuffix(img, filepath.Ext(img))+".jpg")
+			return
+		}
+
+		if original {
+			c.Header("Content-Disposition", "attachment; filename=\""+img+"\"")
+		}
+		// 对象存储开启直链时，图片流量不经过应用服务器
+		if u, err := files.ImageURL(img); err == nil && u != "" && !original {
+			c.Redirect(http.StatusFound, u)
+			return
+		}
+		f, info, err := files.OpenImage(key, img)
+		if err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		// 内容寻址的文件不会变化，可长期缓存
+		c.Header("Cache-Control", "private, max-age=31536000, immutable")
+		serveObject(c, f, info, img)
+	}
+}
+
+// serveObject 本地文件支持 Range/条件请求，其它后端直接转发
+func serveObject(c *gin.Context, f io.ReadCloser, info services.ObjectInfo, name string) {
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, name, info.ModTime, rs)
+		return
+	}
+	ct := mime.TypeByExtension(filepath.Ext(name))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	c.DataFromReader(http.StatusOK, info.Size, ct, f, nil)
+}
+
 // GetEntryRouteView s/:key 的路由，在这里跳转创建或查询
-func GetEntryRouteView(entries *services.EntriesService, keySrvc *services.KeysService) gin.HandlerFunc {
+func GetEntryRouteView(entries *services.EntriesService, keySrvc *services.KeysService, checkpoints *services.CheckpointService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		key := c.Param("key")
 		//检查key是否位于白名单中，否则认为伪造的key，直接跳转首页
@@ -174,6 +217,11 @@ func GetEntryRouteView(entries *services.EntriesService, keySrvc *services.KeysS
 		}
 		//判断key是否创建
 		if entries.HasData(key) { //创建了跳转到展示页
+			//中转经手人扫码：记录中转，不走查询流程
+			if cl := checkpoints.Read(c); cl != nil && !middleware.IsAdmin(c) {
+				checkpointForm(c, entries, key, cl)
+				return
+			}
 			//允许配置全局跳过验证，避免每次都要输验证码
 			noVerify := os.Getenv("DISABLE_VERIFICATION")
 			if noVerify == "true" {
@@ -193,7 +241,18 @@ func GetEntryRouteView(entries *services.EntriesService, keySrvc *services.KeysS
 	}
 }
 
-func PostLookupHandler(entries *services.EntriesService) gin.HandlerFunc {
+// lookupFailed 记录一次口令错误，触发锁定时通知寄件人
+func lookupFailed(c *gin.Context, guard *services.LookupGuard, notify *services.NotificationService, key, msg string) {
+	if guard.Fail(key, c.ClientIP()) {
+		notify.Notify(services.Notification{Event: services.EventLockout, Key: key, Fields: map[string]string{
+			"ip": c.ClientIP(),
+			"ua": c.Request.UserAgent(),
+		}})
+	}
+	helper.RenderHTML(c, http.StatusBadRequest, "view_check.html", gin.H{"Key": key, "error": msg})
+}
+
+func PostLookupHandler(entries *services.EntriesService, geo *services.GeoService, notify *services.NotificationService, guard *services.LookupGuard, hooks *services.WebhookService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		key := c.PostForm("keyID")
 		key = strings.ToUpper(key)
@@ -213,6 +272,16 @@ func PostLookupHandler(entries *services.EntriesService) gin.HandlerFunc {
 			)
 			return
 		}
+		if entryUnavailable(c, entries, key) {
+			return
+		}
+
+		//连续输错被锁定
+		if until := guard.Locked(key, c.ClientIP()); !until.IsZero() {
+			helper.RenderHTML(c, http.StatusTooManyRequests, "view_check.html",
+				gin.H{"Key": key, "error": "尝试次数过多，请在 " + until.Format("15:04") + " 后再试"})
+			return
+		}
 
 		//鉴权
 		encrypt := entry.Data.Encrypt
@@ -220,21 +289,18 @@ func PostLookupHandler(entries *services.EntriesService) gin.HandlerFunc {
 			method := encrypt.Method
 			if method != nil {
 				//验证收件人
-				if *method == "recipient" {
+				if *method == services.EncryptRecipient {
 					name := entry.Data.RecipientName
 					if name != nil && subtle.ConstantTimeCompare([]byte(helper.NormalizeString(formPassword)), []byte(helper.NormalizeString(*name))) != 1 {
-						helper.RenderHTML(c, http.StatusBadRequest, "view_check.html",
-							gin.H{"Key": key, "error": "收件人核验失败，请检查输入是否正确（大小写、空格？）"})
+						lookupFailed(c, guard, notify, key, "收件人核验失败，请检查输入是否正确（大小写、空格？）")
 						return
 					}
 				}
-				if *method == "password" {
+				if *method == services.EncryptPassword {
 					passwd := encrypt.Password
 					if passwd != nil {
 						if subtle.ConstantTimeCompare([]byte(*passwd), []byte(formPassword)) != 1 {
-							helper.RenderHTML(c, http.StatusBadRequest, "view_check.html",
-								gin.H{"Key": key, "error": "密码核验失败，请检查输入是否正确（大小写、空格？）"})
-
+							lookupFailed(c, guard, notify, key, "密码核验失败，请检查输入是否正确（大小写、空格？）")
 							return
 						}
 					}
@@ -242,13 +308,29 @@ func PostLookupHandler(entries *services.EntriesService) gin.HandlerFunc {
 			}
 		}
 
+		guard.Succeed(key, c.ClientIP())
+
 		//过鉴权，在这里写日志？
 		//不记录管理员查询 todo 可以改成表单
 		if !middleware.IsAdmin(c) {
 			// Record UA only if history.json exists for this key
 			ua := c.Request.UserAgent()
 			ip := c.ClientIP()
-			_ = entries.RecorduaNewlinejson(key, services.HistoryRecord{Time: time.Now(), UA: ua, IP: ip})
+			rec := services.HistoryRecord{Time: time.Now(), UA: ua, IP: ip}
+			geo.Enrich(&rec)
+			entries.ApplyPrivacy(&rec) // 通知与 Webhook 中的 IP 与落盘的一致
+			ip = rec.IP
+			first, err := entries.RecorduaNewlinejson(key, rec)
+			if err != nil {
+				log.Printf("record history %s: %v", key, err)
+			}
+			hooks.Emit(services.WebhookEntryViewed, gin.H{"key": key, "record": rec})
+
+			event := services.EventLookup
+			if first {
+				event = services.EventFirstView
+			}
+			notify.Notify(services.Notification{Event: event, Key: key, Fields: map[string]string{"ip": ip, "ua": ua}})
 		}
 
 		// ========== JWT：读取 -> 解析 -> 追加 -> 回写 ==========
@@ -287,11 +369,18 @@ func RegisterEntryRoutes(r *gin.Engine,
 	fileSvc *services.FilesService,
 	keysSvc *services.KeysService,
 	geoSvc *services.GeoService,
+	notifySvc *services.NotificationService,
+	guard *services.LookupGuard,
+	hooks *services.WebhookService,
+	visitors *services.VisitorFilter,
+	creator *services.EntryCreateService,
+	checkpoints *services.CheckpointService,
+	audit *services.AuditLog,
 ) {
 	// create 页面
 	createHandler := func(c *gin.Context) {
 		key := c.Param("key")
-		c.HTML(http.StatusOK, "create.html", gin.H{"Key": key})
+		c.HTML(http.StatusOK, "create.html", gin.H{"Key": key, "Carriers": services.Carriers})
 	}
 	r.GET("/create", middleware.RequireLogin(), createHandler)
 	r.GET("/create/:key", middleware.RequireLogin(), createHandler)
@@ -302,18 +391,12 @@ func RegisterEntryRoutes(r *gin.Engine,
 	})
 
 	// 图片
-	r.GET("/img/:key/:imgName", func(c *gin.Context) {
-		key := c.Param("key")
-		img := c.Param("imgName")
-		base := filepath.Join(".", "data", "entries")
-		abs := filepath.Join(base, key, "images", img)
-		c.File(abs)
-	})
+	r.GET("/img/:key/:imgName", requireAvailable(entriesSvc), GetImage(entriesSvc, fileSvc))
 
 	//二维码 短链落地页
-	r.GET("/s/:key", GetEntryRouteView(entriesSvc, keysSvc))
+	r.GET("/s/:key", requireAvailable(entriesSvc), GetEntryRouteView(entriesSvc, keysSvc, checkpoints))
 	//创建表单提交
-	r.POST("/entry", PostEntry(entriesSvc, fileSvc, keysSvc))
+	r.POST("/entry", PostEntry(creator, entriesSvc, audit))
 
 	//查询页，没有密码时要求用户输入
 	viewCheckHandler := func(c *gin.Context) {
@@ -322,8 +405,8 @@ func RegisterEntryRoutes(r *gin.Engine,
 		entry, _ := entriesSvc.LoadData(key)
 		if entry != nil {
 			if entry.Data.Encrypt.Method != nil {
-				if *entry.Data.Encrypt.Method == "recipient" {
-					helper.RenderHTML(c, http.StatusOK, "view_check.html", gin.H{"Key": key, "EncryptType": "recipient"})
+				if *entry.Data.Encrypt.Method == services.EncryptRecipient {
+					helper.RenderHTML(c, http.StatusOK, "view_check.html", gin.H{"Key": key, "EncryptType": services.EncryptRecipient})
 					return
 				}
 			}
@@ -331,7 +414,7 @@ func RegisterEntryRoutes(r *gin.Engine,
 		helper.RenderHTML(c, http.StatusOK, "view_check.html", gin.H{"Key": key})
 	}
 	r.GET("/lookup/", viewCheckHandler)
-	r.GET("/lookup/:key", viewCheckHandler)
+	r.GET("/lookup/:key", requireAvailable(entriesSvc), viewCheckHandler)
 
 	//查询表单提交点
 	r.POST("/lookup/", middleware.TurnstileGuard(middleware.TurnstileConfig{
@@ -343,32 +426,66 @@ func RegisterEntryRoutes(r *gin.Engine,
 			// 失败统一回到验证页（带上 SiteKey）
 			helper.RenderHTML(c, http.StatusBadRequest, "view_check.html", gin.H{"error": "验证码核验失败，请重试。"})
 			return
-		}}), PostLookupHandler(entriesSvc))
+		}}), PostLookupHandler(entriesSvc, geoSvc, notifySvc, guard, hooks))
 
 	//视图实际加载页
-	r.GET("/view/:key/",
-		//jwt鉴权中间件
-		func(c *gin.Context) {
-			key := c.Param("key")
+	r.GET("/view/:key/", requireAvailable(entriesSvc), requireViewAccess(), GetEntryView(entriesSvc, geoSvc, visitors))
+	//访问记录分页
+	r.GET("/view/:key/history", requireAvailable(entriesSvc), requireViewAccess(), GetHistory(entriesSvc, geoSvc, visitors))
+	//收件人确认收货
+	r.POST("/view/:key/receipt", requireAvailable(entriesSvc), requireViewAccess(), PostReceipt(entriesSvc, fileSvc, notifySvc))
 
-			noVerify := os.Getenv("DISABLE_VERIFICATION")
-			//非管理才鉴权有无jwt
-			if !middleware.IsAdmin(c) && noVerify != "true" {
-				tok := services.ReadTokenFromRequest(c)
-				claims, err := services.ParseClaims(tok)
-				if err != nil || claims == nil {
-					helper.RenderHTML(c, http.StatusForbidden, "view_check.html", gin.H{"error": "无访问权限1", "Key": key})
-					c.Abort()
-					return
-				}
-				log.Println("availbleKeys:", claims.AllowKeyList)
-				if !slices.Contains(claims.AllowKeyList, key) {
-					helper.RenderHTML(c, http.StatusForbidden, "view_check.html", gin.H{"error": "无访问权限2", "Key": key})
-					c.Abort()
-					return
-				}
-			}
-			c.Next()
-		}, GetEntryView(entriesSvc, geoSvc))
+}
 
+// entryUnavailable 已归档或在回收站中的条目对非管理员不可见，返回 true 时已输出错误页
+func entryUnavailable(c *gin.Context, entries *services.EntriesService, key string) bool {
+	if middleware.IsAdmin(c) {
+		return false
+	}
+	switch entries.Lifecycle(key) {
+	case services.LifecycleArchived:
+		helper.RenderHTML(c, http.StatusGone, "view_check.html", gin.H{"Key": key, "error": "该邮件已归档，无法查询"})
+		return true
+	case services.LifecycleDeleted:
+		helper.RenderHTML(c, http.StatusNotFound, "view_check.html", gin.H{"Key": key, "error": "ID不存在，请检查输入是否有误"})
+		return true
+	}
+	return false
+}
+
+// requireAvailable 路径中 :key 对应的条目已归档或已删除时中止请求
+func requireAvailable(entries *services.EntriesService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if entryUnavailable(c, entries, c.Param("key")) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireViewAccess jwt鉴权中间件，查看页与收件人回执共用
+func requireViewAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+
+		noVerify := os.Getenv("DISABLE_VERIFICATION")
+		//非管理才鉴权有无jwt
+		if !middleware.IsAdmin(c) && noVerify != "true" {
+			tok := services.ReadTokenFromRequest(c)
+			claims, err := services.ParseClaims(tok)
+			if err != nil || claims == nil {
+				helper.RenderHTML(c, http.StatusForbidden, "view_check.html", gin.H{"error": "无访问权限1", "Key": key})
+				c.Abort()
+				return
+			}
+			log.Println("availbleKeys:", claims.AllowKeyList)
+			if !slices.Contains(claims.AllowKeyList, key) {
+				helper.RenderHTML(c, http.StatusForbidden, "view_check.html", gin.H{"error": "无访问权限2", "Key": key})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
 }
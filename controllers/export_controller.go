@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"mailtrackerProject/models"
+	"mailtrackerProject/services"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Export GET|POST /admin/export 导出选中条目（keys，可多值或逗号分隔；为空导出全部）。
+// format 为 csv/jsonl/xlsx，tables 为 entries/history
+func Export(exports *services.ExportService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_ = c.Request.ParseMultipartForm(1 << 20)
+		form := c.Request.Form
+
+		var keys []string
+		for _, v := range form["keys"] {
+			for _, k := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' }) {
+				if models.ValidKey(k) && !slices.Contains(keys, k) {
+					keys = append(keys, k)
+				}
+			}
+		}
+		tables := form["tables"]
+		if len(tables) == 0 {
+			tables = []string{services.ExportTableEntries}
+		}
+		opt := services.ExportOptions{
+			Format:  form.Get("format"),
+			Keys:    keys,
+			Entries: slices.Contains(tables, services.ExportTableEntries),
+			History: slices.Contains(tables, services.ExportTableHistory),
+		}
+		if opt.Format == "" {
+			opt.Format = services.ExportCSV
+		}
+		if err := opt.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		scope := "全部条目"
+		if len(keys) > 0 {
+			scope = fmt.Sprintf("%d 个条目", len(keys))
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditExport, Target: strings.Join(keys, ","),
+			Detail: fmt.Sprintf("格式 %s，表 %s，%s", opt.Format, strings.Join(tables, ","), scope)})
+
+		name := "export-" + time.Now().Format("20060102-150405")
+		if opt.Format == services.ExportCSV {
+			name += "-" + tables[0]
+		}
+		c.Header("Content-Type", opt.ContentType())
+		c.Header("Content-Disposition", `attachment; filename="`+name+"."+opt.Format+`"`)
+		c.Header("Cache-Control", "no-store")
+		c.Status(http.StatusOK)
+		// 已经开始输出，出错只能记日志，下载到的文件会不完整
+		if err := exports.Export(c.Writer, opt); err != nil {
+			log.Printf("export %s: %v", opt.Format, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"mailtrackerProject/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	historyPageSize    = 20
+	historyMaxPageSize = 200
+)
+
+// historyPage 读取一页访问记录：补全旧记录的 GeoIP/UA，并结合前面的记录判断爬虫与重复查看
+func historyPage(entries *services.EntriesService, geo *services.GeoService, visitors *services.VisitorFilter,
+	key, cursor string, limit int) (services.HistoryPage, error) {
+	page, err := entries.HistoryPage(key, cursor, limit, visitors.Window())
+	if err != nil {
+		return page, err
+	}
+	all := append(page.Records, page.Context...)
+	for i := range all {
+		geo.Enrich(&all[i])
+		all[i].Timestamp = all[i].Time.UnixMilli()
+	}
+	visitors.Classify(all)
+	page.Records = all[:len(page.Records)]
+	return page, nil
+}
+
+// GetHistory GET /view/:key/history?cursor=&limit= 加载更多访问记录
+func GetHistory(entries *services.EntriesService, geo *services.GeoService, visitors *services.VisitorFilter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		if !entries.HasData(key) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "entry not found"})
+			return
+		}
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 {
+			limit = historyPageSize
+		}
+		limit = min(limit, historyMaxPageSize)
+
+		page, err := historyPage(entries, geo, visitors, key, c.Query("cursor"), limit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		records := make([]gin.H, 0, len(page.Records))
+		for _, rec := range page.Records {
+			records = append(records, gin.H{
+				"time":   rec.Timestamp,
+				"ip":     rec.IP,
+				"ua":     rec.UA,
+				"agent":  rec.UAObj,
+				"geo":    rec.IPObj,
+				"hidden": rec.Hidden,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"records": records, "next": page.Next})
+	}
+}
+
+// VisitorsErase POST /admin/entries/:key/visitors/erase 删除条目的全部访问记录
+func VisitorsErase(entries *services.EntriesService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		if !entries.HasData(key) {
+			c.String(http.StatusNotFound, "entry not found")
+			return
+		}
+		n, err := entries.EraseVisitors(key)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		log.Printf("erased %d visitor records of %s", n, key)
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditEntryErase, Target: key, Detail: fmt.Sprintf("删除 %d 条访问记录", n)})
+		c.Redirect(http.StatusSeeOther, "/view/"+key)
+	}
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailtrackerProject/services"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImportPage GET /admin/import 上传页
+func ImportPage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.HTML(http.StatusOK, "import.html", gin.H{})
+	}
+}
+
+// ImportUpload POST /admin/import 暂存 CSV（csv）与可选的图片 ZIP（zip），然后跳到预览
+func ImportUpload(imports *services.ImportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		csvFH, err := c.FormFile("csv")
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "import.html", gin.H{"error": "请选择 CSV 文件"})
+			return
+		}
+		csvFile, err := csvFH.Open()
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "import.html", gin.H{"error": err.Error()})
+			return
+		}
+		defer csvFile.Close()
+
+		var zipFile io.Reader
+		if zipFH, err := c.FormFile("zip"); err == nil {
+			var f multipart.File
+			if f, err = zipFH.Open(); err != nil {
+				c.HTML(http.StatusBadRequest, "import.html", gin.H{"error": err.Error()})
+				return
+			}
+			defer f.Close()
+			zipFile = f
+		}
+
+		id, err := imports.Stage(csvFile, zipFile)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "import.html", gin.H{"error": err.Error()})
+			return
+		}
+		target := "/admin/import/" + id
+		if c.PostForm("overwrite") == "on" {
+			target += "?overwrite=1"
+		}
+		c.Redirect(http.StatusSeeOther, target)
+	}
+}
+
+// ImportPreview GET /admin/import/:id 逐行校验结果，不写入数据
+func ImportPreview(imports *services.ImportService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rep, err := imports.Preview(c.Param("id"), c.Query("overwrite") == "1")
+		if err != nil {
+			c.HTML(importStatus(err), "import.html", gin.H{"error": err.Error()})
+			return
+		}
+		c.HTML(http.StatusOK, "import.html", gin.H{"Report": rep})
+	}
+}
+
+// ImportCommit POST /admin/import/:id/commit 创建校验通过的行并展示每行结果
+func ImportCommit(imports *services.ImportService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		rep, err := imports.Commit(id, c.PostForm("overwrite") == "1")
+		if err != nil {
+			c.HTML(importStatus(err), "import.html", gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("import %s: created %d, updated %d, failed %d, skipped %d", id, rep.Created, rep.Updated, rep.Failed, rep.Invalid)
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditImport, Target: id,
+			Detail: fmt.Sprintf("新建 %d，覆盖 %d，失败 %d，跳过 %d", rep.Created, rep.Updated, rep.Failed, rep.Invalid)})
+		c.HTML(http.StatusOK, "import.html", gin.H{"Report": rep})
+	}
+}
+
+// ImportDiscard POST /admin/import/:id/discard 放弃暂存的导入
+func ImportDiscard(imports *services.ImportService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		err := imports.Discard(id)
+		if errors.Is(err, services.ErrImportNotFound) {
+			c.Redirect(http.StatusSeeOther, "/admin/import")
+			return
+		}
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "import.html", gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditImportDiscard, Target: id})
+		c.Redirect(http.StatusSeeOther, "/admin/import")
+	}
+}
+
+func importStatus(err error) int {
+	if errors.Is(err, services.ErrImportNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
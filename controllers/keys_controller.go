@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"mailtrackerProject/services"
 	"net/http"
 	"strconv"
@@ -9,7 +11,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func KeysGenerate(keys *services.KeysService) gin.HandlerFunc {
+func KeysGenerate(keys *services.KeysService, hooks *services.WebhookService, audit *services.AuditLog) gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 
@@ -32,6 +34,11 @@ func KeysGenerate(keys *services.KeysService) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		// 一次可生成上百万个 key，只发送批次信息，不把整批 key 写进投递队列
+		hooks.Emit(services.WebhookKeyGenerated, gin.H{"batch": out[0].Batch, "count": len(out), "length": length,
+			"comment": comment, "created_at": out[0].CreatedAt})
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditKeyGenerate, Target: out[0].Batch,
+			Detail: fmt.Sprintf("生成 %d 个，长度 %d，备注：%s", len(out), length, comment)})
 		ids := make([]string, 0, len(out))
 		for _, item := range out {
 			ids = append(ids, item.Key)
@@ -67,10 +74,15 @@ func KeyStatus(keys *services.KeysService, entries *services.EntriesService) gin
 		}
 		used := entries.HasData(k)
 		s := "available"
+		resp := gin.H{"key": k, "created_at": info.CreatedAt.Format(time.RFC3339), "lifecycle": info.Lifecycle()}
 		if used {
 			s = "used"
+			st := entries.Status(k)
+			resp["entry_status"] = st
+			resp["entry_status_label"] = st.Label()
 		}
-		c.JSON(http.StatusOK, gin.H{"key": k, "status": s, "created_at": info.CreatedAt.Format(time.RFC3339)})
+		resp["status"] = s
+		c.JSON(http.StatusOK, resp)
 	}
 }
 
@@ -82,10 +94,12 @@ func KeysList(keys *services.KeysService, entries *services.EntriesService) gin.
 			Key       string
 			CreatedAt string
 			Used      bool
+			Status    services.EntryStatus
 		}
 
 		used := make([]KeyStatus, 0)
 		unused := make([]KeyStatus, 0)
+		archived := make([]KeyStatus, 0)
 
 		for _, ki := range all {
 			ks := KeyStatus{
@@ -94,16 +108,124 @@ func KeysList(keys *services.KeysService, entries *services.EntriesService) gin.
 				Used:      entries.HasData(ki.Key),
 			}
 			if ks.Used {
+				ks.Status = entries.Status(ki.Key)
+			}
+			switch {
+			case ki.ArchivedAt != nil:
+				archived = append(archived, ks)
+			case ks.Used:
 				used = append(used, ks)
-			} else {
+			default:
 				unused = append(unused, ks)
 			}
 		}
 
 		// 输出给模板
 		c.HTML(http.StatusOK, "key_view.html", gin.H{
-			"usedKeys":   used,
-			"unusedKeys": unused,
+			"usedKeys":     used,
+			"unusedKeys":   unused,
+			"archivedKeys": archived,
+			"showArchived": c.Query("archived") == "1",
 		})
 	}
 }
+
+// KeyRevoke POST /admin/keys/:key/revoke 作废未使用的 key
+func KeyRevoke(keys *services.KeysService, entries *services.EntriesService, hooks *services.WebhookService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k := c.Param("key")
+		info, err := keys.RevokeUnused(k, entries.HasData)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, services.ErrKeyUsed):
+				status = http.StatusConflict
+			case errors.Is(err, services.ErrKeyNotFound):
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		hooks.Emit(services.WebhookKeyRevoked, gin.H{"key": info})
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditKeyRevoke, Target: k, Detail: "备注：" + info.Comment})
+		c.Redirect(http.StatusSeeOther, "/admin/keys")
+	}
+}
+
+// keyLifecycleAction POST /admin/keys/:key/{archive,unarchive,delete,restore} 修改 key 的生命周期并记入审计日志
+func keyLifecycleAction(audit *services.AuditLog, action string, fn func(key string) (services.KeyInfo, error), back string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k := c.Param("key")
+		if _, err := fn(k); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, services.ErrKeyNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: action, Target: k})
+		c.Redirect(http.StatusSeeOther, back)
+	}
+}
+
+func KeyArchive(lifecycle *services.LifecycleService, audit *services.AuditLog) gin.HandlerFunc {
+	return keyLifecycleAction(audit, services.AuditKeyArchive, lifecycle.Archive, "/admin/keys")
+}
+
+func KeyUnarchive(lifecycle *services.LifecycleService, audit *services.AuditLog) gin.HandlerFunc {
+	return keyLifecycleAction(audit, services.AuditKeyUnarchive, lifecycle.Unarchive, "/admin/keys?archived=1")
+}
+
+func KeyDelete(lifecycle *services.LifecycleService, audit *services.AuditLog) gin.HandlerFunc {
+	return keyLifecycleAction(audit, services.AuditKeyDelete, lifecycle.Delete, "/admin/keys")
+}
+
+func KeyRestore(lifecycle *services.LifecycleService, audit *services.AuditLog) gin.HandlerFunc {
+	return keyLifecycleAction(audit, services.AuditKeyRestore, lifecycle.Restore, "/admin/trash")
+}
+
+// KeyPurge POST /admin/keys/:key/purge 彻底删除回收站中的 key 及其条目
+func KeyPurge(lifecycle *services.LifecycleService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k := c.Param("key")
+		if err := lifecycle.Purge(k); err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, services.ErrKeyNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, services.ErrNotInTrash):
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditKeyPurge, Target: k})
+		c.Redirect(http.StatusSeeOther, "/admin/trash")
+	}
+}
+
+// TrashList GET /admin/trash 回收站
+func TrashList(lifecycle *services.LifecycleService, keys *services.KeysService, entries *services.EntriesService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		type TrashItem struct {
+			services.KeyInfo
+			Used    bool
+			Status  services.EntryStatus
+			PurgeAt time.Time
+		}
+		items := make([]TrashItem, 0)
+		for _, ki := range keys.Trash() {
+			it := TrashItem{KeyInfo: ki, Used: entries.HasData(ki.Key), PurgeAt: lifecycle.PurgeAt(ki)}
+			if it.Used {
+				it.Status = entries.Status(ki.Key)
+			}
+			items = append(items, it)
+		}
+		window := lifecycle.Window().String()
+		if d := lifecycle.Window(); d%(24*time.Hour) == 0 {
+			window = fmt.Sprintf("%d 天", d/(24*time.Hour))
+		}
+		c.HTML(http.StatusOK, "trash.html", gin.H{"Items": items, "Window": window})
+	}
+}
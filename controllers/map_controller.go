@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"mailtrackerProject/services"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseLatLon 解析表单里的经纬度，缺失或越界时 ok 为 false
+func parseLatLon(latStr, lonStr string) (lat, lon float64, ok bool) {
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// mapTileURL 底图瓦片地址模板（{z}/{x}/{y}）。
+// MAP_TILE_URL 指向自建瓦片服务；只设置 MAP_TILES_DIR 时由本服务在 /tiles 下提供目录里的瓦片。
+// 都没有配置时地图只画经纬网格。
+func mapTileURL() string {
+	if u := os.Getenv("MAP_TILE_URL"); u != "" {
+		return u
+	}
+	if os.Getenv("MAP_TILES_DIR") != "" {
+		ext := os.Getenv("MAP_TILE_EXT")
+		if ext == "" {
+			ext = "png"
+		}
+		return "/tiles/{z}/{x}/{y}." + ext
+	}
+	return ""
+}
+
+// GetEntryGeo GET /view/:key/geo.json 条目地图数据（GeoJSON）
+func GetEntryGeo(entries *services.EntriesService, geo *services.GeoService, visitors *services.VisitorFilter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		env, err := entries.LoadData(key)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "entry not found"})
+			return
+		}
+
+		// 按时间正序取查看者，爬虫不上图
+		var viewers []services.HistoryRecord
+		err = entries.ScanHistory(key, func(rec services.HistoryRecord) bool {
+			geo.Enrich(&rec)
+			if visitors.IsBot(&rec) || !rec.IPObj.HasCoords() {
+				return true
+			}
+			viewers = append(viewers, rec)
+			return len(viewers) < services.MaxGeoViewers
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Cache-Control", "private, no-cache")
+		c.Header("Content-Type", "application/geo+json; charset=utf-8")
+		c.JSON(http.StatusOK, services.EntryGeoJSON(env, viewers))
+	}
+}
+
+// RegisterMapRoutes 地图数据与自托管瓦片
+func RegisterMapRoutes(r *gin.Engine, entriesSvc *services.EntriesService, geoSvc *services.GeoService, visitors *services.VisitorFilter) {
+	r.GET("/view/:key/geo.json", requireAvailable(entriesSvc), requireViewAccess(), GetEntryGeo(entriesSvc, geoSvc, visitors))
+	if dir := os.Getenv("MAP_TILES_DIR"); dir != "" && os.Getenv("MAP_TILE_URL") == "" {
+		r.Static("/tiles", dir)
+	}
+}
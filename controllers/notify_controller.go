@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"mailtrackerProject/models"
+	"mailtrackerProject/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func notificationsPage(c *gin.Context, notify *services.NotificationService, status int, errMsg string) {
+	c.HTML(status, "notifications.html", gin.H{
+		"Subscriptions": notify.List(),
+		"Channels":      notify.Channels(),
+		"Events":        services.AllNotifyEvents,
+		"error":         errMsg,
+	})
+}
+
+// NotificationsList GET /admin/notifications 订阅列表
+func NotificationsList(notify *services.NotificationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		notificationsPage(c, notify, http.StatusOK, "")
+	}
+}
+
+// NotificationsSubscribe POST /admin/notifications 新增订阅
+func NotificationsSubscribe(notify *services.NotificationService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sub := services.Subscription{
+			Channel: c.PostForm("channel"),
+			Target:  strings.TrimSpace(c.PostForm("target")),
+		}
+		for _, e := range c.PostFormArray("events") {
+			sub.Events = append(sub.Events, services.NotifyEvent(e))
+		}
+		// 多个 key 用逗号或空白分隔，留空表示全部条目
+		for _, k := range strings.FieldsFunc(c.PostForm("keys"), func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+			k = strings.ToUpper(strings.TrimSpace(k))
+			if !models.ValidKey(k) {
+				notificationsPage(c, notify, http.StatusBadRequest, "无效的Key："+k)
+				return
+			}
+			sub.Keys = append(sub.Keys, k)
+		}
+		sub, err := notify.Subscribe(sub)
+		if err != nil {
+			notificationsPage(c, notify, http.StatusBadRequest, err.Error())
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditNotifyAdd, Target: sub.ID, After: subscriptionSummary(sub)})
+		c.Redirect(http.StatusSeeOther, "/admin/notifications")
+	}
+}
+
+// NotificationsUnsubscribe POST /admin/notifications/:id/delete 删除订阅
+func NotificationsUnsubscribe(notify *services.NotificationService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		before := ""
+		for _, sub := range notify.List() {
+			if sub.ID == id {
+				before = subscriptionSummary(sub)
+			}
+		}
+		if err := notify.Unsubscribe(id); err != nil {
+			notificationsPage(c, notify, http.StatusNotFound, err.Error())
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditNotifyRemove, Target: id, Before: before})
+		c.Redirect(http.StatusSeeOther, "/admin/notifications")
+	}
+}
+
+// subscriptionSummary 订阅的摘要，用于审计日志
+func subscriptionSummary(sub services.Subscription) string {
+	events := make([]string, len(sub.Events))
+	for i, e := range sub.Events {
+		events[i] = string(e)
+	}
+	keys := "全部条目"
+	if len(sub.Keys) > 0 {
+		keys = strings.Join(sub.Keys, ",")
+	}
+	return sub.Channel + " " + sub.Target + "; 事件=" + strings.Join(events, ",") + "; " + keys
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"mailtrackerProject/middleware"
+	"mailtrackerProject/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const placeSuggestLimit = 10
+
+// GetPlaces GET /api/places?q= 发件城市自动补全
+func GetPlaces(places *services.GazetteerService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 || limit > placeSuggestLimit {
+			limit = placeSuggestLimit
+		}
+		matches := places.Search(c.Query("q"), limit)
+		if matches == nil {
+			matches = []services.PlaceMatch{}
+		}
+		c.JSON(http.StatusOK, gin.H{"places": matches})
+	}
+}
+
+// RegisterPlaceRoutes 地名库接口，只给创建页使用
+func RegisterPlaceRoutes(r *gin.Engine, places *services.GazetteerService) {
+	r.GET("/api/places", middleware.RequireLogin(), GetPlaces(places))
+}
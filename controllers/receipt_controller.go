@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"mailtrackerProject/helper"
+	"mailtrackerProject/services"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PostReceipt POST /view/:key/receipt 收件人确认收货：收到日期、留言、到货照片
+func PostReceipt(entries *services.EntriesService, files *services.FilesService, notify *services.NotificationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		fail := func(status int, msg string) {
+			helper.RenderHTML(c, status, "view_check.html", gin.H{"Key": key, "error": msg})
+		}
+
+		env, err := entries.LoadData(key)
+		if err != nil {
+			fail(http.StatusBadRequest, "无效的Key")
+			return
+		}
+		if !env.CanConfirmReceipt() {
+			fail(http.StatusConflict, "该邮件已确认收货或当前状态无法确认")
+			return
+		}
+
+		arrival := strings.TrimSpace(c.PostForm("arrivalDate"))
+		if _, err := time.Parse("2006-01-02", arrival); err != nil {
+			fail(http.StatusBadRequest, "收到日期格式不正确")
+			return
+		}
+		message := strings.TrimSpace(c.PostForm("message"))
+		if utf8.RuneCountInString(message) > 500 {
+			fail(http.StatusBadRequest, "留言最多 500 字")
+			return
+		}
+		receipt := services.Receipt{ArrivalDate: arrival, Message: message}
+
+		// 照片可选，与创建条目走同一套图片处理
+		if fh, err := c.FormFile("photo"); err == nil {
+			f, err := fh.Open()
+			if err != nil {
+				fail(http.StatusBadRequest, err.Error())
+				return
+			}
+			rec, err := files.SaveImage(key, f, fh)
+			if err != nil {
+				log.Print("save receipt photo failed: ", err)
+				fail(http.StatusBadRequest, "照片上传失败："+err.Error())
+				return
+			}
+			receipt.Photo = &rec
+			defer files.Settle(services.EntryData{Images: services.ImageList{rec}}.ImageNames())
+		}
+
+		if _, err := entries.ConfirmReceipt(key, receipt); err != nil {
+			if errors.Is(err, services.ErrReceiptExists) || errors.Is(err, services.ErrTransitionNotAllowed) {
+				fail(http.StatusConflict, "该邮件已确认收货或当前状态无法确认")
+				return
+			}
+			fail(http.StatusInternalServerError, "保存失败")
+			return
+		}
+		fields := map[string]string{"arrivalDate": arrival}
+		if message != "" {
+			fields["message"] = message
+		}
+		if receipt.Photo != nil {
+			fields["photo"] = "/img/" + key + "/" + receipt.Photo.File
+		}
+		notify.Notify(services.Notification{Event: services.EventReceipt, Key: key, Fields: fields})
+		c.Redirect(http.StatusSeeOther, "/view/"+key)
+	}
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"mailtrackerProject/services"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const searchPageSize = 50
+
+// searchParams 搜索页的查询参数，翻页与排序链接原样保留
+var searchParams = []string{"key", "recipient", "remarks", "origin", "from", "to", "status", "batch", "viewed", "lifecycle", "sort", "order"}
+
+// EntrySearch GET /admin/entries?key=&recipient=&remarks=&origin=&from=&to=&status=&batch=&viewed=&lifecycle=&sort=&order=&page=
+// 按条件搜索条目，默认按寄出日期从新到旧
+func EntrySearch(entries *services.EntriesService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := services.EntrySearch{
+			Key:       strings.TrimSpace(c.Query("key")),
+			Recipient: strings.TrimSpace(c.Query("recipient")),
+			Remarks:   strings.TrimSpace(c.Query("remarks")),
+			Origin:    strings.TrimSpace(c.Query("origin")),
+			Batch:     c.Query("batch"),
+			Sort:      c.Query("sort"),
+			Desc:      c.Query("order") != "asc",
+		}
+		if t, err := time.ParseInLocation("2006-01-02", c.Query("from"), time.Local); err == nil {
+			q.From = t
+		}
+		if t, err := time.ParseInLocation("2006-01-02", c.Query("to"), time.Local); err == nil {
+			q.To = t
+		}
+		if st, ok := services.ParseStatus(c.Query("status")); ok {
+			q.Status = st
+		}
+		switch c.Query("viewed") {
+		case "yes":
+			viewed := true
+			q.Viewed = &viewed
+		case "no":
+			viewed := false
+			q.Viewed = &viewed
+		}
+		switch lc := services.Lifecycle(c.Query("lifecycle")); lc {
+		case services.LifecycleActive, services.LifecycleArchived:
+			q.Lifecycle = lc
+		}
+		if !slices.Contains(services.SearchSorts, q.Sort) {
+			q.Sort = services.SortPosted
+		}
+		page, _ := strconv.Atoi(c.Query("page"))
+		page = max(page, 1)
+
+		hits, total := entries.Search(q, (page-1)*searchPageSize, searchPageSize)
+
+		query := gin.H{}
+		values := url.Values{}
+		for _, name := range searchParams {
+			query[name] = c.Query(name)
+			if v := c.Query(name); v != "" {
+				values.Set(name, v)
+			}
+		}
+		query["sort"] = q.Sort
+		// link 返回保留筛选条件的链接，可覆盖部分参数
+		link := func(set map[string]string) string {
+			v := maps.Clone(values)
+			for name, val := range set {
+				v.Set(name, val)
+			}
+			return "/admin/entries?" + v.Encode()
+		}
+		// 点击列标题按该列排序，再次点击切换方向
+		sorts := gin.H{}
+		for _, s := range services.SearchSorts {
+			order := "desc"
+			if s == q.Sort && q.Desc {
+				order = "asc"
+			}
+			sorts[s] = link(map[string]string{"sort": s, "order": order, "page": "1"})
+		}
+
+		data := gin.H{
+			"Hits":       hits,
+			"Total":      total,
+			"Page":       page,
+			"Query":      query,
+			"Desc":       q.Desc,
+			"Sorts":      sorts,
+			"Statuses":   services.AllStatuses,
+			"Batches":    entries.Batches(),
+			"Lifecycles": []services.Lifecycle{services.LifecycleActive, services.LifecycleArchived},
+		}
+		if page > 1 {
+			data["Prev"] = link(map[string]string{"page": strconv.Itoa(page - 1)})
+		}
+		if page*searchPageSize < total {
+			data["Next"] = link(map[string]string{"page": strconv.Itoa(page + 1)})
+		}
+		c.HTML(http.StatusOK, "search.html", data)
+	}
+}
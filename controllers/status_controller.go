@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mailtrackerProject/helper"
+	"mailtrackerProject/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EntryStatusUpdate POST /admin/entries/:key/status 管理员手动推进投递状态
+func EntryStatusUpdate(entries *services.EntriesService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		to, ok := services.ParseStatus(c.PostForm("status"))
+		if !ok {
+			helper.RenderHTML(c, http.StatusBadRequest, "view_check.html", gin.H{"Key": key, "error": "无效的状态"})
+			return
+		}
+
+		from := entries.Status(key)
+		_, err := entries.Transition(key, services.TrackingEvent{
+			Status:   to,
+			Actor:    services.RoleAdmin,
+			Note:     strings.TrimSpace(c.PostForm("note")),
+			Location: strings.TrimSpace(c.PostForm("location")),
+		})
+		if errors.Is(err, services.ErrTransitionNotAllowed) {
+			helper.RenderHTML(c, http.StatusBadRequest, "view_check.html", gin.H{"Key": key, "error": "当前状态不允许该操作"})
+			return
+		}
+		if err != nil {
+			helper.RenderHTML(c, http.StatusBadRequest, "view_check.html", gin.H{"Key": key, "error": "无效的Key"})
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditEntryStatus, Target: key,
+			Before: from.Label(), After: to.Label(), Detail: strings.TrimSpace(c.PostForm("note"))})
+		c.Redirect(http.StatusSeeOther, "/view/"+key)
+	}
+}
+
+// TrackingSync POST /admin/entries/:key/tracking/sync 立即查询一次承运商物流
+func TrackingSync(tracking *services.TrackingService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		n, err := tracking.Sync(ctx, key)
+		if err != nil {
+			log.Printf("tracking sync %s: %v", key, err)
+			helper.RenderHTML(c, http.StatusBadGateway, "view_check.html", gin.H{"Key": key, "error": "物流查询失败：" + err.Error()})
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditTrackingSync, Target: key, Detail: fmt.Sprintf("新增 %d 条物流事件", n)})
+		c.Redirect(http.StatusSeeOther, "/view/"+key)
+	}
+}
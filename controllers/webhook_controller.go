@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"mailtrackerProject/services"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func webhooksPage(c *gin.Context, hooks *services.WebhookService, status int, errMsg string) {
+	endpoints := hooks.Endpoints()
+	urls := make(map[string]string, len(endpoints))
+	for _, ep := range endpoints {
+		urls[ep.ID] = ep.URL
+	}
+	c.HTML(status, "webhooks.html", gin.H{
+		"Endpoints":  endpoints,
+		"Deliveries": hooks.Deliveries(100),
+		"URLs":       urls,
+		"Events":     services.WebhookEvents,
+		"error":      errMsg,
+	})
+}
+
+// WebhooksPage GET /admin/webhooks 接收端与投递记录
+func WebhooksPage(hooks *services.WebhookService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhooksPage(c, hooks, http.StatusOK, "")
+	}
+}
+
+// WebhookAdd POST /admin/webhooks 新增接收端，签名密钥自动生成
+func WebhookAdd(hooks *services.WebhookService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := strings.TrimSpace(c.PostForm("url"))
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			webhooksPage(c, hooks, http.StatusBadRequest, "URL 需以 http:// 或 https:// 开头")
+			return
+		}
+		ep, err := hooks.AddEndpoint(raw, c.PostFormArray("events"))
+		if err != nil {
+			webhooksPage(c, hooks, http.StatusBadRequest, err.Error())
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditWebhookAdd, Target: ep.ID, After: endpointSummary(ep)})
+		c.Redirect(http.StatusSeeOther, "/admin/webhooks")
+	}
+}
+
+// WebhookRemove POST /admin/webhooks/:id/delete
+func WebhookRemove(hooks *services.WebhookService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		before := ""
+		for _, ep := range hooks.Endpoints() {
+			if ep.ID == id {
+				before = endpointSummary(ep)
+			}
+		}
+		if err := hooks.RemoveEndpoint(id); err != nil {
+			webhooksPage(c, hooks, http.StatusNotFound, err.Error())
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditWebhookDel, Target: id, Before: before})
+		c.Redirect(http.StatusSeeOther, "/admin/webhooks")
+	}
+}
+
+// WebhookRedeliver POST /admin/webhooks/deliveries/:id/redeliver 手动重发
+func WebhookRedeliver(hooks *services.WebhookService, audit *services.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		d, err := hooks.Redeliver(id)
+		if err != nil {
+			webhooksPage(c, hooks, http.StatusNotFound, err.Error())
+			return
+		}
+		recordAudit(c, audit, services.AuditEvent{Action: services.AuditWebhookRedeliver, Target: id,
+			Detail: "事件=" + d.Event + "; 接收端=" + d.EndpointID + "; 新投递=" + d.ID})
+		c.Redirect(http.StatusSeeOther, "/admin/webhooks")
+	}
+}
+
+// endpointSummary 接收端的摘要，用于审计日志（不含签名密钥）
+func endpointSummary(ep services.WebhookEndpoint) string {
+	return ep.URL + "; 事件=" + strings.Join(ep.Events, ",")
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"mailtrackerProject/controllers"
 	"mailtrackerProject/helper"
@@ -12,6 +14,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,7 +27,156 @@ func main() {
 	if err := os.MkdirAll(dataDir, 0o755); err != nil {
 		log.Fatalf("cannot create DATA_DIR: %v", err)
 	}
+	// 服务与各子命令都独占数据目录：服务运行时 restore 等子命令会直接退出，反之亦然
+	unlockDataDir, err := services.LockDataDir(dataDir)
+	if err != nil {
+		log.Fatalf("lock data dir %s: %v (is the server already running?)", dataDir, err)
+	}
+	defer unlockDataDir()
+
+	// Services
+
+	//加载ip库
+
+	cityPath := os.Getenv("CITY_MMDB")
+	asnPath := os.Getenv("ASN_MMDB")
+
+	// 2) 初始化单例（geoip2.Reader 是 goroutine-safe，可全局复用）
+	geoService, err := services.NewGeoSerice(cityPath, asnPath)
+	if err != nil {
+		log.Fatalf("init geo service: %v", err)
+	}
+	defer geoService.Close()
+
+	//加载key service
+	keysSvc := services.NewKeysService(filepath.Join(dataDir, "keys.json"))
+	if err := keysSvc.Load(); err != nil {
+		log.Fatalf("load keys: %v", err)
+	}
 
+	// 内容寻址的图片存储，启动时根据 entry.json 重建引用计数
+	storage, err := newObjectStorage(dataDir)
+	if err != nil {
+		log.Fatalf("init object storage: %v", err)
+	}
+	// 子命令：校验备份包并恢复到数据目录后退出（服务运行时无法取得数据目录锁，需先停止服务）
+	// restore <完整备份> [增量备份...] [merge|replace]，mode 默认 merge
+	if len(os.Args) > 2 && os.Args[1] == "restore" {
+		paths, mode := os.Args[2:], services.RestoreMerge
+		if last := paths[len(paths)-1]; last == services.RestoreMerge || last == services.RestoreReplace {
+			paths, mode = paths[:len(paths)-1], last
+		}
+		restoreBackup(dataDir, storage, paths, mode)
+		return
+	}
+	presign, _ := time.ParseDuration(os.Getenv("S3_PRESIGN_EXPIRES"))
+	blobStore := services.NewBlobStore(dataDir, storage, presign)
+	if err := blobStore.Rebuild(); err != nil {
+		log.Fatalf("load blob refs: %v", err)
+	}
+	gcInterval, err := time.ParseDuration(os.Getenv("BLOB_GC_INTERVAL"))
+	if err != nil || gcInterval <= 0 {
+		gcInterval = 6 * time.Hour
+	}
+	blobStore.StartGC(gcInterval, time.Hour)
+
+	entriesSvc := services.NewEntriesService(dataDir, keysSvc, blobStore)
+	// 访问记录文件超过上限后轮转，只保留最近的若干个归档
+	histMax, _ := strconv.ParseInt(os.Getenv("HISTORY_MAX_BYTES"), 10, 64)
+	if histMax == 0 {
+		histMax = 8 << 20
+	}
+	histKeep, err := strconv.Atoi(os.Getenv("HISTORY_KEEP_ARCHIVES"))
+	if err != nil || histKeep < 0 {
+		histKeep = 5
+	}
+	entriesSvc.SetHistoryRotation(histMax, histKeep)
+	privacy := privacyPolicy(dataDir)
+	entriesSvc.SetPrivacy(privacy)
+	// 子命令：为旧的访问记录补全 GeoIP/UA 快照后退出
+	if len(os.Args) > 1 && os.Args[1] == "backfill-history" {
+		backfillHistory(entriesSvc, geoService)
+		return
+	}
+
+	// 离线地名库，用于发件城市补全与地点解析；未配置时不启用
+	var gazetteer *services.GazetteerService
+	if p := os.Getenv("GAZETTEER_CITIES"); p != "" {
+		gazetteer, err = services.NewGazetteerService(p, os.Getenv("GAZETTEER_ADMIN1"), os.Getenv("GAZETTEER_COUNTRIES"))
+		if err != nil {
+			log.Fatalf("load gazetteer: %v", err)
+		}
+		log.Printf("gazetteer loaded: %d places", gazetteer.Len())
+	}
+	// 子命令：按发件地点原文为旧条目补全城市与坐标后退出
+	if len(os.Args) > 1 && os.Args[1] == "geocode-origins" {
+		if gazetteer == nil {
+			log.Fatal("geocode-origins requires GAZETTEER_CITIES")
+		}
+		n, err := entriesSvc.BackfillOrigins(gazetteer)
+		if err != nil {
+			log.Fatalf("geocode origins: %v", err)
+		}
+		log.Printf("geocode done: %d entries", n)
+		return
+	}
+	watermarkSvc, err := newWatermarkService()
+	if err != nil {
+		log.Fatalf("init watermark: %v", err)
+	}
+	fileSrvc := services.NewFilesService(dataDir, blobStore, watermarkSvc)
+	// 水印配置变化后，旧版本的派生图由 GC 清理
+	blobStore.SetDerivedVersion(watermarkSvc.Version())
+
+	// 旧数据中的图片文件名数组迁移为结构化记录，并补算缺失的占位图
+	if n, err := entriesSvc.MigrateImages(fileSrvc.DescribeImage); err != nil {
+		log.Printf("[WARN] migrate entry images: %v", err)
+	} else if n > 0 {
+		log.Printf("migrated images of %d entries", n)
+	}
+
+	// 条目搜索索引，之后随写入增量更新
+	if n, skipped, err := entriesSvc.BuildIndex(); err != nil {
+		log.Printf("[WARN] build entry index: %v", err)
+	} else {
+		log.Printf("indexed %d entries, skipped %d unreadable", n, skipped)
+	}
+
+	// 承运商物流同步，未配置查询接口时不启动
+	trackingSvc := services.NewTrackingService(entriesSvc, trackingProviders()...)
+	pollInterval, err := time.ParseDuration(os.Getenv("TRACKING_POLL_INTERVAL"))
+	if err != nil || pollInterval <= 0 {
+		pollInterval = 30 * time.Minute
+	}
+	trackingSvc.Start(pollInterval)
+
+	// 通知：订阅保存在 notifications.json，渠道按环境变量启用
+	notifySvc := services.NewNotificationService(filepath.Join(dataDir, "notifications.json"), notifyChannels())
+	if err := notifySvc.Load(); err != nil {
+		log.Fatalf("load notifications: %v", err)
+	}
+	maxFailures, _ := strconv.Atoi(os.Getenv("LOOKUP_MAX_FAILURES"))
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	lockout, err := time.ParseDuration(os.Getenv("LOOKUP_LOCKOUT"))
+	if err != nil || lockout <= 0 {
+		lockout = 15 * time.Minute
+	}
+	lookupGuard := services.NewLookupGuard(maxFailures, lockout, lockout)
+
+	// 对外 webhook，投递队列持久化，重启后继续重试
+	webhookSvc := services.NewWebhookService(dataDir)
+	if err := webhookSvc.Load(); err != nil {
+		log.Fatalf("load webhooks: %v", err)
+	}
+	// 子命令：服务停止时把完整快照写到文件后退出；运行中请从管理后台下载
+	if len(os.Args) > 2 && os.Args[1] == "backup" {
+		backupTo(services.NewBackupService(dataDir, keysSvc, entriesSvc, blobStore, notifySvc, webhookSvc), os.Args[2])
+		return
+	}
+
+	// 以下只有运行服务时需要，子命令不检查
 	adminToken := os.Getenv("ADMIN_TOKEN")
 	if adminToken == "" {
 		log.Println("[WARN] ADMIN_TOKEN environment variable not set, using random token.")
@@ -47,28 +201,61 @@ func main() {
 		log.Fatalf("CF_TURNSTILE_SITEKEY or CF_TURNSTILE_SECRET not set")
 	}
 
-	// Services
-
-	//加载ip库
-
-	cityPath := os.Getenv("CITY_MMDB")
-	asnPath := os.Getenv("ASN_MMDB")
-
-	// 2) 初始化单例（geoip2.Reader 是 goroutine-safe，可全局复用）
-	geoService, err := services.NewGeoSerice(cityPath, asnPath)
-	if err != nil {
-		log.Fatalf("init geo service: %v", err)
+	webhookSvc.Start()
+	// 后台把 IP 处理方式与保留期限应用到已有的访问记录
+	if privacy.IPMode != services.IPModeFull || privacy.Retention > 0 {
+		privacyInterval, err := time.ParseDuration(os.Getenv("VISITOR_PRIVACY_INTERVAL"))
+		if err != nil || privacyInterval <= 0 {
+			privacyInterval = 24 * time.Hour
+		}
+		entriesSvc.StartPrivacyJob(privacyInterval)
 	}
-	defer geoService.Close()
 
-	//加载key service
-	keysSvc := services.NewKeysService(filepath.Join(dataDir, "keys.json"))
-	if err := keysSvc.Load(); err != nil {
-		log.Fatalf("load keys: %v", err)
+	// 访问记录里的爬虫与重复查看默认折叠
+	var asnDeny []uint
+	for _, v := range strings.Split(os.Getenv("VISIT_ASN_DENYLIST"), ",") {
+		if n, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32); err == nil {
+			asnDeny = append(asnDeny, uint(n))
+		}
 	}
+	dedupWindow, err := time.ParseDuration(os.Getenv("VISIT_DEDUP_WINDOW"))
+	if err != nil || dedupWindow < 0 {
+		dedupWindow = 30 * time.Minute
+	}
+	visitorFilter := services.NewVisitorFilter(strings.Split(os.Getenv("VISIT_UA_DENYLIST"), ","), asnDeny, dedupWindow)
 
-	entriesSvc := services.NewEntriesService(dataDir, keysSvc)
-	fileSrvc := services.NewFilesService(dataDir)
+	analyticsSvc := services.NewAnalyticsService(entriesSvc, keysSvc, geoService, visitorFilter)
+	exportSvc := services.NewExportService(entriesSvc, keysSvc, geoService, visitorFilter)
+	// 创建页与批量导入共用的条目创建逻辑
+	creatorSvc := services.NewEntryCreateService(entriesSvc, fileSrvc, keysSvc, webhookSvc, gazetteer)
+	backupSvc := services.NewBackupService(dataDir, keysSvc, entriesSvc, blobStore, notifySvc, webhookSvc)
+	importSvc := services.NewImportService(filepath.Join(dataDir, "imports"), creatorSvc, entriesSvc, keysSvc)
+	backupScheduler := newBackupScheduler(dataDir, backupSvc, blobStore)
+	// 中转凭证的签发记录，撤销的凭证即使未过期也不再接受
+	checkpointSvc := services.NewCheckpointService(filepath.Join(dataDir, "checkpoints.json"))
+	if err := checkpointSvc.Load(); err != nil {
+		log.Fatalf("load checkpoints: %v", err)
+	}
+	// 管理操作审计日志，记录之间用 hash 串成链；配置密钥后为 HMAC，链头另存一份，建议放在数据目录之外
+	auditHead := os.Getenv("AUDIT_HEAD_FILE")
+	if auditHead == "" {
+		auditHead = filepath.Join(dataDir, "audit.head")
+	}
+	auditKey := os.Getenv("AUDIT_LOG_KEY")
+	if auditKey == "" {
+		log.Printf("[WARN] AUDIT_LOG_KEY not set, audit log hashes are not keyed")
+	}
+	auditLog := services.NewAuditLog(filepath.Join(dataDir, "audit.ndjson"), auditHead, []byte(auditKey))
+	if err := auditLog.Load(); err != nil {
+		log.Fatalf("load audit log: %v", err)
+	}
+	// 回收站中的 key 超过保留期限后彻底删除
+	trashRetention, err := time.ParseDuration(os.Getenv("TRASH_RETENTION"))
+	if err != nil || trashRetention <= 0 {
+		trashRetention = 30 * 24 * time.Hour
+	}
+	lifecycleSvc := services.NewLifecycleService(keysSvc, entriesSvc, auditLog, trashRetention)
+	lifecycleSvc.StartPurge(time.Hour)
 
 	logger := helper.NewZap()
 	defer logger.Sync()
@@ -94,9 +281,12 @@ func main() {
 	r.LoadHTMLGlob("templates/*.html")
 	r.Static("/styles", "./styles")
 
-	controllers.RegisterAuthRoutes(r)
-	controllers.RegisterAdminRoutes(r, keysSvc, entriesSvc)
-	controllers.RegisterEntryRoutes(r, entriesSvc, fileSrvc, keysSvc, geoService)
+	controllers.RegisterAuthRoutes(r, auditLog)
+	controllers.RegisterAdminRoutes(r, keysSvc, entriesSvc, trackingSvc, notifySvc, webhookSvc, analyticsSvc, exportSvc, importSvc, backupSvc, backupScheduler, lifecycleSvc, checkpointSvc, auditLog)
+	controllers.RegisterEntryRoutes(r, entriesSvc, fileSrvc, keysSvc, geoService, notifySvc, lookupGuard, webhookSvc, visitorFilter, creatorSvc, checkpointSvc, auditLog)
+	controllers.RegisterCheckpointRoutes(r, entriesSvc, geoService, checkpointSvc)
+	controllers.RegisterMapRoutes(r, entriesSvc, geoService, visitorFilter)
+	controllers.RegisterPlaceRoutes(r, gazetteer)
 
 	address := os.Getenv("ADDRESS")
 	log.Printf("listening on %s (DATA_DIR=%s)", address, dataDir)
@@ -104,3 +294,215 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// newObjectStorage 根据 STORAGE_BACKEND 选择图片存储后端，默认本地磁盘
+func newObjectStorage(dataDir string) (services.ObjectStorage, error) {
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "", "local":
+		return services.NewLocalStorage(dataDir), nil
+	case "s3":
+		return services.NewS3Storage(services.S3Config{
+			Endpoint:       os.Getenv("S3_ENDPOINT"),
+			Region:         os.Getenv("S3_REGION"),
+			Bucket:         os.Getenv("S3_BUCKET"),
+			AccessKey:      os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:      os.Getenv("S3_SECRET_KEY"),
+			Prefix:         os.Getenv("S3_PREFIX"),
+			PathStyle:      os.Getenv("S3_PATH_STYLE") == "true",
+			PublicEndpoint: os.Getenv("S3_PUBLIC_ENDPOINT"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", os.Getenv("STORAGE_BACKEND"))
+	}
+}
+
+// newWatermarkService 从环境变量读取水印配置；WATERMARK_TEXT 与 WATERMARK_IMAGE 都未设置时不加水印
+func newWatermarkService() (*services.WatermarkService, error) {
+	opacity, _ := strconv.ParseFloat(os.Getenv("WATERMARK_OPACITY"), 64)
+	scale, _ := strconv.ParseFloat(os.Getenv("WATERMARK_SCALE"), 64)
+	maxSize, _ := strconv.Atoi(os.Getenv("WATERMARK_MAX_SIZE"))
+	return services.NewWatermarkService(services.WatermarkConfig{
+		Text:      os.Getenv("WATERMARK_TEXT"),
+		Site:      os.Getenv("WATERMARK_SITE"),
+		ImagePath: os.Getenv("WATERMARK_IMAGE"),
+		FontPath:  os.Getenv("WATERMARK_FONT"),
+		Position:  os.Getenv("WATERMARK_POSITION"),
+		Opacity:   opacity,
+		Scale:     scale,
+		MaxSize:   maxSize,
+	})
+}
+
+// trackingProviders 按 TRACKING_PROVIDER 选择物流查询方式：file 读取本地目录，http 调用聚合接口
+func trackingProviders() []services.TrackingProvider {
+	switch os.Getenv("TRACKING_PROVIDER") {
+	case "file":
+		return []services.TrackingProvider{services.FileTrackingProvider{Dir: os.Getenv("TRACKING_FILE_DIR")}}
+	case "http":
+		var carriers []string
+		if v := os.Getenv("TRACKING_HTTP_CARRIERS"); v != "" {
+			carriers = strings.Split(v, ",")
+		}
+		return []services.TrackingProvider{services.HTTPTrackingProvider{
+			URL:      os.Getenv("TRACKING_HTTP_URL"),
+			Token:    os.Getenv("TRACKING_HTTP_TOKEN"),
+			Carriers: carriers,
+		}}
+	default:
+		return nil
+	}
+}
+
+// notifyChannels SMTP_HOST 设置后启用邮件，NOTIFY_WEBHOOK_ENABLED=true 启用 webhook
+func notifyChannels() map[string]services.Notifier {
+	channels := map[string]services.Notifier{}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		channels["email"] = services.NewEmailNotifier(services.SMTPConfig{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+	}
+	if os.Getenv("NOTIFY_WEBHOOK_ENABLED") == "true" {
+		channels["webhook"] = &services.WebhookNotifier{}
+	}
+	return channels
+}
+
+// privacyPolicy 访问记录的隐私设置：VISITOR_IP_MODE 为 full（默认）、truncate 或 hash，
+// VISITOR_RETENTION_DAYS 天之前的记录按 VISITOR_RETENTION_ACTION 匿名化（默认）或删除
+func privacyPolicy(dataDir string) services.PrivacyPolicy {
+	p := services.PrivacyPolicy{IPMode: os.Getenv("VISITOR_IP_MODE"), Action: os.Getenv("VISITOR_RETENTION_ACTION")}
+	switch p.IPMode {
+	case "":
+		p.IPMode = services.IPModeFull
+	case services.IPModeFull, services.IPModeTruncate:
+	case services.IPModeHash:
+		if salt := os.Getenv("VISITOR_IP_SALT"); salt != "" {
+			p.Salt = []byte(salt)
+		} else {
+			salt, err := services.LoadOrCreateSalt(filepath.Join(dataDir, "ip-salt"))
+			if err != nil {
+				log.Fatalf("load ip salt: %v", err)
+			}
+			p.Salt = salt
+		}
+	default:
+		log.Fatalf("unknown VISITOR_IP_MODE %q", p.IPMode)
+	}
+	switch p.Action {
+	case "":
+		p.Action = services.RetentionAnonymize
+	case services.RetentionAnonymize, services.RetentionDelete:
+	default:
+		log.Fatalf("unknown VISITOR_RETENTION_ACTION %q", p.Action)
+	}
+	if days, _ := strconv.Atoi(os.Getenv("VISITOR_RETENTION_DAYS")); days > 0 {
+		p.Retention = time.Duration(days) * 24 * time.Hour
+	}
+	return p
+}
+
+// backfillHistory 遍历所有条目的 history.ndjson，补全缺失的 GeoIP/UA 信息。
+// 会改写访问记录文件，服务运行时取不到数据目录锁，main 在此之前已经退出
+func backfillHistory(entries *services.EntriesService, geo *services.GeoService) {
+	keys, err := entries.ListKeys()
+	if err != nil {
+		log.Fatalf("list entries: %v", err)
+	}
+	total := 0
+	for _, key := range keys {
+		n, err := entries.EnrichHistory(key, geo.Enrich)
+		if err != nil {
+			log.Printf("backfill %s: %v", key, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("backfill %s: %d records", key, n)
+		}
+		total += n
+	}
+	log.Printf("backfill done: %d records in %d entries", total, len(keys))
+}
+
+// backupTo 把快照写到 path，写完再改名，避免留下不完整的文件
+func backupTo(backups *services.BackupService, path string) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Fatalf("backup: %v", err)
+	}
+	man, err := backups.Backup(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		log.Fatalf("backup: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Fatalf("backup: %v", err)
+	}
+	log.Printf("backup written to %s: %d keys, %d entries, %d blobs", path, man.Keys, man.Entries, man.Blobs)
+}
+
+// restoreBackup 恢复备份包，paths 为完整备份及其后的增量备份
+func restoreBackup(dataDir string, storage services.ObjectStorage, paths []string, mode string) {
+	var archives []io.Reader
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			log.Fatalf("restore: %v", err)
+		}
+		defer f.Close()
+		archives = append(archives, f)
+	}
+	rep, err := services.RestoreBackup(dataDir, storage, archives, mode)
+	if err != nil {
+		log.Fatalf("restore: %v", err)
+	}
+	log.Printf("restore (%s) from backup of %s: %d keys, %d entries, %d blobs restored",
+		rep.Mode, rep.Manifest.CreatedAt.Format(time.RFC3339), rep.Keys, rep.Entries, rep.Blobs)
+	if len(rep.Skipped) > 0 {
+		log.Printf("restore: %d entries already exist and were skipped: %s", len(rep.Skipped), strings.Join(rep.Skipped, ", "))
+	}
+	if rep.SavedTo != "" {
+		log.Printf("restore: previous data moved to %s", rep.SavedTo)
+	}
+}
+
+// newBackupScheduler 定时备份到 BACKUP_DIR，或 BACKUP_STORAGE=blob 时备份到图片存储的 backups/ 下；都未配置时不启用
+func newBackupScheduler(dataDir string, backups *services.BackupService, blobs *services.BlobStore) *services.BackupScheduler {
+	var store services.ObjectStorage
+	prefix := ""
+	switch {
+	case os.Getenv("BACKUP_DIR") != "":
+		store = services.NewLocalStorage(os.Getenv("BACKUP_DIR"))
+	case os.Getenv("BACKUP_STORAGE") == "blob":
+		store, prefix = blobs.Storage(), "backups/"
+	default:
+		return nil
+	}
+	cfg := services.BackupScheduleConfig{Interval: 24 * time.Hour, FullEvery: 7, KeepDaily: 7, KeepWeekly: 4}
+	if d, err := time.ParseDuration(os.Getenv("BACKUP_INTERVAL")); err == nil && d > 0 {
+		cfg.Interval = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("BACKUP_FULL_EVERY")); err == nil && n > 0 {
+		cfg.FullEvery = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("BACKUP_KEEP_DAILY")); err == nil && n >= 0 {
+		cfg.KeepDaily = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("BACKUP_KEEP_WEEKLY")); err == nil && n >= 0 {
+		cfg.KeepWeekly = n
+	}
+	s := services.NewBackupScheduler(backups, store, prefix, filepath.Join(dataDir, "backup-status.json"), cfg)
+	if err := s.Load(); err != nil {
+		log.Fatalf("load backup status: %v", err)
+	}
+	s.Start()
+	return s
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// AnalyticsFilter 统计范围：按寄出日期筛选条目、按生成时间筛选 key 批次，零值表示不限
+type AnalyticsFilter struct {
+	From      time.Time
+	To        time.Time // 含当天
+	StaleDays int       // 寄出超过这么多天仍无人查看的条目算作滞留
+}
+
+func (f AnalyticsFilter) contains(t time.Time) bool {
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	return f.To.IsZero() || t.Before(f.To.AddDate(0, 0, 1))
+}
+
+// EntryStats 单个条目的统计；查看相关的数字都已排除爬虫
+type EntryStats struct {
+	Key         string
+	Recipient   string
+	Batch       string
+	Status      EntryStatus
+	PostedAt    time.Time
+	FirstView   *time.Time
+	TransitDays *float64 // 寄出到首次查看的天数
+	Views       int
+	Unique      int
+	Country     string // 首次查看所在国家（ISO 代码），视为目的地
+}
+
+// TransitStats 运输时长分布（天）
+type TransitStats struct {
+	Count  int
+	Avg    float64
+	Median float64
+	P90    float64
+	Min    float64
+	Max    float64
+}
+
+// CountryStats 目的地国家
+type CountryStats struct {
+	ISO     string
+	Entries int
+	Views   int
+}
+
+// BatchStats key 批次的使用情况
+type BatchStats struct {
+	ID          string
+	Comment     string
+	CreatedAt   time.Time
+	Total       int
+	Used        int
+	UnusedRatio float64
+}
+
+// UnusedPercent 未使用比例（百分数）
+func (b BatchStats) UnusedPercent() float64 { return b.UnusedRatio * 100 }
+
+// batchStats 按批次汇总 key 的使用情况，从新到旧；include 为 nil 时统计全部
+func batchStats(keys []KeyInfo, include func(KeyInfo) bool, used func(key string) bool) []BatchStats {
+	batches := map[string]*BatchStats{}
+	for _, ki := range keys {
+		if include != nil && !include(ki) {
+			continue
+		}
+		id := ki.BatchID()
+		b, ok := batches[id]
+		if !ok {
+			b = &BatchStats{ID: id, Comment: ki.Comment, CreatedAt: ki.CreatedAt}
+			batches[id] = b
+		}
+		b.Total++
+		if used(ki.Key) {
+			b.Used++
+		}
+	}
+	out := make([]BatchStats, 0, len(batches))
+	for _, b := range batches {
+		b.UnusedRatio = float64(b.Total-b.Used) / float64(b.Total)
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+type AnalyticsReport struct {
+	Filter     AnalyticsFilter
+	Entries    int
+	Drafts     int
+	Viewed     int
+	TotalViews int
+	Unique     int
+	Transit    TransitStats
+	Countries  []CountryStats
+	Batches    []BatchStats
+	Stale      []EntryStats
+	Rows       []EntryStats
+}
+
+// AnalyticsService 跨条目的投递统计，每次请求现算
+type AnalyticsService struct {
+	entries  *EntriesService
+	keys     *KeysService
+	geo      *GeoService
+	visitors *VisitorFilter
+}
+
+func NewAnalyticsService(entries *EntriesService, keys *KeysService, geo *GeoService, visitors *VisitorFilter) *AnalyticsService {
+	return &AnalyticsService{entries: entries, keys: keys, geo: geo, visitors: visitors}
+}
+
+// PostedAt 寄出时间：发件日期（按本地时区当天零点），没有或格式不对时用创建时间
+func (e *EntryEnvelope) PostedAt() time.Time {
+	if e.Data.PostDate != nil {
+		if t, err := time.ParseInLocation("2006-01-02", *e.Data.PostDate, time.Local); err == nil {
+			return t
+		}
+	}
+	return e.CreatedAt
+}
+
+// entryStats 读取一个条目的访问记录并计算统计
+func (s *AnalyticsService) entryStats(key string, env *EntryEnvelope, batch string) (EntryStats, error) {
+	st := EntryStats{
+		Key:      key,
+		Batch:    batch,
+		Status:   env.CurrentStatus(),
+		PostedAt: env.PostedAt(),
+	}
+	if env.Data.RecipientName != nil {
+		st.Recipient = *env.Data.RecipientName
+	}
+
+	sum, err := s.visitors.Summary(key, s.entries.HistoryVersion(key), func(fn func(HistoryRecord) bool) error {
+		return s.entries.ScanHistory(key, fn)
+	})
+	if err != nil {
+		return st, err
+	}
+	st.Views, st.Unique = sum.Views, sum.Unique
+
+	// 首次非爬虫查看
+	err = s.entries.ScanHistory(key, func(rec HistoryRecord) bool {
+		s.geo.Enrich(&rec)
+		if s.visitors.IsBot(&rec) {
+			return true
+		}
+		t := rec.Time
+		st.FirstView = &t
+		if rec.IPObj != nil {
+			st.Country = rec.IPObj.CountryISO
+		}
+		return false
+	})
+	if err != nil {
+		return st, err
+	}
+	if st.FirstView != nil && st.Status != StatusDraft {
+		d := math.Round(st.FirstView.Sub(st.PostedAt).Hours()/24*10) / 10
+		if d >= 0 { // 寄件人自己先看过的不计入
+			st.TransitDays = &d
+		}
+	}
+	return st, nil
+}
+
+// Report 计算统计报表。读不出的条目（含统计期间被彻底删除的）记日志后跳过，不影响其余条目
+func (s *AnalyticsService) Report(f AnalyticsFilter) (*AnalyticsReport, error) {
+	rep := &AnalyticsReport{Filter: f}
+	keys := s.keys.List()
+	batchOf := map[string]string{}
+	for _, ki := range keys {
+		batchOf[ki.Key] = ki.BatchID()
+	}
+	rep.Batches = batchStats(keys, func(ki KeyInfo) bool { return f.contains(ki.CreatedAt) }, s.entries.HasData)
+
+	entryKeys, err := s.entries.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	countries := map[string]*CountryStats{}
+	var transit []float64
+	staleBefore := time.Now().AddDate(0, 0, -f.StaleDays)
+	for _, key := range entryKeys {
+		if _, ok := batchOf[key]; !ok {
+			continue // 回收站中的条目不计入
+		}
+		env, err := s.entries.LoadData(key)
+		if err != nil {
+			log.Printf("analytics: skip %s: %v", key, err)
+			continue
+		}
+		if !f.contains(env.PostedAt()) {
+			continue
+		}
+		st, err := s.entryStats(key, env, batchOf[key])
+		if err != nil {
+			log.Printf("analytics: skip %s: %v", key, err)
+			continue
+		}
+		rep.Rows = append(rep.Rows, st)
+		if st.Status == StatusDraft {
+			rep.Drafts++
+			continue
+		}
+		rep.Entries++
+		rep.TotalViews += st.Views
+		rep.Unique += st.Unique
+		if st.FirstView == nil {
+			if f.StaleDays > 0 && st.PostedAt.Before(staleBefore) {
+				rep.Stale = append(rep.Stale, st)
+			}
+			continue
+		}
+		rep.Viewed++
+		if st.TransitDays != nil {
+			transit = append(transit, *st.TransitDays)
+		}
+		iso := st.Country
+		if iso == "" {
+			iso = "??"
+		}
+		c, ok := countries[iso]
+		if !ok {
+			c = &CountryStats{ISO: iso}
+			countries[iso] = c
+		}
+		c.Entries++
+		c.Views += st.Views
+	}
+	for _, c := range countries {
+		rep.Countries = append(rep.Countries, *c)
+	}
+	sort.Slice(rep.Countries, func(i, j int) bool {
+		if rep.Countries[i].Entries != rep.Countries[j].Entries {
+			return rep.Countries[i].Entries > rep.Countries[j].Entries
+		}
+		return rep.Countries[i].ISO < rep.Countries[j].ISO
+	})
+	sort.Slice(rep.Rows, func(i, j int) bool { return rep.Rows[i].PostedAt.After(rep.Rows[j].PostedAt) })
+	sort.Slice(rep.Stale, func(i, j int) bool { return rep.Stale[i].PostedAt.Before(rep.Stale[j].PostedAt) })
+	rep.Transit = transitStats(transit)
+	return rep, nil
+}
+
+func transitStats(days []float64) TransitStats {
+	if len(days) == 0 {
+		return TransitStats{}
+	}
+	sort.Float64s(days)
+	total := 0.0
+	for _, d := range days {
+		total += d
+	}
+	pct := func(p float64) float64 { return days[int(math.Ceil(p*float64(len(days))))-1] }
+	return TransitStats{
+		Count:  len(days),
+		Avg:    math.Round(total/float64(len(days))*10) / 10,
+		Median: pct(0.5),
+		P90:    pct(0.9),
+		Min:    days[0],
+		Max:    days[len(days)-1],
+	}
+}
+
+// 可导出的表
+const (
+	AnalyticsTableEntries   = "entries"
+	AnalyticsTableCountries = "countries"
+	AnalyticsTableBatches   = "batches"
+	AnalyticsTableStale     = "stale"
+)
+
+var AnalyticsTables = []string{AnalyticsTableEntries, AnalyticsTableCountries, AnalyticsTableBatches, AnalyticsTableStale}
+
+// WriteCSV 导出报表中的一张表；带 UTF-8 BOM，方便 Excel 直接打开中文。文本列经 csvSafe 转义，不会被当作公式
+func (r *AnalyticsReport) WriteCSV(w io.Writer, table string) error {
+	if !slices.Contains(AnalyticsTables, table) {
+		return fmt.Errorf("unknown table %q", table)
+	}
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	fmtTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	fmtDays := func(d *float64) string {
+		if d == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*d, 'f', 1, 64)
+	}
+	entryRows := func(rows []EntryStats) {
+		_ = cw.Write([]string{"key", "recipient", "batch", "status", "posted_at", "first_view", "transit_days", "views", "unique_visitors", "country"})
+		for _, e := range rows {
+			_ = cw.Write([]string{e.Key, csvSafe(e.Recipient), csvSafe(e.Batch), string(e.Status), e.PostedAt.Format("2006-01-02"),
+				fmtTime(e.FirstView), fmtDays(e.TransitDays), strconv.Itoa(e.Views), strconv.Itoa(e.Unique), csvSafe(e.Country)})
+		}
+	}
+
+	switch table {
+	case AnalyticsTableEntries:
+		entryRows(r.Rows)
+	case AnalyticsTableStale:
+		entryRows(r.Stale)
+	case AnalyticsTableCountries:
+		_ = cw.Write([]string{"country", "entries", "views"})
+		for _, c := range r.Countries {
+			_ = cw.Write([]string{csvSafe(c.ISO), strconv.Itoa(c.Entries), strconv.Itoa(c.Views)})
+		}
+	case AnalyticsTableBatches:
+		_ = cw.Write([]string{"batch", "comment", "created_at", "total", "used", "unused_ratio"})
+		for _, b := range r.Batches {
+			_ = cw.Write([]string{csvSafe(b.ID), csvSafe(b.Comment), b.CreatedAt.Format(time.RFC3339), strconv.Itoa(b.Total), strconv.Itoa(b.Used),
+				strconv.FormatFloat(b.UnusedRatio, 'f', 3, 64)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
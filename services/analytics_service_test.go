@@ -0,0 +1,34 @@
+package services
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// 读不出的条目跳过，其余照常统计；批次统计与搜索页的批次列表一致
+func TestAnalyticsReportSkipsUnreadable(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 3)
+	for _, ki := range kis[:2] {
+		if err := entries.SaveData(ki.Key, EntryData{}, StatusPosted); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(entries.entryPath(kis[1].Key), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAnalyticsService(entries, entries.keys, nil, NewVisitorFilter(nil, nil, time.Hour))
+	rep, err := a.Report(AnalyticsFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Rows) != 1 || rep.Rows[0].Key != kis[0].Key {
+		t.Fatalf("rows = %+v", rep.Rows)
+	}
+	if len(rep.Batches) != 1 || rep.Batches[0].Total != 3 || rep.Batches[0].Used != 2 {
+		t.Fatalf("batches = %+v", rep.Batches)
+	}
+	if b := entries.Batches(); len(b) != 1 || b[0] != rep.Batches[0] {
+		t.Fatalf("Batches() = %+v, report %+v", b, rep.Batches)
+	}
+}
@@ -1,76 +1,149 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
-	"errors"
-	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/mileusna/useragent"
 )
 
 type HistoryRecord struct {
-	Time      time.Time           `json:"time"`
-	UA        string              `json:"ua"`
-	IP        string              `json:"ip"`
-	UAObj     useragent.UserAgent `json:"-"`
-	IPObj     *IPInfo             `json:"-"`
-	Timestamp int64               `json:"-"`
+	Time      time.Time `json:"time"`
+	UA        string    `json:"ua"`
+	IP        string    `json:"ip"`
+	UAObj     *UAInfo   `json:"agent,omitempty"` // 记录时解析的 UA
+	IPObj     *IPInfo   `json:"geo,omitempty"`   // 记录时查到的 GeoIP，IP 库更新后也不变
+	Timestamp int64     `json:"-"`
+	Visitor   string    `json:"-"` // 访客指纹，展示时计算
+	Hidden    string    `json:"-"` // 折叠原因：bot / repeat，空表示正常显示
 }
 
-func (s *EntriesService) RecorduaNewlinejson(key string, rec HistoryRecord) error {
+// UAInfo UA 解析结果的快照
+type UAInfo struct {
+	Name      string `json:"name,omitempty"`
+	Version   string `json:"version,omitempty"`
+	OS        string `json:"os,omitempty"`
+	OSVersion string `json:"os_version,omitempty"`
+	Device    string `json:"device,omitempty"`
+	Mobile    bool   `json:"mobile,omitempty"`
+	Tablet    bool   `json:"tablet,omitempty"`
+	Desktop   bool   `json:"desktop,omitempty"`
+	Bot       bool   `json:"bot,omitempty"`
+}
+
+func ParseUAInfo(ua string) *UAInfo {
+	p := useragent.Parse(ua)
+	return &UAInfo{
+		Name:      p.Name,
+		Version:   p.Version,
+		OS:        p.OS,
+		OSVersion: p.OSVersion,
+		Device:    p.Device,
+		Mobile:    p.Mobile,
+		Tablet:    p.Tablet,
+		Desktop:   p.Desktop,
+		Bot:       p.Bot,
+	}
+}
+
+// RecorduaNewlinejson 追加一条访问记录；first 表示这是该条目的第一条记录，
+// 在记录锁内判断，同时到达的两次查询只有一个会得到 true
+func (s *EntriesService) RecorduaNewlinejson(key string, rec HistoryRecord) (first bool, err error) {
 	hp := s.historyPath(key)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	// 每个 key 单独加锁，不阻塞其它条目的写入
+	mu := s.historyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
 
+	first = !s.HasHistory(key)
 	if err := os.MkdirAll(filepath.Dir(hp), 0o755); err != nil {
-		return err
+		return false, err
 	}
 	f, err := os.OpenFile(hp, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer func(f *os.File) {
 		_ = f.Close()
 	}(f)
 
+	s.privacy.scrub(&rec) // 按隐私设置截断或散列 IP，只落盘处理后的记录
 	enc := json.NewEncoder(f)
-	return enc.Encode(rec) // 每条一行
+	if err := enc.Encode(rec); err != nil { // 每条一行
+		return false, err
+	}
+	s.index.viewed(key, rec.Time)
+	return first, s.rotateHistoryLocked(key, f)
 }
+
+// ReadUARecords 读取全部访问记录（含已轮转的归档），最新在前。
+// 记录很多的条目请用 HistoryPage 分页读取。
 func (s *EntriesService) ReadUARecords(key string) ([]HistoryRecord, error) {
-	hp := s.historyPath(key)
+	var records []HistoryRecord
+	err := s.ScanHistory(key, func(rec HistoryRecord) bool {
+		records = append(records, rec)
+		return true
+	})
+	// 倒序排列
+	slices.Reverse(records)
+	return records, err
+}
 
-	// 文件不存在时返回 nil
-	f, err := os.Open(hp)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	defer func(f *os.File) {
-		_ = f.Close()
-	}(f)
+// EnrichHistory 逐条调用 enrich 补全访问记录，有改动的文件整体重写。返回改动条数。
+// 补全的 GeoIP 快照同样按隐私设置处理
+func (s *EntriesService) EnrichHistory(key string, enrich func(rec *HistoryRecord) bool) (int, error) {
+	n, _, err := s.rewriteHistory(key, func(rec *HistoryRecord) (bool, bool) {
+		changed := enrich(rec)
+		return s.privacy.scrub(rec) || changed, false
+	})
+	return n, err
+}
 
+// rewriteHistoryFile 对文件中的每条记录调用 fn（返回是否改动、是否删除），有改动时先写临时文件再 Rename。
+// 返回改动与删除的条数；归档文件的记录全部删除时删除该文件
+func rewriteHistoryFile(p string, fn func(rec *HistoryRecord) (changed, drop bool)) (int, int, error) {
 	var records []HistoryRecord
-	dec := json.NewDecoder(f)
+	if err := scanHistoryFile(p, func(rec HistoryRecord) bool {
+		records = append(records, rec)
+		return true
+	}); err != nil {
+		return 0, 0, err
+	}
+	changed, dropped := 0, 0
+	kept := records[:0]
+	for i := range records {
+		c, d := fn(&records[i])
+		switch {
+		case d:
+			dropped++
+			continue
+		case c:
+			changed++
+		}
+		kept = append(kept, records[i])
+	}
+	if changed == 0 && dropped == 0 {
+		return 0, 0, nil
+	}
+	if len(kept) == 0 && filepath.Base(p) != "history.ndjson" {
+		return changed, dropped, os.Remove(p)
+	}
 
-	for {
-		var rec HistoryRecord
-		if err := dec.Decode(&rec); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return nil, err
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range kept {
+		if err := enc.Encode(rec); err != nil {
+			return 0, 0, err
 		}
-		records = append(records, rec)
 	}
-	// 倒序排列
-	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
-		records[i], records[j] = records[j], records[i]
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return 0, 0, err
 	}
-	return records, nil
+	return changed, dropped, os.Rename(tmp, p)
 }
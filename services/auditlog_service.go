@@ -0,0 +1,397 @@
+package services
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 审计日志中的操作
+const (
+	AuditLogin            = "auth.login"
+	AuditLoginFailed      = "auth.login_failed"
+	AuditKeyGenerate      = "key.generate"
+	AuditKeyRevoke        = "key.revoke"
+	AuditKeyArchive       = "key.archive"
+	AuditKeyUnarchive     = "key.unarchive"
+	AuditKeyDelete        = "key.delete"
+	AuditKeyRestore       = "key.restore"
+	AuditKeyPurge         = "key.purge"
+	AuditEntryCreate      = "entry.create"
+	AuditEntryUpdate      = "entry.update"
+	AuditEntryStatus      = "entry.status"
+	AuditEntryErase       = "entry.erase_visitors"
+	AuditImport           = "entry.import"
+	AuditImportDiscard    = "entry.import_discard"
+	AuditTrackingSync     = "entry.tracking_sync"
+	AuditNotifyAdd        = "settings.notification_add"
+	AuditNotifyRemove     = "settings.notification_remove"
+	AuditWebhookAdd       = "settings.webhook_add"
+	AuditWebhookDel       = "settings.webhook_remove"
+	AuditWebhookRedeliver = "settings.webhook_redeliver"
+	AuditCheckpointIssue  = "settings.checkpoint_issue"
+	AuditCheckpointRevoke = "settings.checkpoint_revoke"
+	AuditExport           = "data.export"
+	AuditBackup           = "data.backup"
+)
+
+// AuditActions 所有操作，审计页的筛选项
+var AuditActions = []string{
+	AuditLogin, AuditLoginFailed,
+	AuditKeyGenerate, AuditKeyRevoke, AuditKeyArchive, AuditKeyUnarchive, AuditKeyDelete, AuditKeyRestore, AuditKeyPurge,
+	AuditEntryCreate, AuditEntryUpdate, AuditEntryStatus, AuditEntryErase, AuditImport, AuditImportDiscard, AuditTrackingSync,
+	AuditNotifyAdd, AuditNotifyRemove, AuditWebhookAdd, AuditWebhookDel, AuditWebhookRedeliver, AuditCheckpointIssue, AuditCheckpointRevoke,
+	AuditExport, AuditBackup,
+}
+
+// 操作者
+const (
+	AuditActorAdmin     = "admin"
+	AuditActorAnonymous = "anonymous" // 未登录，如登录失败、持 key 公开创建条目
+	AuditActorSystem    = "system"    // 后台任务
+)
+
+// AuditEvent 审计日志的一条记录。Hash 覆盖除 Hash 外的所有字段与上一条的 Hash，
+// 改动或删除任何一条都会使之后的链校验失败。配置了密钥时 Hash 为 HMAC-SHA256（Keyed），
+// 没有密钥的人无法重新计算整条链
+type AuditEvent struct {
+	Seq      int64     `json:"seq"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	IP       string    `json:"ip,omitempty"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	Before   string    `json:"before,omitempty"` // 改动前的摘要
+	After    string    `json:"after,omitempty"`  // 改动后的摘要
+	PrevHash string    `json:"prev_hash,omitempty"`
+	Keyed    bool      `json:"keyed,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+func (e AuditEvent) digest(key []byte) string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	if e.Keyed {
+		m := hmac.New(sha256.New, key)
+		m.Write(b)
+		return hex.EncodeToString(m.Sum(nil))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditHead 链头：最后一条记录的序号与 hash，另存一份在日志文件之外，用于发现重启前被截掉的末尾记录。
+// 同时记下链开始前允许存在的旧记录条数与开始使用密钥的序号；配置密钥时链头本身也带签名
+type auditHead struct {
+	Seq       int64  `json:"seq"`
+	Hash      string `json:"hash"`
+	Legacy    *int   `json:"legacy,omitempty"`    // 文件开头启用 hash 链之前写入的记录条数
+	KeyedFrom int64  `json:"keyedFrom,omitempty"` // 从这一序号起的记录必须由密钥签名
+	Mac       string `json:"mac,omitempty"`
+}
+
+func (h auditHead) mac(key []byte) string {
+	h.Mac = ""
+	b, _ := json.Marshal(h)
+	m := hmac.New(sha256.New, key)
+	m.Write(b)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// AuditLog 管理操作的审计日志，只追加写入 audit.ndjson，记录之间用 hash 串成链；
+// 链头（最后一条的序号与 hash）同时写入 headPath
+type AuditLog struct {
+	path      string
+	headPath  string
+	key       []byte // HMAC 密钥，为空时用不带密钥的 SHA-256
+	mu        sync.Mutex
+	seq       int64
+	last      string // 最后一条的 Hash
+	legacy    int
+	keyedFrom int64
+	headErr   string // 链头签名校验失败的原因，此后不再为链头签名
+}
+
+func NewAuditLog(path, headPath string, key []byte) *AuditLog {
+	return &AuditLog{path: path, headPath: headPath, key: key}
+}
+
+// Keyed 是否配置了 HMAC 密钥
+func (l *AuditLog) Keyed() bool { return len(l.key) > 0 }
+
+// Load 读取最后一条记录，接着它的序号与 hash 继续写。
+// 链头文件比日志更新时说明末尾被截掉了，以链头为准继续写，缺口会一直在校验中显示出来。
+// 首次配置密钥时把下一条记录的序号写入链头，此后未签名的记录无法通过校验
+func (l *AuditLog) Load() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	legacy := 0
+	err := l.scan(func(e AuditEvent) bool {
+		if e.Hash != "" {
+			l.seq, l.last = e.Seq, e.Hash
+		} else if l.seq == 0 {
+			legacy++
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var head auditHead
+	if err := readJSONFile(l.headPath, &head); err != nil {
+		return err
+	}
+	if l.Keyed() && head.KeyedFrom > 0 && !hmac.Equal([]byte(head.Mac), []byte(head.mac(l.key))) {
+		l.headErr = "链头签名无效，链头文件可能被改写"
+		log.Printf("[WARN] audit head signature does not match AUDIT_LOG_KEY")
+	}
+	if head.Seq > l.seq || (head.Seq == l.seq && head.Hash != l.last) {
+		log.Printf("[WARN] audit log ends at #%d but head is #%d, the tail may have been removed", l.seq, head.Seq)
+		l.seq, l.last = head.Seq, head.Hash
+	}
+	dirty := false
+	if head.Legacy == nil {
+		// 旧版本写的链头或链头缺失：以当前文件开头的旧记录为准
+		if head.Seq == 0 && l.seq > 0 {
+			log.Printf("[WARN] audit head missing, rebuilt from the log at #%d", l.seq)
+		}
+		head.Legacy, dirty = &legacy, true
+	}
+	l.legacy, l.keyedFrom = *head.Legacy, head.KeyedFrom
+	if l.Keyed() && l.keyedFrom == 0 {
+		l.keyedFrom, dirty = l.seq+1, true
+		log.Printf("audit log is keyed from #%d", l.keyedFrom)
+	}
+	if dirty {
+		return l.writeHead()
+	}
+	return nil
+}
+
+// writeHead 写入链头，调用方持有锁
+func (l *AuditLog) writeHead() error {
+	legacy := l.legacy
+	head := auditHead{Seq: l.seq, Hash: l.last, Legacy: &legacy, KeyedFrom: l.keyedFrom}
+	if l.Keyed() && l.headErr == "" {
+		head.Mac = head.mac(l.key)
+	}
+	return writeJSONFile(l.headPath, head)
+}
+
+// Record 追加一条记录，未设置时间时取当前时间
+func (l *AuditLog) Record(e AuditEvent) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq, e.PrevHash, e.Keyed = l.seq+1, l.last, l.Keyed()
+	e.Hash = e.digest(l.key)
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	l.seq, l.last = e.Seq, e.Hash
+	return l.writeHead()
+}
+
+// scan 按写入顺序读取全部记录，调用方持有锁
+func (l *AuditLog) scan(fn func(e AuditEvent) bool) error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("audit log line %d: %w", line, err)
+		}
+		if !fn(e) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// AuditVerify 链校验结果
+type AuditVerify struct {
+	Records  int
+	Legacy   int   // 启用 hash 链之前写入的记录
+	Unkeyed  int   // 配置密钥之前写入、只有 SHA-256 的记录
+	Keyed    bool  // 是否配置了密钥
+	BrokenAt int64 // 第一条校验失败的序号，0 表示完整
+	Reason   string
+}
+
+func (v AuditVerify) OK() bool { return v.BrokenAt == 0 && v.Reason == "" }
+
+// Verify 从头校验整条链：每条的 hash、与上一条的衔接以及序号是否连续。
+// 链开始前的旧记录只接受链头记下的条数；配置密钥后写入的记录必须带密钥签名
+func (l *AuditLog) Verify() (AuditVerify, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v := AuditVerify{Keyed: l.Keyed()}
+	var prev AuditEvent
+	chained, keyed := false, false
+	err := l.scan(func(e AuditEvent) bool {
+		v.Records++
+		if e.Hash == "" && !chained && v.Legacy < l.legacy {
+			v.Legacy++
+			return true
+		}
+		switch {
+		case e.Hash == "":
+			v.Reason = "缺少 hash"
+		case e.Keyed && !l.Keyed():
+			v.Reason = "记录由密钥签名，需配置 AUDIT_LOG_KEY 才能校验"
+		case !e.Keyed && keyed:
+			v.Reason = "密钥签名的记录之后出现了未签名的记录"
+		case !e.Keyed && l.Keyed() && l.keyedFrom > 0 && e.Seq >= l.keyedFrom:
+			v.Reason = "配置密钥之后写入的记录没有密钥签名"
+		case !hmac.Equal([]byte(e.digest(l.key)), []byte(e.Hash)):
+			v.Reason = "内容与 hash 不符"
+		case chained && e.PrevHash != prev.Hash:
+			v.Reason = "与上一条记录不衔接"
+		case chained && e.Seq != prev.Seq+1:
+			v.Reason = fmt.Sprintf("序号不连续（上一条为 %d）", prev.Seq)
+		case !chained && (e.PrevHash != "" || e.Seq != 1):
+			v.Reason = "链的开头被删除"
+		case !chained && v.Legacy != l.legacy:
+			v.Reason = "启用 hash 链之前的记录被删除"
+		}
+		if v.Reason != "" {
+			v.BrokenAt = max(e.Seq, prev.Seq+1)
+			return false
+		}
+		if !e.Keyed {
+			v.Unkeyed++
+		}
+		prev, chained, keyed = e, true, keyed || e.Keyed
+		return true
+	})
+	switch {
+	case err != nil || !v.OK():
+	case prev.Hash != l.last:
+		// 末尾的记录被删除
+		v.BrokenAt, v.Reason = prev.Seq+1, "末尾的记录缺失"
+	case !chained && v.Legacy != l.legacy:
+		v.BrokenAt, v.Reason = 1, "启用 hash 链之前的记录被删除"
+	case l.headErr != "":
+		v.BrokenAt, v.Reason = l.seq, l.headErr
+	}
+	return v, err
+}
+
+// AuditFilter 审计日志查询条件，空值表示不限
+type AuditFilter struct {
+	Action string // 完整操作名，或 "key." 这样的前缀
+	Actor  string
+	IP     string
+	Target string // 包含匹配
+	Text   string // 在说明与前后摘要中包含匹配
+	From   time.Time
+	To     time.Time // 不含
+}
+
+func (f AuditFilter) match(e *AuditEvent) bool {
+	switch {
+	case f.Action != "" && e.Action != f.Action && !(strings.HasSuffix(f.Action, ".") && strings.HasPrefix(e.Action, f.Action)):
+		return false
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case f.IP != "" && e.IP != f.IP:
+		return false
+	case f.Target != "" && !strings.Contains(strings.ToUpper(e.Target), strings.ToUpper(f.Target)):
+		return false
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !e.Time.Before(f.To):
+		return false
+	}
+	if f.Text != "" {
+		t := strings.ToLower(f.Text)
+		return strings.Contains(strings.ToLower(e.Detail+"\n"+e.Before+"\n"+e.After), t)
+	}
+	return true
+}
+
+// Query 按条件查询，最新的在前；返回一页记录与符合条件的总数
+func (l *AuditLog) Query(f AuditFilter, offset, limit int) ([]AuditEvent, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var all []AuditEvent
+	err := l.scan(func(e AuditEvent) bool {
+		if f.match(&e) {
+			all = append(all, e)
+		}
+		return true
+	})
+	slices.Reverse(all)
+	total := len(all)
+	offset = min(max(offset, 0), total)
+	return all[offset:min(offset+limit, total)], total, err
+}
+
+// AuditSummary 条目的摘要，用于审计日志中改动前后的对比。
+// 收件人姓名是按姓名查询的凭据，而审计日志只追加、彻底删除条目后也不会清除，因此不写入
+func (e *EntryEnvelope) AuditSummary() string {
+	if e == nil {
+		return ""
+	}
+	d := e.Data
+	method := ""
+	if d.Encrypt != nil {
+		method = derefString(d.Encrypt.Method)
+	}
+	parts := []string{
+		"状态=" + string(e.CurrentStatus()),
+		"发件日期=" + derefString(d.PostDate),
+		"发件地=" + derefString(d.OriginLocation),
+		"查询方式=" + method,
+		fmt.Sprintf("图片=%d", len(d.Images)),
+	}
+	if d.TrackingNumber != nil {
+		parts = append(parts, "单号="+derefString(d.Carrier)+" "+*d.TrackingNumber)
+	}
+	if d.Remarks != nil && *d.Remarks != "" {
+		parts = append(parts, fmt.Sprintf("备注=%d 字", len([]rune(*d.Remarks))))
+	}
+	return strings.Join(parts, "; ")
+}
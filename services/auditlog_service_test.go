@@ -0,0 +1,186 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type auditFixture struct {
+	path, head string
+}
+
+func newAuditFixture(t *testing.T) auditFixture {
+	t.Helper()
+	dir := t.TempDir()
+	return auditFixture{path: filepath.Join(dir, "audit.ndjson"), head: filepath.Join(dir, "audit.head")}
+}
+
+// open 模拟一次启动：新建实例并 Load
+func (f auditFixture) open(t *testing.T, key string) *AuditLog {
+	t.Helper()
+	l := NewAuditLog(f.path, f.head, []byte(key))
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func (f auditFixture) record(t *testing.T, l *AuditLog, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := l.Record(AuditEvent{Actor: AuditActorAdmin, Action: AuditKeyGenerate, Detail: "batch"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (f auditFixture) events(t *testing.T) []AuditEvent {
+	t.Helper()
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []AuditEvent
+	for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+		var e AuditEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func (f auditFixture) write(t *testing.T, events []AuditEvent) {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, e := range events {
+		b, _ := json.Marshal(e)
+		buf.Write(append(b, '\n'))
+	}
+	if err := os.WriteFile(f.path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wantBroken(t *testing.T, l *AuditLog, at int64, reason string) {
+	t.Helper()
+	v, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.OK() || v.BrokenAt != at || !strings.Contains(v.Reason, reason) {
+		t.Fatalf("verify = %+v, want broken at %d (%s)", v, at, reason)
+	}
+}
+
+func wantIntact(t *testing.T, l *AuditLog) AuditVerify {
+	t.Helper()
+	v, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.OK() {
+		t.Fatalf("verify = %+v, want intact", v)
+	}
+	return v
+}
+
+func TestAuditVerifyTamper(t *testing.T) {
+	const key = "secret"
+	cases := []struct {
+		name   string
+		tamper func(t *testing.T, f auditFixture)
+		at     int64
+		reason string
+	}{
+		{"edited line", func(t *testing.T, f auditFixture) {
+			ev := f.events(t)
+			ev[2].Detail = "changed"
+			f.write(t, ev)
+		}, 3, "内容与 hash 不符"},
+		{"truncated tail", func(t *testing.T, f auditFixture) {
+			ev := f.events(t)
+			f.write(t, ev[:len(ev)-2])
+		}, 4, "末尾的记录缺失"},
+		{"deleted first record", func(t *testing.T, f auditFixture) {
+			f.write(t, f.events(t)[1:])
+		}, 2, "链的开头被删除"},
+		{"deleted middle record", func(t *testing.T, f auditFixture) {
+			ev := f.events(t)
+			f.write(t, append(ev[:2:2], ev[3:]...))
+		}, 4, "不衔接"},
+		{"prepended legacy record", func(t *testing.T, f auditFixture) {
+			f.write(t, append([]AuditEvent{{Actor: AuditActorAdmin, Action: AuditKeyRevoke}}, f.events(t)...))
+		}, 1, "缺少 hash"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newAuditFixture(t)
+			f.record(t, f.open(t, key), 5)
+			wantIntact(t, f.open(t, key))
+			tc.tamper(t, f)
+			wantBroken(t, f.open(t, key), tc.at, tc.reason)
+		})
+	}
+}
+
+// 配置密钥后，不知道密钥的人把整条链改写成不带密钥的 SHA-256 并同步链头，校验仍要失败
+func TestAuditVerifyUnkeyedRewrite(t *testing.T) {
+	f := newAuditFixture(t)
+	f.record(t, f.open(t, ""), 2)
+	l := f.open(t, "secret")
+	f.record(t, l, 3)
+	if v := wantIntact(t, l); v.Unkeyed != 2 {
+		t.Fatalf("unkeyed = %d, want 2", v.Unkeyed)
+	}
+
+	ev := f.events(t)
+	prev := ""
+	for i := range ev {
+		ev[i].Keyed, ev[i].PrevHash = false, prev
+		if i == 3 {
+			ev[i].Detail = "forged"
+		}
+		ev[i].Hash = ev[i].digest(nil)
+		prev = ev[i].Hash
+	}
+	f.write(t, ev)
+	var head auditHead
+	if err := readJSONFile(f.head, &head); err != nil {
+		t.Fatal(err)
+	}
+	head.Hash = prev
+	if err := writeJSONFile(f.head, head); err != nil {
+		t.Fatal(err)
+	}
+
+	// 运行中的实例以内存中的链头为准
+	wantBroken(t, l, 3, "没有密钥签名")
+	// 重启后链头签名不符
+	wantBroken(t, f.open(t, "secret"), 3, "没有密钥签名")
+	head.KeyedFrom = 100
+	if err := writeJSONFile(f.head, head); err != nil {
+		t.Fatal(err)
+	}
+	wantBroken(t, f.open(t, "secret"), 5, "链头签名无效")
+}
+
+// 启用 hash 链之前的记录只接受链头记下的条数
+func TestAuditVerifyLegacy(t *testing.T) {
+	f := newAuditFixture(t)
+	f.write(t, []AuditEvent{{Actor: AuditActorAdmin, Action: AuditLogin}, {Actor: AuditActorAdmin, Action: AuditKeyGenerate}})
+	l := f.open(t, "secret")
+	f.record(t, l, 2)
+	if v := wantIntact(t, f.open(t, "secret")); v.Legacy != 2 || v.Records != 4 {
+		t.Fatalf("verify = %+v, want 2 legacy of 4", v)
+	}
+
+	ev := f.events(t)
+	f.write(t, ev[1:])
+	wantBroken(t, f.open(t, "secret"), 1, "启用 hash 链之前的记录被删除")
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackupScheduleConfig 定时备份配置
+type BackupScheduleConfig struct {
+	Interval   time.Duration // 两次备份的间隔
+	FullEvery  int           // 每条增量链最多的备份数（含开头的完整备份），到了就重新做完整备份
+	KeepDaily  int           // 保留最近多少天每天的最后一个备份
+	KeepWeekly int           // 保留最近多少周每周的最后一个备份
+}
+
+// StoredBackup 已保存的一个备份
+type StoredBackup struct {
+	ID        string
+	Kind      string
+	Parent    string
+	CreatedAt time.Time
+	Files     int
+	Size      int64 // 压缩后的大小
+	Entries   int
+}
+
+// ArchiveName 备份包在存储中的文件名
+func (b StoredBackup) ArchiveName() string { return b.ID + ".tar.gz" }
+
+// BackupStatus 定时备份的运行状态，保存在 backup-status.json
+type BackupStatus struct {
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	LastBackup  string    `json:"last_backup,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+	Pruned      int       `json:"pruned"` // 上次清理删除的备份数
+	Running     bool      `json:"-"`
+}
+
+// BackupScheduler 按间隔自动备份到本地目录或 blob 存储（prefix 下），
+// 在同一条链上做增量备份，并按保留规则清理旧备份。
+// 每个备份保存为 <id>.tar.gz，清单另存为 <id>.json 方便列出与计算下一次增量
+type BackupScheduler struct {
+	backups    *BackupService
+	store      ObjectStorage
+	prefix     string
+	statusPath string
+	cfg        BackupScheduleConfig
+
+	run    sync.Mutex // 同一时间只跑一个备份
+	mu     sync.Mutex
+	status BackupStatus
+}
+
+func NewBackupScheduler(backups *BackupService, store ObjectStorage, prefix, statusPath string, cfg BackupScheduleConfig) *BackupScheduler {
+	return &BackupScheduler{backups: backups, store: store, prefix: prefix, statusPath: statusPath, cfg: cfg}
+}
+
+func (s *BackupScheduler) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return readJSONFile(s.statusPath, &s.status)
+}
+
+// Config 当前配置
+func (s *BackupScheduler) Config() BackupScheduleConfig { return s.cfg }
+
+func (s *BackupScheduler) Status() BackupStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Start 每分钟检查一次是否到了备份时间；以上次执行时间为准，重启后不会立即重复备份
+func (s *BackupScheduler) Start() {
+	go func() {
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+		for range t.C {
+			if time.Since(s.Status().LastRun) < s.cfg.Interval {
+				continue
+			}
+			if _, err := s.Run(); err != nil && !errors.Is(err, ErrBackupRunning) {
+				log.Printf("scheduled backup failed: %v", err)
+			}
+		}
+	}()
+}
+
+var ErrBackupRunning = errors.New("backup already running")
+
+// Run 立即执行一次备份并清理过期的备份
+func (s *BackupScheduler) Run() (*StoredBackup, error) {
+	if !s.run.TryLock() {
+		return nil, ErrBackupRunning
+	}
+	defer s.run.Unlock()
+	s.setStatus(func(st *BackupStatus) { st.Running, st.LastRun = true, time.Now() })
+
+	b, err := s.backup()
+	pruned := 0
+	if err == nil {
+		if pruned, err = s.prune(); err != nil {
+			err = fmt.Errorf("prune: %w", err)
+		}
+	}
+	s.setStatus(func(st *BackupStatus) {
+		st.Running = false
+		if b != nil {
+			st.LastSuccess, st.LastBackup, st.Pruned = b.CreatedAt, b.ID, pruned
+		}
+		if err != nil {
+			st.LastError, st.LastErrorAt = err.Error(), time.Now()
+		} else {
+			st.LastError = ""
+		}
+	})
+	return b, err
+}
+
+func (s *BackupScheduler) setStatus(fn func(st *BackupStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.status)
+	if err := writeJSONFile(s.statusPath, s.status); err != nil {
+		log.Printf("save backup status: %v", err)
+	}
+}
+
+// backup 链上的备份数不足 FullEvery 时在最新的备份上做增量，否则做完整备份
+func (s *BackupScheduler) backup() (*StoredBackup, error) {
+	list, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var prev *BackupManifest
+	if n := len(list); n > 0 && chainLength(list, list[n-1].ID) < s.cfg.FullEvery {
+		if prev, err = s.Manifest(list[n-1].ID); err != nil {
+			log.Printf("backup: read manifest %s: %v, doing a full backup", list[n-1].ID, err)
+			prev = nil
+		}
+	}
+
+	// 先写到临时文件，完成后再放进存储，存储里不会出现写了一半的备份
+	tmp, err := os.CreateTemp("", "mailtracker-backup-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	var man *BackupManifest
+	if prev != nil {
+		man, err = s.backups.BackupSince(tmp, prev)
+	} else {
+		man, err = s.backups.Backup(tmp)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := StoredBackup{ID: man.ID, Kind: man.Kind, Parent: man.Parent, CreatedAt: man.CreatedAt, Files: len(man.Files), Entries: man.Entries}
+	if fi, err := os.Stat(tmp.Name()); err == nil {
+		b.Size = fi.Size()
+	}
+	if err := putFile(s.store, s.prefix+b.ArchiveName(), tmp.Name()); err != nil {
+		return nil, err
+	}
+	// 清单最后写：List 只认有清单的备份
+	mb, _ := json.Marshal(struct {
+		*BackupManifest
+		ArchiveSize int64 `json:"archive_size"`
+	}{man, b.Size})
+	if err := s.store.Put(s.prefix+b.ID+".json", mb, "application/json"); err != nil {
+		return nil, err
+	}
+	log.Printf("backup %s: %d files, %d bytes", b.ID, b.Files, b.Size)
+	return &b, nil
+}
+
+// putFile 把本地文件放进存储；本地存储直接移动，其它存储流式上传，避免把大文件读进内存
+func putFile(store ObjectStorage, name, src string) error {
+	if ls, ok := store.(*LocalStorage); ok {
+		return ls.PutFile(name, src)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return store.PutStream(name, f, fi.Size(), "application/gzip")
+}
+
+// Manifest 读取已保存备份的清单
+func (s *BackupScheduler) Manifest(id string) (*BackupManifest, error) {
+	rc, _, err := s.store.Get(s.prefix + id + ".json")
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var man BackupManifest
+	if err := json.NewDecoder(rc).Decode(&man); err != nil {
+		return nil, err
+	}
+	return &man, nil
+}
+
+// Open 读取备份包
+func (s *BackupScheduler) Open(id string) (io.ReadCloser, ObjectInfo, error) {
+	return s.store.Get(s.prefix + id + ".tar.gz")
+}
+
+// List 已保存的备份，从旧到新
+func (s *BackupScheduler) List() ([]StoredBackup, error) {
+	var list []StoredBackup
+	err := s.store.List(s.prefix, func(o ObjectInfo) error {
+		rel := strings.TrimPrefix(o.Name, s.prefix)
+		if strings.Contains(rel, "/") || path.Ext(rel) != ".json" {
+			return nil
+		}
+		rc, _, err := s.store.Get(o.Name)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		var m struct {
+			BackupManifest
+			ArchiveSize int64 `json:"archive_size"`
+		}
+		if err := json.NewDecoder(rc).Decode(&m); err != nil || m.ID == "" {
+			return nil // 不是备份清单
+		}
+		list = append(list, StoredBackup{ID: m.ID, Kind: m.Kind, Parent: m.Parent, CreatedAt: m.CreatedAt,
+			Files: len(m.Files), Size: m.ArchiveSize, Entries: m.Entries})
+		return nil
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, err
+}
+
+// Chain 恢复某个备份需要的所有备份，从完整备份开始
+func Chain(list []StoredBackup, id string) []StoredBackup {
+	byID := map[string]StoredBackup{}
+	for _, b := range list {
+		byID[b.ID] = b
+	}
+	var out []StoredBackup
+	for b, ok := byID[id]; ok; b, ok = byID[b.Parent] {
+		out = append([]StoredBackup{b}, out...)
+		if b.Kind == BackupFull || len(out) > len(list) {
+			break
+		}
+	}
+	return out
+}
+
+func chainLength(list []StoredBackup, id string) int {
+	c := Chain(list, id)
+	if len(c) == 0 || c[0].Kind != BackupFull {
+		return 1 << 30 // 链不完整，重新做完整备份
+	}
+	return len(c)
+}
+
+// retained 按保留规则要留下的备份：每天最后一个（最近 KeepDaily 天）、每周最后一个（最近 KeepWeekly 周），
+// 以及它们所依赖的整条链；最新的备份总是保留
+func retained(list []StoredBackup, daily, weekly int) map[string]bool {
+	keep := map[string]bool{}
+	days, weeks := map[string]bool{}, map[string]bool{}
+	for i := len(list) - 1; i >= 0; i-- {
+		b := list[i]
+		if i == len(list)-1 {
+			keep[b.ID] = true
+		}
+		t := b.CreatedAt.Local()
+		if d := t.Format("2006-01-02"); !days[d] && len(days) < daily {
+			days[d], keep[b.ID] = true, true
+		}
+		y, w := t.ISOWeek()
+		if k := fmt.Sprintf("%d-%d", y, w); !weeks[k] && len(weeks) < weekly {
+			weeks[k], keep[b.ID] = true, true
+		}
+	}
+	for id := range keep {
+		for _, b := range Chain(list, id) {
+			keep[b.ID] = true
+		}
+	}
+	return keep
+}
+
+// prune 删除不再需要保留的备份，返回删除的数量
+func (s *BackupScheduler) prune() (int, error) {
+	list, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	keep := retained(list, s.cfg.KeepDaily, s.cfg.KeepWeekly)
+	removed := 0
+	for _, b := range list {
+		if keep[b.ID] {
+			continue
+		}
+		// 先删清单：中途失败时剩下的备份包不会被当作可用备份
+		if err := s.store.Delete(s.prefix + b.ID + ".json"); err != nil {
+			return removed, err
+		}
+		if err := s.store.Delete(s.prefix + b.ArchiveName()); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,727 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailtrackerProject/models"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 备份包格式：tar.gz，文件路径相对于数据目录（/ 分隔），最后一个文件是 manifest.json，
+// 记录所有文件的大小与 SHA-256。增量备份只包含变化的文件，恢复时需要从它所基于的完整备份开始依次应用。图片 blob 无论存放在本地还是 S3 都以 blobs/<aa>/<hash> 写入，
+// 派生图缓存不备份，恢复后按需重新生成。
+const (
+	BackupFormat   = 1
+	backupManifest = "manifest.json"
+)
+
+// 恢复方式
+const (
+	RestoreMerge   = "merge"   // 只补充本地没有的 key、条目与配置，已有的保持不变
+	RestoreReplace = "replace" // 用备份替换本地数据，原数据移到 pre-restore-<时间>/ 下
+)
+
+// backupConfigFiles 除条目外需要备份的配置文件。audit.head 排在 audit.ndjson 之前读取，
+// 备份中的日志不会比链头旧
+var backupConfigFiles = []string{"keys.json", "notifications.json", "webhooks/endpoints.json", "webhooks/deliveries.json",
+	"checkpoints.json", "ip-salt", "audit.head", "audit.ndjson"}
+
+// backupKeepLocal 合并恢复时只在本地没有时才从备份中取的文件：盐与审计日志不能合并
+var backupKeepLocal = []string{"ip-salt", "audit.head", "audit.ndjson"}
+
+// backupTopLevel 替换恢复时整体换掉的数据目录下的文件与目录
+var backupTopLevel = []string{"keys.json", "notifications.json", "webhooks", "checkpoints.json", "entries", "ip-salt", "audit.head", "audit.ndjson"}
+
+var (
+	backupEntryFile = regexp.MustCompile(`^entries/([A-Za-z0-9_-]{1,64})/(entry\.json|history(\.[0-9]+)?\.ndjson|history\.rotated|images/[^/]+)$`)
+	backupBlobFile  = regexp.MustCompile(`^blobs/([0-9a-f]{2})/([0-9a-f]{64})$`)
+)
+
+// 备份类型
+const (
+	BackupFull        = "full"
+	BackupIncremental = "incr" // 只包含相对上一次备份有变化的文件
+)
+
+// BackupFile 备份中的一个文件
+type BackupFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"mtime,omitempty"` // 下次增量备份时用来跳过未改动的文件
+}
+
+func (f BackupFile) same(o BackupFile) bool {
+	return f.Path == o.Path && f.Size == o.Size && f.SHA256 == o.SHA256
+}
+
+// BackupManifest 备份清单。增量备份的 Files 只有本包中的文件，
+// Inventory 是备份时刻的完整文件列表；完整备份的 Files 即完整列表
+type BackupManifest struct {
+	Format    int          `json:"format"`
+	ID        string       `json:"id"`
+	Kind      string       `json:"kind"`
+	Parent    string       `json:"parent,omitempty"` // 增量备份所基于的上一次备份
+	CreatedAt time.Time    `json:"created_at"`
+	Keys      int          `json:"keys"`
+	Entries   int          `json:"entries"`
+	Blobs     int          `json:"blobs"` // 本包中的 blob 数
+	Files     []BackupFile `json:"files"`
+	Inventory []BackupFile `json:"inventory,omitempty"`
+}
+
+// All 备份时刻的完整文件列表
+func (m *BackupManifest) All() []BackupFile {
+	if m.Kind == BackupIncremental {
+		return m.Inventory
+	}
+	return m.Files
+}
+
+// Size 本包中文件的总大小（未压缩）
+func (m *BackupManifest) Size() int64 {
+	var n int64
+	for _, f := range m.Files {
+		n += f.Size
+	}
+	return n
+}
+
+// BackupService 生成整个数据目录的一致性快照。
+// 小文件（keys.json、各条目的 entry.json、配置）在服务锁内一次读入内存，
+// 访问记录按条目加锁复制，blob 内容不可变，复制期间标记为 pending 防止被 GC
+type BackupService struct {
+	dataDir string
+	keys    *KeysService
+	entries *EntriesService
+	blobs   *BlobStore
+	notify  *NotificationService
+	hooks   *WebhookService
+}
+
+func NewBackupService(dataDir string, keys *KeysService, entries *EntriesService, blobs *BlobStore, notify *NotificationService, hooks *WebhookService) *BackupService {
+	return &BackupService{dataDir: dataDir, keys: keys, entries: entries, blobs: blobs, notify: notify, hooks: hooks}
+}
+
+// snapshot 在锁内读取的部分
+type snapshot struct {
+	files   map[string][]byte // 配置与 entry.json
+	entries []string          // 有 entry.json 的 key
+	images  []string          // 所有条目引用的图片文件名
+	keys    int
+}
+
+// read 读取小文件；各服务的锁按固定顺序获取，期间所有写入都会等待
+func (s *BackupService) read() (*snapshot, error) {
+	if s.keys != nil {
+		s.keys.mu.RLock()
+		defer s.keys.mu.RUnlock()
+	}
+	if s.notify != nil {
+		s.notify.mu.RLock()
+		defer s.notify.mu.RUnlock()
+	}
+	if s.hooks != nil {
+		s.hooks.mu.Lock()
+		defer s.hooks.mu.Unlock()
+	}
+	s.entries.mu.Lock()
+	defer s.entries.mu.Unlock()
+
+	snap := &snapshot{files: map[string][]byte{}}
+	for _, name := range backupConfigFiles {
+		b, err := os.ReadFile(filepath.Join(s.dataDir, filepath.FromSlash(name)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if name == "audit.ndjson" {
+			// 审计日志不在上面的锁里，可能正在追加，去掉末尾不完整的行
+			b = b[:bytes.LastIndexByte(b, '\n')+1]
+		}
+		snap.files[name] = b
+	}
+	if s.keys != nil {
+		snap.keys = len(s.keys.keys)
+	}
+	keys, err := s.entries.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		b, err := os.ReadFile(s.entries.entryPath(key))
+		if err != nil {
+			return nil, err
+		}
+		var env EntryEnvelope
+		if err := json.Unmarshal(b, &env); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		snap.files["entries/"+key+"/entry.json"] = b
+		snap.entries = append(snap.entries, key)
+		snap.images = append(snap.images, env.ImageNames()...)
+	}
+	// 锁释放前标记，之后条目删掉图片也不会在复制完成前被回收
+	s.blobs.Pin(snap.images)
+	return snap, nil
+}
+
+// Backup 把完整快照写成 tar.gz，返回清单
+func (s *BackupService) Backup(w io.Writer) (*BackupManifest, error) {
+	return s.backup(w, nil)
+}
+
+// BackupSince 增量备份：只写入相对 prev 有变化的文件（按大小与修改时间判断，变了再比较内容 hash）
+func (s *BackupService) BackupSince(w io.Writer, prev *BackupManifest) (*BackupManifest, error) {
+	return s.backup(w, prev)
+}
+
+func (s *BackupService) backup(w io.Writer, prev *BackupManifest) (*BackupManifest, error) {
+	snap, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	defer s.blobs.Settle(snap.images)
+
+	now := time.Now()
+	man := &BackupManifest{Format: BackupFormat, Kind: BackupFull, CreatedAt: now, Keys: snap.keys, Entries: len(snap.entries)}
+	gz := gzip.NewWriter(w)
+	bw := &backupWriter{tw: tar.NewWriter(gz)}
+	if prev != nil {
+		man.Kind, man.Parent = BackupIncremental, prev.ID
+		bw.prev = map[string]BackupFile{}
+		for _, f := range prev.All() {
+			bw.prev[f.Path] = f
+		}
+	}
+	man.ID = now.Format("20060102-150405") + "-" + man.Kind
+
+	names := make([]string, 0, len(snap.files))
+	for name := range snap.files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := bw.writeBytes(name, snap.files[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range snap.entries {
+		if err := s.backupEntryFiles(bw, key); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	for _, h := range blobHashes(snap.images) {
+		// blob 以内容 hash 命名，上次备份过就不会变
+		if f, ok := bw.prev[blobName(h)]; ok {
+			bw.inventory = append(bw.inventory, f)
+			continue
+		}
+		rc, info, err := s.blobs.Open(h)
+		if errors.Is(err, ErrObjectNotFound) {
+			log.Printf("backup: blob %s missing", h)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = bw.writeReader(blobName(h), info.Size, info.ModTime, rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("blob %s: %w", h, err)
+		}
+		man.Blobs++
+	}
+
+	man.Files = bw.files
+	if prev != nil {
+		man.Inventory = bw.inventory
+	}
+	b, _ := json.MarshalIndent(man, "", "  ")
+	if err := bw.tw.WriteHeader(&tar.Header{Name: backupManifest, Mode: 0o644, Size: int64(len(b)), ModTime: man.CreatedAt}); err != nil {
+		return nil, err
+	}
+	if _, err := bw.tw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := bw.tw.Close(); err != nil {
+		return nil, err
+	}
+	return man, gz.Close()
+}
+
+// backupEntryFiles 复制条目的访问记录与旧格式的本地图片。
+// history.rotated 一并复制，归档全部被删除的条目恢复后游标序号不会回退
+func (s *BackupService) backupEntryFiles(bw *backupWriter, key string) error {
+	mu := s.entries.historyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range append(s.entries.historySegments(key), s.entries.rotatedPath(key)) {
+		if err := bw.writeFile("entries/"+key+"/"+filepath.Base(p), p); err != nil {
+			return err
+		}
+	}
+	dir := filepath.Join(s.entries.entryDir(key), "images")
+	des, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, d := range des {
+		if d.Type().IsRegular() && !strings.HasSuffix(d.Name(), ".tmp") {
+			if err := bw.writeFile("entries/"+key+"/images/"+d.Name(), filepath.Join(dir, d.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backupWriter 写 tar 的同时计算每个文件的 SHA-256。
+// prev 不为空时为增量备份，与上次相同的文件只记入 inventory 不写入
+type backupWriter struct {
+	tw        *tar.Writer
+	prev      map[string]BackupFile
+	files     []BackupFile // 写入本包的文件
+	inventory []BackupFile // 完整文件列表
+}
+
+func (bw *backupWriter) writeBytes(name string, b []byte) error {
+	sum := sha256.Sum256(b)
+	if f, ok := bw.prev[name]; ok && f.SHA256 == hex.EncodeToString(sum[:]) {
+		bw.inventory = append(bw.inventory, f)
+		return nil
+	}
+	return bw.writeReader(name, int64(len(b)), time.Now(), bytes.NewReader(b))
+}
+
+// writeFile 复制本地文件；不存在时跳过
+func (bw *backupWriter) writeFile(name, p string) error {
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if old, ok := bw.prev[name]; ok && old.Size == fi.Size() {
+		if old.ModTime.Equal(fi.ModTime()) {
+			bw.inventory = append(bw.inventory, old)
+			return nil
+		}
+		// 修改时间变了但内容可能没变（如重写后内容相同），比较 hash
+		h := sha256.New()
+		if _, err := io.Copy(h, io.LimitReader(f, fi.Size())); err != nil {
+			return err
+		}
+		if hex.EncodeToString(h.Sum(nil)) == old.SHA256 {
+			old.ModTime = fi.ModTime()
+			bw.inventory = append(bw.inventory, old)
+			return nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return bw.writeReader(name, fi.Size(), fi.ModTime(), f)
+}
+
+func (bw *backupWriter) writeReader(name string, size int64, mod time.Time, r io.Reader) error {
+	if err := bw.tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: mod}); err != nil {
+		return err
+	}
+	h := sha256.New()
+	// 只复制 size 字节：追加写入的文件在复制期间变长也不会破坏 tar
+	n, err := io.Copy(io.MultiWriter(bw.tw, h), io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s: short read", name)
+	}
+	f := BackupFile{Path: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)), ModTime: mod}
+	bw.files = append(bw.files, f)
+	bw.inventory = append(bw.inventory, f)
+	return nil
+}
+
+// RestoreReport 恢复结果
+type RestoreReport struct {
+	Mode     string
+	Manifest *BackupManifest
+	Keys     int      // 新增（合并）或恢复（替换）的 key 数
+	Entries  int      // 恢复的条目数
+	Blobs    int      // 新写入存储的 blob 数
+	Skipped  []string // 合并时本地已存在而跳过的条目
+	SavedTo  string   // 替换前原数据的保存位置
+}
+
+// ErrDataDirLocked 数据目录正被另一个进程使用，通常是服务还在运行
+var ErrDataDirLocked = errors.New("data dir is in use by another process")
+
+// RestoreBackup 校验备份包并恢复到 dataDir。调用方须持有 LockDataDir 的锁，
+// 服务运行时也持有该锁，因此只能在服务停止后通过命令行执行；恢复后重新启动服务会重建 blob 引用计数。
+// archives 为一个完整备份，后面可以跟着基于它的若干增量备份，按时间顺序排列
+func RestoreBackup(dataDir string, storage ObjectStorage, archives []io.Reader, mode string) (*RestoreReport, error) {
+	if mode != RestoreMerge && mode != RestoreReplace {
+		return nil, fmt.Errorf("unknown restore mode %q", mode)
+	}
+	if len(archives) == 0 {
+		return nil, errors.New("no backup given")
+	}
+	stage := filepath.Join(dataDir, "restore-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(stage, 0o755); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stage)
+
+	// 依次解压，后面的增量覆盖前面的文件
+	got := map[string]BackupFile{}
+	var man *BackupManifest
+	for i, r := range archives {
+		m, err := extractBackup(stage, r, got)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup #%d: %w", i+1, err)
+		}
+		switch {
+		case i == 0 && m.Kind == BackupIncremental: // 旧版备份没有 kind，按完整备份处理
+			return nil, fmt.Errorf("backup %s is incremental, restore its full backup first", m.ID)
+		case i > 0 && (m.Kind != BackupIncremental || m.Parent != man.ID):
+			return nil, fmt.Errorf("backup %s does not follow %s", m.ID, man.ID)
+		}
+		man = m
+	}
+	if err := verifyStage(stage, man, got); err != nil {
+		return nil, fmt.Errorf("invalid backup: %w", err)
+	}
+	rep := &RestoreReport{Mode: mode, Manifest: man}
+
+	// 先写 blob，条目写入时引用的图片一定已经存在
+	for _, f := range man.All() {
+		if !backupBlobFile.MatchString(f.Path) {
+			continue
+		}
+		if _, err := storage.Stat(f.Path); err == nil {
+			continue
+		} else if !errors.Is(err, ErrObjectNotFound) {
+			return rep, err
+		}
+		b, err := os.ReadFile(filepath.Join(stage, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return rep, err
+		}
+		if err := storage.Put(f.Path, b, ""); err != nil {
+			return rep, fmt.Errorf("blob %s: %w", f.Path, err)
+		}
+		rep.Blobs++
+	}
+
+	if mode == RestoreReplace {
+		return rep, restoreReplace(dataDir, stage, rep)
+	}
+	return rep, restoreMerge(dataDir, stage, rep)
+}
+
+// extractBackup 解压一个备份包到 stage，检查路径白名单、blob 内容与文件名一致，
+// 并核对包内文件与清单的 Files 完全一致；解压出的文件记入 got
+func extractBackup(stage string, r io.Reader, got map[string]BackupFile) (*BackupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	inArchive := map[string]BackupFile{}
+	var man *BackupManifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%s: not a regular file", name)
+		}
+		if name == backupManifest {
+			man = &BackupManifest{}
+			if err := json.NewDecoder(io.LimitReader(tr, 64<<20)).Decode(man); err != nil {
+				return nil, fmt.Errorf("manifest: %w", err)
+			}
+			continue
+		}
+		if path.Clean(name) != name || !validBackupPath(name) {
+			return nil, fmt.Errorf("unexpected file %q", name)
+		}
+		if _, dup := inArchive[name]; dup {
+			return nil, fmt.Errorf("duplicate file %q", name)
+		}
+		p := filepath.Join(stage, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(p)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(f, h), tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		if m := backupBlobFile.FindStringSubmatch(name); m != nil && m[2] != sum {
+			return nil, fmt.Errorf("%s: content does not match its hash", name)
+		}
+		inArchive[name] = BackupFile{Path: name, Size: n, SHA256: sum}
+	}
+
+	if man == nil {
+		return nil, errors.New("manifest.json missing")
+	}
+	if man.Format != BackupFormat {
+		return nil, fmt.Errorf("unsupported backup format %d", man.Format)
+	}
+	listed := map[string]bool{}
+	for _, f := range man.Files {
+		g, ok := inArchive[f.Path]
+		if !ok {
+			return nil, fmt.Errorf("%s: listed in manifest but missing", f.Path)
+		}
+		if !g.same(f) {
+			return nil, fmt.Errorf("%s: checksum mismatch", f.Path)
+		}
+		listed[f.Path] = true
+	}
+	for name, f := range inArchive {
+		if !listed[name] {
+			return nil, fmt.Errorf("%s: not listed in manifest", name)
+		}
+		got[name] = f
+	}
+	return man, nil
+}
+
+// verifyStage 核对解压结果与最后一个清单的完整文件列表一致（删除已不存在的旧文件），
+// 再做内容层面的检查：JSON 都能解析，条目引用的 blob 都在备份中
+func verifyStage(stage string, man *BackupManifest, got map[string]BackupFile) error {
+	want := map[string]bool{}
+	blobs := map[string]bool{}
+	for _, f := range man.All() {
+		g, ok := got[f.Path]
+		if !ok {
+			return fmt.Errorf("%s: missing, an incremental backup in the chain may be absent", f.Path)
+		}
+		if !g.same(f) {
+			return fmt.Errorf("%s: checksum mismatch", f.Path)
+		}
+		want[f.Path] = true
+		if m := backupBlobFile.FindStringSubmatch(f.Path); m != nil {
+			blobs[m[2]] = true
+		}
+	}
+	for name := range got {
+		if !want[name] {
+			if err := os.Remove(filepath.Join(stage, filepath.FromSlash(name))); err != nil {
+				return err
+			}
+			delete(got, name)
+		}
+	}
+
+	for name := range got {
+		if strings.HasSuffix(name, ".json") {
+			b, err := os.ReadFile(filepath.Join(stage, filepath.FromSlash(name)))
+			if err != nil {
+				return err
+			}
+			if !json.Valid(b) {
+				return fmt.Errorf("%s: invalid json", name)
+			}
+		}
+		if !strings.HasSuffix(name, "/entry.json") {
+			continue
+		}
+		b, _ := os.ReadFile(filepath.Join(stage, filepath.FromSlash(name)))
+		var env EntryEnvelope
+		if err := json.Unmarshal(b, &env); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, h := range blobHashes(env.ImageNames()) {
+			if !blobs[h] {
+				return fmt.Errorf("%s: image %s not in backup", name, h)
+			}
+		}
+	}
+	return nil
+}
+
+func validBackupPath(name string) bool {
+	return slices.Contains(backupConfigFiles, name) || backupEntryFile.MatchString(name) || backupBlobFile.MatchString(name)
+}
+
+// restoreReplace 原有的 key、条目与配置整体移到 pre-restore-<时间>/，再换成备份中的。
+// 中途出错时把已换入的移回 stage、原数据移回原处，数据目录不会处于新旧混合的状态
+func restoreReplace(dataDir, stage string, rep *RestoreReport) (err error) {
+	saved := filepath.Join(dataDir, "pre-restore-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(saved, 0o755); err != nil {
+		return err
+	}
+	rep.SavedTo = saved
+	var movedOut, movedIn []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		rolledBack := true
+		for _, name := range slices.Backward(movedIn) {
+			if rerr := os.Rename(filepath.Join(dataDir, name), filepath.Join(stage, name)); rerr != nil {
+				log.Printf("restore rollback %s: %v", name, rerr)
+				rolledBack = false
+			}
+		}
+		for _, name := range slices.Backward(movedOut) {
+			if rerr := os.Rename(filepath.Join(saved, name), filepath.Join(dataDir, name)); rerr != nil {
+				log.Printf("restore rollback %s: %v", name, rerr)
+				rolledBack = false
+			}
+		}
+		if rolledBack {
+			_ = os.Remove(saved)
+			rep.SavedTo = ""
+			err = fmt.Errorf("%w (rolled back, local data unchanged)", err)
+		} else {
+			err = fmt.Errorf("%w (rollback incomplete, original data is in %s)", err, saved)
+		}
+	}()
+	for _, name := range backupTopLevel {
+		err := os.Rename(filepath.Join(dataDir, name), filepath.Join(saved, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		movedOut = append(movedOut, name)
+	}
+	for _, name := range backupTopLevel {
+		err := os.Rename(filepath.Join(stage, name), filepath.Join(dataDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		movedIn = append(movedIn, name)
+	}
+	var keys []json.RawMessage
+	_ = readJSONFile(filepath.Join(dataDir, "keys.json"), &keys)
+	rep.Keys = len(keys)
+	des, _ := os.ReadDir(filepath.Join(dataDir, "entries"))
+	for _, d := range des {
+		if d.IsDir() && models.ValidKey(d.Name()) {
+			rep.Entries++
+		}
+	}
+	return nil
+}
+
+// restoreMerge 按 key / id 合并：本地已有的保持不变，只补充备份中多出来的
+func restoreMerge(dataDir, stage string, rep *RestoreReport) error {
+	for _, m := range []struct{ file, id string }{
+		{"keys.json", "key"},
+		{"notifications.json", "id"},
+		{"webhooks/endpoints.json", "id"},
+		{"webhooks/deliveries.json", "id"},
+		{"checkpoints.json", "id"},
+	} {
+		n, err := mergeJSONList(filepath.Join(dataDir, filepath.FromSlash(m.file)), filepath.Join(stage, filepath.FromSlash(m.file)), m.id)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.file, err)
+		}
+		if m.file == "keys.json" {
+			rep.Keys = n
+		}
+	}
+	for _, name := range backupKeepLocal {
+		dst := filepath.Join(dataDir, name)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := os.Rename(filepath.Join(stage, name), dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	des, err := os.ReadDir(filepath.Join(stage, "entries"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dataDir, "entries"), 0o755); err != nil {
+		return err
+	}
+	for _, d := range des {
+		dst := filepath.Join(dataDir, "entries", d.Name())
+		if _, err := os.Stat(dst); err == nil {
+			rep.Skipped = append(rep.Skipped, d.Name())
+			continue
+		}
+		if err := os.Rename(filepath.Join(stage, "entries", d.Name()), dst); err != nil {
+			return err
+		}
+		rep.Entries++
+	}
+	return nil
+}
+
+// mergeJSONList 把 incoming 中 id 字段在 local 里不存在的对象追加到 local，返回追加的数量
+func mergeJSONList(local, incoming, id string) (int, error) {
+	var in []map[string]json.RawMessage
+	if err := readJSONFile(incoming, &in); err != nil || len(in) == 0 {
+		return 0, err
+	}
+	var out []map[string]json.RawMessage
+	if err := readJSONFile(local, &out); err != nil {
+		return 0, err
+	}
+	seen := map[string]bool{}
+	for _, o := range out {
+		seen[string(o[id])] = true
+	}
+	added := 0
+	for _, o := range in {
+		if !seen[string(o[id])] {
+			out = append(out, o)
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, writeJSONFile(local, out)
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func manifestPaths(files []BackupFile) []string {
+	out := make([]string, 0, len(files))
+	for _, f := range files {
+		out = append(out, f.Path)
+	}
+	return out
+}
+
+func restoreInto(t *testing.T, dir, mode string, archives ...[]byte) (*RestoreReport, error) {
+	t.Helper()
+	rs := make([]io.Reader, 0, len(archives))
+	for _, b := range archives {
+		rs = append(rs, bytes.NewReader(b))
+	}
+	return RestoreBackup(dir, NewLocalStorage(filepath.Join(dir, "blobs")), rs, mode)
+}
+
+// openRestored 按服务启动时的方式加载恢复后的数据目录
+func openRestored(t *testing.T, dir string) *EntriesService {
+	t.Helper()
+	keys := NewKeysService(filepath.Join(dir, "keys.json"))
+	if err := keys.Load(); err != nil {
+		t.Fatal(err)
+	}
+	blobs := NewBlobStore(dir, NewLocalStorage(filepath.Join(dir, "blobs")), 0)
+	if err := blobs.Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	return NewEntriesService(dir, keys, blobs)
+}
+
+// 完整备份 + 增量备份恢复后，条目、图片、访问记录与轮转序号都与原数据一致
+func TestBackupRestoreChain(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 2)
+	backups := NewBackupService(entries.dataDir, entries.keys, entries, entries.blobs, nil, nil)
+	k0, k1 := kis[0].Key, kis[1].Key
+
+	hash, err := entries.blobs.Put([]byte("image"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entries.SaveData(k0, EntryData{Images: ImageList{{File: hash + ".png"}}}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	entries.blobs.Settle([]string{hash})
+	// 不保留归档：轮转过的序号只记在 history.rotated 中
+	entries.SetHistoryRotation(200, 0)
+	record := func(n int) {
+		for i := 0; i < n; i++ {
+			rec := HistoryRecord{Time: time.Now(), IP: "203.0.113.7", UA: "curl/8.0"}
+			if _, err := entries.RecorduaNewlinejson(k0, rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	record(6)
+	seq := entries.nextArchiveSeq(k0)
+	if seq <= 1 || len(entries.historyArchives(k0)) != 0 {
+		t.Fatalf("rotation: next seq %d, archives %v", seq, entries.historyArchives(k0))
+	}
+
+	var full bytes.Buffer
+	man, err := backups.Backup(&full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := "entries/" + k0 + "/history.rotated"
+	if paths := manifestPaths(man.Files); !slices.Contains(paths, rotated) || !slices.Contains(paths, blobName(hash)) {
+		t.Fatalf("full backup files = %v", paths)
+	}
+
+	if err := entries.SaveData(k1, EntryData{}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	var incr bytes.Buffer
+	man2, err := backups.BackupSince(&incr, man)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := manifestPaths(man2.Files)
+	if man2.Kind != BackupIncremental || man2.Parent != man.ID {
+		t.Fatalf("incremental manifest = %s / %s", man2.Kind, man2.Parent)
+	}
+	if !slices.Contains(paths, "entries/"+k1+"/entry.json") {
+		t.Fatalf("new entry not in incremental backup: %v", paths)
+	}
+	for _, p := range []string{"entries/" + k0 + "/entry.json", rotated, blobName(hash)} {
+		if slices.Contains(paths, p) {
+			t.Errorf("unchanged %s written again", p)
+		}
+	}
+	if !slices.Contains(manifestPaths(man2.All()), rotated) {
+		t.Fatalf("inventory misses %s", rotated)
+	}
+
+	want, err := entries.ReadUARecords(k0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	rep, err := restoreInto(t, dst, RestoreReplace, full.Bytes(), incr.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Keys != 2 || rep.Entries != 2 || rep.Blobs != 1 {
+		t.Fatalf("report = %+v", rep)
+	}
+	restored := openRestored(t, dst)
+	if got := restored.nextArchiveSeq(k0); got != seq {
+		t.Errorf("next archive seq after restore = %d, want %d", got, seq)
+	}
+	if got, err := restored.ReadUARecords(k0); err != nil || len(got) != len(want) {
+		t.Errorf("restored history = %d records, %v; want %d", len(got), err, len(want))
+	}
+	if _, err := restored.LoadData(k1); err != nil {
+		t.Errorf("entry from incremental backup: %v", err)
+	}
+	if _, err := restored.blobs.Storage().Stat(blobName(hash)); err != nil {
+		t.Errorf("blob: %v", err)
+	}
+
+	// 只有完整备份时恢复到完整备份时的状态
+	dst = t.TempDir()
+	if _, err := restoreInto(t, dst, RestoreReplace, full.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "entries", k1, "entry.json")); err == nil {
+		t.Error("entry created after the full backup was restored")
+	}
+
+	// 增量备份不能单独恢复，也不能重复应用
+	if _, err := restoreInto(t, t.TempDir(), RestoreReplace, incr.Bytes()); err == nil {
+		t.Error("incremental backup restored without its full backup")
+	}
+	if _, err := restoreInto(t, t.TempDir(), RestoreReplace, full.Bytes(), incr.Bytes(), incr.Bytes()); err == nil {
+		t.Error("incremental backup applied twice")
+	}
+}
+
+// 合并恢复只补充本地没有的 key 与条目，本地已有的保持不变；替换恢复把原数据移到 pre-restore-*/
+func TestBackupRestoreMergeReplace(t *testing.T) {
+	_, backups, kis := newLifecycleFixture(t, 2)
+	var full bytes.Buffer
+	if _, err := backups.Backup(&full); err != nil {
+		t.Fatal(err)
+	}
+
+	local, localKeys := newEntriesFixture(t, 1)
+	if err := local.SaveData(localKeys[0].Key, EntryData{}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	// 本地已有同名条目
+	mine := filepath.Join(local.dataDir, "entries", kis[0].Key, "entry.json")
+	if err := os.MkdirAll(filepath.Dir(mine), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mine, []byte(`{"local":true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := restoreInto(t, local.dataDir, RestoreMerge, full.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Keys != 2 || rep.Entries != 1 || !slices.Equal(rep.Skipped, []string{kis[0].Key}) {
+		t.Fatalf("merge report = %+v", rep)
+	}
+	if b, _ := os.ReadFile(mine); string(b) != `{"local":true}` {
+		t.Errorf("local entry overwritten: %s", b)
+	}
+	merged := openRestored(t, local.dataDir)
+	for _, k := range []string{localKeys[0].Key, kis[0].Key, kis[1].Key} {
+		if _, ok := merged.keys.Get(k); !ok {
+			t.Errorf("key %s missing after merge", k)
+		}
+	}
+	if _, err := merged.LoadData(kis[1].Key); err != nil {
+		t.Errorf("entry not merged: %v", err)
+	}
+
+	rep, err = restoreInto(t, local.dataDir, RestoreReplace, full.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Keys != 2 || rep.Entries != 2 || rep.SavedTo == "" {
+		t.Fatalf("replace report = %+v", rep)
+	}
+	replaced := openRestored(t, local.dataDir)
+	if _, ok := replaced.keys.Get(localKeys[0].Key); ok {
+		t.Error("local key kept after replace")
+	}
+	if b, err := os.ReadFile(filepath.Join(rep.SavedTo, "entries", kis[0].Key, "entry.json")); err != nil || string(b) != `{"local":true}` {
+		t.Errorf("original data not saved: %s, %v", b, err)
+	}
+}
+
+// backupArchive 按备份格式打包任意文件，清单与内容一致，只有路径白名单能拒绝它
+func backupArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	man := BackupManifest{Format: BackupFormat, ID: "test", Kind: BackupFull, CreatedAt: time.Now()}
+	add := func(name string, b []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(b))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		man.Files = append(man.Files, BackupFile{Path: name, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])})
+		add(name, []byte(content))
+	}
+	b, _ := json.Marshal(man)
+	add(backupManifest, b)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRestoreRejectsUnexpectedPaths(t *testing.T) {
+	ok := map[string]string{
+		"keys.json":                       "[]",
+		"entries/ABC123/history.ndjson":   "",
+		"entries/ABC123/history.3.ndjson": "",
+		"entries/ABC123/history.rotated":  "3",
+	}
+	if _, err := restoreInto(t, t.TempDir(), RestoreReplace, backupArchive(t, ok)); err != nil {
+		t.Fatalf("valid archive rejected: %v", err)
+	}
+	for _, name := range []string{
+		"../outside",
+		"entries/ABC123/../../outside",
+		"entries/ABC123/notes.txt",
+		"entries/ABC123/history.rotated.bak",
+		"entries/ABC 123/history.ndjson",
+		"entries/ABC123/images/sub/a.png",
+		"pre-restore-20260101-000000/keys.json",
+		"audit.head.tmp",
+	} {
+		dir := t.TempDir()
+		_, err := restoreInto(t, dir, RestoreReplace, backupArchive(t, map[string]string{name: "x"}))
+		if err == nil || !strings.Contains(err.Error(), "unexpected file") {
+			t.Errorf("%s: err = %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "keys.json")); err == nil {
+			t.Errorf("%s: data dir changed", name)
+		}
+	}
+}
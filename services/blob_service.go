@@ -0,0 +1,359 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlobStore 内容寻址的图片存储：文件以内容的 SHA-256 命名，相同图片在多个条目间只存一份。
+// 引用计数来自所有 entry.json 中的 Images，启动时扫描重建，保存条目时增减。
+// 实际数据存放在 ObjectStorage（本地磁盘或 S3）中。
+type BlobStore struct {
+	storage    ObjectStorage
+	entriesDir string
+	// presign 大于 0 时，图片请求重定向到存储后端的限时直链
+	presign time.Duration
+
+	mu       sync.Mutex
+	refs     map[string]int  // hash -> 引用该 blob 的条目数
+	pending  map[string]int  // hash -> 已上传但尚未写入 entry.json 的次数
+	deleting map[string]bool // hash -> 正在从存储中删除，删除在锁外进行
+	deleted  *sync.Cond      // 每次删除结束时广播，等待同一 blob 删除完成的 Put 据此继续
+	gen      uint64          // refs 每次增减加一，GC 据此判断扫描期间引用是否有变化
+	// derivedVersion 当前的派生图版本，GC 删除其它版本的派生图
+	derivedVersion string
+}
+
+func NewBlobStore(dataDir string, storage ObjectStorage, presign time.Duration) *BlobStore {
+	s := &BlobStore{
+		storage:    storage,
+		entriesDir: filepath.Join(dataDir, "entries"),
+		presign:    presign,
+		refs:       map[string]int{},
+		pending:    map[string]int{},
+		deleting:   map[string]bool{},
+	}
+	s.deleted = sync.NewCond(&s.mu)
+	return s
+}
+
+const (
+	blobPrefix    = "blobs/"
+	derivedPrefix = "derived/"
+)
+
+// 按 hash 前两位分桶，避免单目录文件过多
+func blobName(hash string) string {
+	return blobPrefix + hash[:2] + "/" + hash
+}
+
+// BlobHash 从图片文件名（<sha256><ext>）中取出 hash；旧的 uuid 文件名返回 false
+func BlobHash(name string) (string, bool) {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	if len(base) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(base); err != nil {
+		return "", false
+	}
+	return strings.ToLower(base), true
+}
+
+// Put 写入内容并返回其 hash；已存在则直接复用。
+// 返回的 blob 处于 pending 状态，调用方写完 entry.json 后必须调用 Settle。
+func (s *BlobStore) Put(data []byte, contentType string) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	// 先标记 pending，GC 与 Settle 就不会在写入期间删除它；存储读写不持锁，慢的上传不阻塞其他请求。
+	// 同一 blob 正在被删除时等它删完，否则 Stat 看到的对象随后就会消失
+	s.mu.Lock()
+	s.pending[hash]++
+	for s.deleting[hash] {
+		s.deleted.Wait()
+	}
+	s.mu.Unlock()
+
+	name := blobName(hash)
+	_, err := s.storage.Stat(name)
+	if errors.Is(err, ErrObjectNotFound) {
+		err = s.storage.Put(name, data, contentType)
+	}
+	if err != nil {
+		// 取消 pending；没有条目引用时一并删除可能写了一半的对象
+		s.Settle([]string{hash})
+		return "", err
+	}
+	return hash, nil
+}
+
+// Open 读取 blob
+func (s *BlobStore) Open(hash string) (io.ReadCloser, ObjectInfo, error) {
+	return s.storage.Get(blobName(hash))
+}
+
+// PresignURL 返回 blob 的限时直链；未启用或后端不支持时返回空字符串
+func (s *BlobStore) PresignURL(hash string) (string, error) {
+	return s.PresignObject(blobName(hash))
+}
+
+// PresignObject 返回存储中任意对象（如派生图）的限时直链
+func (s *BlobStore) PresignObject(name string) (string, error) {
+	if s.presign <= 0 {
+		return "", nil
+	}
+	return s.storage.PresignGet(name, s.presign)
+}
+
+// Storage 底层对象存储，派生图缓存也放在这里
+func (s *BlobStore) Storage() ObjectStorage { return s.storage }
+
+// Settle 结束上传流程：取消 pending 标记，并立即删除没有被任何条目引用的 blob
+// （例如 PostEntry 在上传循环中途失败时留下的文件）
+func (s *BlobStore) Settle(names []string) {
+	var unused []string
+	s.mu.Lock()
+	for _, h := range blobHashes(names) {
+		if s.pending[h] > 0 {
+			s.pending[h]--
+		}
+		if s.pending[h] == 0 {
+			delete(s.pending, h)
+			if s.refs[h] == 0 {
+				unused = append(unused, h)
+			}
+		}
+	}
+	s.mu.Unlock()
+	for _, h := range unused {
+		s.deleteUnused(h, blobName(h))
+	}
+}
+
+// deleteUnused 在锁内确认 blob 没有引用、不在上传中后标记为删除中，在锁外删除存储中的对象。
+// 删除期间同一 blob 的 Put 会等待，不会与删除交错
+func (s *BlobStore) deleteUnused(hash, name string) bool {
+	s.mu.Lock()
+	if s.refs[hash] != 0 || s.pending[hash] != 0 || s.deleting[hash] {
+		s.mu.Unlock()
+		return false
+	}
+	s.deleting[hash] = true
+	s.mu.Unlock()
+
+	err := s.storage.Delete(name)
+
+	s.mu.Lock()
+	delete(s.deleting, hash)
+	s.deleted.Broadcast()
+	s.mu.Unlock()
+	return err == nil
+}
+
+// Pin 把已存在的 blob 标记为 pending，防止在读取期间（如备份）被 GC 回收；用完后调用 Settle
+func (s *BlobStore) Pin(names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range blobHashes(names) {
+		s.pending[h]++
+	}
+}
+
+// Retain / Release 在条目引用关系变化时增减计数（同一条目内重复的文件名只计一次）
+func (s *BlobStore) Retain(names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range blobHashes(names) {
+		s.refs[h]++
+	}
+	s.gen++
+}
+
+func (s *BlobStore) Release(names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range blobHashes(names) {
+		if s.refs[h] <= 1 {
+			delete(s.refs, h)
+			continue
+		}
+		s.refs[h]--
+	}
+	s.gen++
+}
+
+// Rebuild 扫描所有 entry.json 重建引用计数
+func (s *BlobStore) Rebuild() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refs, _, err := s.scanRefs()
+	if err != nil {
+		return err
+	}
+	s.refs = refs
+	return nil
+}
+
+// SetDerivedVersion 设置当前的派生图版本（水印配置指纹），GC 会删除其它版本的派生图；为空时不按版本清理
+func (s *BlobStore) SetDerivedVersion(v string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.derivedVersion = v
+}
+
+// scanRefs 统计每个 blob 被多少条目引用，并返回每个条目引用的图片（去掉扩展名的文件名），用于清理派生图
+func (s *BlobStore) scanRefs() (map[string]int, map[string]map[string]bool, error) {
+	refs := map[string]int{}
+	images := map[string]map[string]bool{}
+	dirs, err := os.ReadDir(s.entriesDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return refs, images, nil
+		}
+		return nil, nil, err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(s.entriesDir, d.Name(), "entry.json"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, nil, err
+		}
+		var env EntryEnvelope
+		// 解析失败时中止，宁可少删也不能误删
+		if err := json.Unmarshal(b, &env); err != nil {
+			return nil, nil, err
+		}
+		names := env.ImageNames()
+		for _, h := range blobHashes(names) {
+			refs[h]++
+		}
+		bases := make(map[string]bool, len(names))
+		for _, n := range names {
+			bases[strings.TrimSuffix(n, filepath.Ext(n))] = true
+		}
+		images[d.Name()] = bases
+	}
+	return refs, images, nil
+}
+
+// GC 删除不再被任何 entry.json 引用的 blob，以及过期的派生图。
+// 正在上传中的、以及修改时间在 grace 之内的文件会被跳过。
+// 扫描 entry.json、列举与删除存储中的对象都不持锁，删除每个 blob 前在锁内重新确认没有引用且不在上传中。
+func (s *BlobStore) GC(grace time.Duration) (int, error) {
+	s.mu.Lock()
+	gen, version := s.gen, s.derivedVersion
+	s.mu.Unlock()
+	refs, images, err := s.scanRefs()
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	// 扫描期间没有 Retain/Release 时才用扫描结果校正计数，否则可能覆盖掉刚发生的变化
+	if s.gen == gen {
+		s.refs = refs
+	}
+	s.mu.Unlock()
+
+	removed := 0
+	cutoff := time.Now().Add(-grace)
+	found := map[string]string{} // 对象名 -> hash
+	err = s.storage.List(blobPrefix, func(o ObjectInfo) error {
+		// 本地存储残留的临时文件也一并清理
+		hash, ok := BlobHash(strings.TrimSuffix(path.Base(o.Name), ".tmp"))
+		if ok && refs[hash] == 0 && !o.ModTime.After(cutoff) {
+			found[o.Name] = hash
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+	for name, hash := range found {
+		if s.deleteUnused(hash, name) {
+			removed++
+		}
+	}
+
+	// 派生图（derived/<key>/<原图文件名去掉扩展名>-<version>.jpg）在以下情况删除：
+	// 不是当前版本、条目已不存在、条目不再引用该原图（含旧的 uuid 文件名）。需要时会按需重新生成
+	err = s.storage.List(derivedPrefix, func(o ObjectInfo) error {
+		key, base, ver, ok := parseDerivedName(o.Name)
+		if !ok || o.ModTime.After(cutoff) {
+			return nil
+		}
+		if (version == "" || ver == version) && images[key][base] {
+			return nil
+		}
+		if err := s.storage.Delete(o.Name); err == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// parseDerivedName 拆分派生图对象名 derived/<key>/<base>-<version>.jpg；旧的 uuid 文件名本身含有 "-"，按最后一个拆分
+func parseDerivedName(name string) (key, base, version string, ok bool) {
+	rest, ok := strings.CutPrefix(name, derivedPrefix)
+	if !ok {
+		return "", "", "", false
+	}
+	key, file, ok := strings.Cut(rest, "/")
+	if !ok || strings.Contains(file, "/") {
+		return "", "", "", false
+	}
+	file = strings.TrimSuffix(file, path.Ext(file))
+	i := strings.LastIndex(file, "-")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	return key, file[:i], file[i+1:], true
+}
+
+// StartGC 后台定期执行 GC
+func (s *BlobStore) StartGC(interval, grace time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for range t.C {
+			n, err := s.GC(grace)
+			if err != nil {
+				log.Printf("blob gc failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("blob gc removed %d unreferenced blobs", n)
+			}
+		}
+	}()
+}
+
+// 提取文件名中的 hash 并去重
+func blobHashes(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		h, ok := BlobHash(n)
+		if !ok || seen[h] {
+			continue
+		}
+		seen[h] = true
+		out = append(out, h)
+	}
+	return out
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gatedStorage 让 Put 停在 gate 上，模拟很慢的上传
+type gatedStorage struct {
+	ObjectStorage
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedStorage) Put(name string, data []byte, contentType string) error {
+	g.started <- struct{}{}
+	<-g.gate
+	return g.ObjectStorage.Put(name, data, contentType)
+}
+
+// gatedDeleteStorage 让 Delete 停在 gate 上，模拟很慢的删除
+type gatedDeleteStorage struct {
+	ObjectStorage
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedDeleteStorage) Delete(name string) error {
+	g.started <- struct{}{}
+	<-g.gate
+	return g.ObjectStorage.Delete(name)
+}
+
+func TestBlobStoreS3(t *testing.T) {
+	f, st := newFakeS3(t)
+	blobs := NewBlobStore(t.TempDir(), st, 0)
+
+	hash, err := blobs.Put([]byte("image"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "pfx/" + blobName(hash)
+	if string(f.objects[name]) != "image" {
+		t.Fatalf("object %s not stored", name)
+	}
+	// 相同内容复用已有对象
+	if h2, err := blobs.Put([]byte("image"), "image/png"); err != nil || h2 != hash {
+		t.Fatalf("second Put = %s, %v", h2, err)
+	}
+	blobs.Retain([]string{hash + ".png"})
+	blobs.Settle([]string{hash + ".png"})
+	blobs.Settle([]string{hash + ".png"})
+	if _, ok := f.objects[name]; !ok {
+		t.Fatal("referenced blob deleted by Settle")
+	}
+
+	// 没有引用且已结束上传的 blob 被 GC 回收（GC 以 entry.json 为准，这里没有条目）
+	if n, err := blobs.GC(0); err != nil || n != 1 {
+		t.Fatalf("GC = %d, %v", n, err)
+	}
+	if _, ok := f.objects[name]; ok {
+		t.Fatal("unreferenced blob survived GC")
+	}
+}
+
+// 上传期间不持有锁，GC 等操作不必等待；上传完成前不会被 Settle 以外的操作删除
+func TestBlobStorePutUnlocked(t *testing.T) {
+	_, st := newFakeS3(t)
+	g := &gatedStorage{ObjectStorage: st, started: make(chan struct{}), gate: make(chan struct{})}
+	blobs := NewBlobStore(t.TempDir(), g, 0)
+
+	done := make(chan error, 1)
+	var hash string
+	go func() {
+		var err error
+		hash, err = blobs.Put([]byte("slow"), "image/jpeg")
+		done <- err
+	}()
+	<-g.started
+
+	gc := make(chan int, 1)
+	go func() {
+		n, _ := blobs.GC(0)
+		gc <- n
+	}()
+	select {
+	case <-gc:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC blocked by an in-flight Put")
+	}
+	close(g.gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Stat(blobName(hash)); err != nil {
+		t.Fatalf("pending blob: %v", err)
+	}
+	blobs.Settle([]string{hash})
+	if _, err := st.Stat(blobName(hash)); err != ErrObjectNotFound {
+		t.Fatalf("settled unreferenced blob: %v", err)
+	}
+}
+
+// 派生图：保留当前版本且条目仍引用原图的，其余（旧版本、条目已删除、不再引用、旧 uuid 文件名）删除
+func TestBlobStoreGCDerived(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 2)
+	blobs, st := entries.blobs, entries.blobs.Storage()
+	key := kis[0].Key
+	hash, err := blobs.Put([]byte("image"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const uuid = "0b3c2f4e-9a1d-4c6b-8e2f-5d7a9c1b3e4f"
+	if err := entries.SaveData(key, EntryData{Images: ImageList{{File: hash + ".png"}, {File: uuid + ".jpg"}}}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	if err := entries.SaveData(kis[1].Key, EntryData{}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	blobs.Settle([]string{hash})
+	blobs.SetDerivedVersion("v2")
+
+	keep := []string{
+		derivedPrefix + key + "/" + hash + "-v2.jpg",
+		derivedPrefix + key + "/" + uuid + "-v2.jpg",
+	}
+	drop := []string{
+		derivedPrefix + key + "/" + hash + "-v1.jpg",
+		derivedPrefix + key + "/" + uuid + "-v1.jpg",
+		derivedPrefix + key + "/" + strings.Repeat("ab", 32) + "-v2.jpg",
+		derivedPrefix + kis[1].Key + "/" + hash + "-v2.jpg",
+		derivedPrefix + "GONE1234/" + uuid + "-v2.jpg",
+	}
+	for _, name := range append(slices.Clone(keep), drop...) {
+		if err := st.Put(name, []byte("jpeg"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := blobs.GC(0); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range keep {
+		if _, err := st.Stat(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range drop {
+		if _, err := st.Stat(name); err != ErrObjectNotFound {
+			t.Errorf("%s not collected", name)
+		}
+	}
+	if _, err := st.Stat(blobName(hash)); err != nil {
+		t.Fatalf("referenced blob: %v", err)
+	}
+}
+
+// 删除在锁外进行：删除期间其它 blob 的读写不必等待，同一 blob 的 Put 等删除结束后重新上传
+func TestBlobStoreDeleteUnlocked(t *testing.T) {
+	local := NewLocalStorage(t.TempDir())
+	g := &gatedDeleteStorage{ObjectStorage: local, started: make(chan struct{}), gate: make(chan struct{})}
+	blobs := NewBlobStore(t.TempDir(), g, 0)
+	hash, err := blobs.Put([]byte("old"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settled := make(chan struct{})
+	go func() {
+		blobs.Settle([]string{hash})
+		close(settled)
+	}()
+	<-g.started
+
+	other := make(chan error, 1)
+	go func() {
+		h, err := blobs.Put([]byte("other"), "image/png")
+		blobs.Retain([]string{h})
+		blobs.Settle([]string{h})
+		other <- err
+	}()
+	select {
+	case err := <-other:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Put of another blob blocked by a slow delete")
+	}
+
+	again := make(chan error, 1)
+	go func() {
+		_, err := blobs.Put([]byte("old"), "image/png")
+		again <- err
+	}()
+	select {
+	case <-again:
+		t.Fatal("Put of a blob being deleted did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(g.gate)
+	<-settled
+	if err := <-again; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Stat(blobName(hash)); err != nil {
+		t.Fatalf("blob uploaded after delete: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Carrier 承运商及其单号格式
+type Carrier struct {
+	Code    string
+	Name    string
+	pattern *regexp.Regexp
+	s10     bool // UPU S10 格式，带校验位
+}
+
+// Carriers 自动识别时按顺序匹配，越具体的格式越靠前
+var Carriers = []Carrier{
+	{Code: "sf", Name: "顺丰速运", pattern: regexp.MustCompile(`^SF\d{12,13}$`)},
+	{Code: "ups", Name: "UPS", pattern: regexp.MustCompile(`^1Z[0-9A-Z]{16}$`)},
+	{Code: "ems", Name: "EMS", pattern: regexp.MustCompile(`^E[A-Z]\d{9}CN$`), s10: true},
+	{Code: "chinapost", Name: "中国邮政", pattern: regexp.MustCompile(`^([A-Z]{2}\d{9}CN|[19]\d{12})$`)},
+	{Code: "upu", Name: "国际邮政", pattern: regexp.MustCompile(`^[A-Z]{2}\d{9}[A-Z]{2}$`), s10: true},
+	{Code: "usps", Name: "USPS", pattern: regexp.MustCompile(`^(9[1-5]\d{18,20}|\d{20})$`)},
+}
+
+var (
+	ErrUnknownCarrier        = errors.New("unknown carrier")
+	ErrInvalidTrackingNumber = errors.New("invalid tracking number")
+)
+
+// NormalizeTrackingNumber 去掉空格、横线并转大写
+func NormalizeTrackingNumber(s string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "\t", "").Replace(strings.TrimSpace(s)))
+}
+
+func CarrierByCode(code string) (Carrier, bool) {
+	for _, c := range Carriers {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return Carrier{}, false
+}
+
+// Valid 单号格式与校验位是否正确
+func (c Carrier) Valid(number string) bool {
+	if !c.pattern.MatchString(number) {
+		return false
+	}
+	// 中国邮政的 S10 单号同样带校验位，13 位国内单号没有
+	if c.s10 || (c.Code == "chinapost" && len(number) == 13 && number[0] >= 'A') {
+		return s10CheckDigit(number[2:10]) == int(number[10]-'0')
+	}
+	return true
+}
+
+// DetectCarrier 根据单号格式识别承运商
+func DetectCarrier(number string) (Carrier, bool) {
+	for _, c := range Carriers {
+		if c.Valid(number) {
+			return c, true
+		}
+	}
+	return Carrier{}, false
+}
+
+// ValidateTrackingNumber 规范化单号；carrier 为空时自动识别。返回承运商代码与规范化后的单号
+func ValidateTrackingNumber(carrier, number string) (string, string, error) {
+	number = NormalizeTrackingNumber(number)
+	if carrier == "" {
+		c, ok := DetectCarrier(number)
+		if !ok {
+			return "", number, ErrUnknownCarrier
+		}
+		return c.Code, number, nil
+	}
+	c, ok := CarrierByCode(carrier)
+	if !ok {
+		return "", number, ErrUnknownCarrier
+	}
+	if !c.Valid(number) {
+		return c.Code, number, ErrInvalidTrackingNumber
+	}
+	return c.Code, number, nil
+}
+
+// s10CheckDigit UPU S10 标准：8 位序号加权 8,6,4,2,3,5,9,7 后按 11 取模
+func s10CheckDigit(serial string) int {
+	weights := [8]int{8, 6, 4, 2, 3, 5, 9, 7}
+	sum := 0
+	for i := 0; i < 8; i++ {
+		sum += int(serial[i]-'0') * weights[i]
+	}
+	switch d := 11 - sum%11; d {
+	case 10:
+		return 0
+	case 11:
+		return 5
+	default:
+		return d
+	}
+}
+
+// CarrierName 条目承运商的显示名称
+func (d EntryData) CarrierName() string {
+	if d.Carrier == nil {
+		return ""
+	}
+	if c, ok := CarrierByCode(*d.Carrier); ok {
+		return c.Name
+	}
+	return *d.Carrier
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"mailtrackerProject/helper"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 中转经手人的凭证与查询票据分开保存，互不覆盖
+const (
+	checkpointCookieName = "qcp"
+	checkpointScope      = "checkpoint"
+)
+
+var (
+	ErrCheckpointNotFound = errors.New("checkpoint token not found")
+	ErrCheckpointRevoked  = errors.New("checkpoint token revoked")
+)
+
+// CheckpointClaims 中转凭证：持有者扫码 /s/:key 时可记录中转事件。ID（jti）对应签发记录
+type CheckpointClaims struct {
+	Name  string `json:"name"` // 经手人名称，写入时间线
+	Scope string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// CheckpointToken 签发过的中转凭证；撤销后即使未过期也不再接受
+type CheckpointToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	IssuedAt  time.Time  `json:"issuedAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active 未撤销且未过期
+func (t CheckpointToken) Active() bool {
+	return t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+}
+
+// CheckpointService 签发与撤销中转凭证。签发记录保存在 data/checkpoints.json，
+// 校验时除签名与有效期外，还要求凭证 ID 在记录中且未撤销；没有 ID 的旧凭证不再接受
+type CheckpointService struct {
+	path string
+
+	mu     sync.RWMutex
+	tokens []CheckpointToken
+}
+
+func NewCheckpointService(path string) *CheckpointService {
+	return &CheckpointService{path: path}
+}
+
+func (s *CheckpointService) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return readJSONFile(s.path, &s.tokens)
+}
+
+// List 签发记录，从新到旧
+func (s *CheckpointService) List() []CheckpointToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := slices.Clone(s.tokens)
+	slices.Reverse(out)
+	return out
+}
+
+// Issue 管理员签发中转凭证，返回 JWT 与签发记录。顺带清理已过期的记录
+func (s *CheckpointService) Issue(name string, ttl time.Duration) (string, CheckpointToken, error) {
+	id, err := helper.RandKey(12)
+	if err != nil {
+		return "", CheckpointToken{}, err
+	}
+	now := time.Now()
+	rec := CheckpointToken{ID: id, Name: name, IssuedAt: now, ExpiresAt: now.Add(ttl)}
+	claims := &CheckpointClaims{
+		Name:  name,
+		Scope: checkpointScope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(rec.ExpiresAt),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(hmacSecret)
+	if err != nil {
+		return "", rec, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := slices.DeleteFunc(slices.Clone(s.tokens), func(t CheckpointToken) bool { return now.After(t.ExpiresAt) })
+	tokens = append(tokens, rec)
+	if err := writeJSONFile(s.path, tokens); err != nil {
+		return "", rec, err
+	}
+	s.tokens = tokens
+	return signed, rec, nil
+}
+
+// Revoke 撤销凭证，已登记的设备下次扫码时即失效
+func (s *CheckpointService) Revoke(id string) (CheckpointToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.tokens, func(t CheckpointToken) bool { return t.ID == id })
+	if i < 0 {
+		return CheckpointToken{}, ErrCheckpointNotFound
+	}
+	if s.tokens[i].RevokedAt != nil {
+		return s.tokens[i], nil
+	}
+	now := time.Now()
+	s.tokens[i].RevokedAt = &now
+	if err := writeJSONFile(s.path, s.tokens); err != nil {
+		s.tokens[i].RevokedAt = nil
+		return CheckpointToken{}, err
+	}
+	return s.tokens[i], nil
+}
+
+// Parse 校验签名、有效期、scope，以及凭证是否仍在签发记录中且未撤销
+func (s *CheckpointService) Parse(tok string) (*CheckpointClaims, error) {
+	cl, err := parseCheckpointToken(tok)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := slices.IndexFunc(s.tokens, func(t CheckpointToken) bool { return t.ID == cl.ID })
+	switch {
+	case cl.ID == "" || i < 0:
+		return nil, ErrCheckpointNotFound
+	case s.tokens[i].RevokedAt != nil:
+		return nil, ErrCheckpointRevoked
+	}
+	return cl, nil
+}
+
+func parseCheckpointToken(tok string) (*CheckpointClaims, error) {
+	if tok == "" {
+		return nil, errors.New("empty checkpoint token")
+	}
+	parsed, err := jwt.ParseWithClaims(tok, &CheckpointClaims{}, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Method)
+		}
+		return hmacSecret, nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, err
+	}
+	cl, ok := parsed.Claims.(*CheckpointClaims)
+	if !ok || cl.Scope != checkpointScope {
+		return nil, errors.New("not a checkpoint token")
+	}
+	return cl, nil
+}
+
+// Read 从 Cookie 读取中转凭证，无效或已撤销时返回 nil
+func (s *CheckpointService) Read(c *gin.Context) *CheckpointClaims {
+	ck, err := c.Request.Cookie(checkpointCookieName)
+	if err != nil {
+		return nil
+	}
+	cl, err := s.Parse(ck.Value)
+	if err != nil {
+		return nil
+	}
+	return cl
+}
+
+// SetCheckpointCookie 写入中转凭证；token 为空时清除
+func SetCheckpointCookie(c *gin.Context, token string, expires time.Time) {
+	ck := &http.Cookie{
+		Name:     checkpointCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   gin.Mode() == gin.ReleaseMode,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if token == "" {
+		ck.MaxAge = -1
+	}
+	http.SetCookie(c.Writer, ck)
+}
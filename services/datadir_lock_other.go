@@ -0,0 +1,8 @@
+//go:build !unix
+
+package services
+
+// LockDataDir 非 unix 平台不支持文件锁，不做检查
+func LockDataDir(string) (func(), error) {
+	return func() {}, nil
+}
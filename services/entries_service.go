@@ -1,19 +1,28 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"log"
 	"mailtrackerProject/models"
+	"mime"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
 type EntryEnvelope struct {
-	Data      EntryData `json:"data"`
-	CreatedAt time.Time `json:"created_at"`
+	Data      EntryData       `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	Status    EntryStatus     `json:"status,omitempty"`
+	Timeline  []TrackingEvent `json:"timeline,omitempty"`
+	Receipt   *Receipt        `json:"receipt,omitempty"`
 }
 
 type Encrypt struct {
@@ -26,24 +35,127 @@ type LookupLimit struct {
 	AvailableBefore *string `json:"availableBefore"`
 }
 
+// ImageRecord 条目中的一张图片及其元数据
+type ImageRecord struct {
+	File         string         `json:"file"`                   // 存储文件名：<sha256><ext>，旧数据为 uuid 文件名
+	OriginalName string         `json:"originalName,omitempty"` // 上传时的文件名
+	MIME         string         `json:"mime,omitempty"`
+	Size         int64          `json:"size,omitempty"`
+	Width        int            `json:"width,omitempty"`
+	Height       int            `json:"height,omitempty"`
+	SHA256       string         `json:"sha256,omitempty"`
+	CapturedAt   *time.Time     `json:"capturedAt,omitempty"`  // EXIF 拍摄时间
+	Variants     []ImageVariant `json:"variants,omitempty"`    // 同一张图的其它格式（如 HEIC 原图）
+	Placeholder  string         `json:"placeholder,omitempty"` // 低清占位图 data URI
+	Described    int            `json:"described,omitempty"`   // 生成以上元数据时的 imageDescribeVersion，占位图生成失败时据此不再重试
+	Caption      string         `json:"caption,omitempty"`
+	Order        int            `json:"order"`
+}
+
+// ImageVariant 图片的派生/替代格式
+type ImageVariant struct {
+	File string `json:"file"`
+	MIME string `json:"mime,omitempty"`
+}
+
+// ImageList 兼容旧格式：旧 entry.json 中 images 是文件名数组，读取时自动转换
+type ImageList []ImageRecord
+
+func (l *ImageList) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err == nil {
+		*l = legacyImageRecords(names)
+		return nil
+	}
+	var recs []ImageRecord
+	if err := json.Unmarshal(b, &recs); err != nil {
+		return err
+	}
+	*l = recs
+	return nil
+}
+
+// legacyImageRecords 旧数据按去掉扩展名后的文件名分组，同组的其它格式作为 variant
+func legacyImageRecords(names []string) ImageList {
+	var out ImageList
+	index := map[string]int{}
+	for _, n := range names {
+		ext := strings.ToLower(filepath.Ext(n))
+		base := strings.TrimSuffix(n, filepath.Ext(n))
+		mt := mime.TypeByExtension(ext)
+		if ext == ".heic" || ext == ".heif" {
+			mt = "image/" + strings.TrimPrefix(ext, ".")
+		}
+		i, ok := index[base]
+		if !ok {
+			index[base] = len(out)
+			out = append(out, ImageRecord{File: n, MIME: mt, Order: len(out)})
+			continue
+		}
+		rec := &out[i]
+		if rec.File == n || slices.ContainsFunc(rec.Variants, func(v ImageVariant) bool { return v.File == n }) {
+			continue
+		}
+		// 浏览器普遍能直接显示的格式作为主文件，HEIC 退为 variant
+		if strings.HasPrefix(rec.MIME, "image/hei") && !strings.HasPrefix(mt, "image/hei") {
+			rec.Variants = append(rec.Variants, ImageVariant{File: rec.File, MIME: rec.MIME})
+			rec.File, rec.MIME = n, mt
+			continue
+		}
+		rec.Variants = append(rec.Variants, ImageVariant{File: n, MIME: mt})
+	}
+	return out
+}
+
+// Sorted 按显示顺序返回图片
+func (l ImageList) Sorted() ImageList {
+	out := slices.Clone(l)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Order < out[j].Order })
+	return out
+}
+
 type EntryData struct {
-	Images         *[]string    `json:"images,omitempty"`         // 可选数组
+	Images         ImageList    `json:"images,omitempty"`
 	OriginLocation *string      `json:"originLocation,omitempty"` // 可选字符串
+	OriginLat      *float64     `json:"originLat,omitempty"`      // 寄出地纬度，创建时由浏览器定位填入
+	OriginLon      *float64     `json:"originLon,omitempty"`      // 寄出地经度
+	OriginPlace    *Place       `json:"originPlace,omitempty"`    // 从地名库匹配到的城市，原文仍保存在 OriginLocation
 	PostDate       *string      `json:"postDate,omitempty"`       // 用 *string 保存原始日期，再转 time.Time
 	LookupLimit    *LookupLimit `json:"lookupLimit,omitempty"`
 	Encrypt        *Encrypt     `json:"encrypt,omitempty"`
 	RecipientName  *string      `json:"recipientName,omitempty"`
 	Remarks        *string      `json:"remarks,omitempty"`
+	TrackingNumber *string      `json:"trackingNumber,omitempty"` // 快递/邮政单号
+	Carrier        *string      `json:"carrier,omitempty"`        // 承运商代码，见 Carriers
+}
+
+// ImageNames 返回条目引用的所有图片文件名（含 variant）
+func (d EntryData) ImageNames() []string {
+	var out []string
+	for _, img := range d.Images {
+		out = append(out, img.File)
+		for _, v := range img.Variants {
+			out = append(out, v.File)
+		}
+	}
+	return out
 }
 
 type EntriesService struct {
 	dataDir string
 	keys    *KeysService
+	blobs   *BlobStore
 	mu      sync.RWMutex // protects write operations per entry file (coarse-grained)
+
+	histMu       sync.Map // key -> *sync.Mutex，访问记录按 key 加锁
+	histMaxBytes int64
+	histKeep     int
+	privacy      PrivacyPolicy // 访问记录中 IP/UA 的处理与保留期限
+	index        *entryIndex   // 搜索用的内存索引
 }
 
-func NewEntriesService(dataDir string, ks *KeysService) *EntriesService {
-	return &EntriesService{dataDir: dataDir, keys: ks}
+func NewEntriesService(dataDir string, ks *KeysService, blobs *BlobStore) *EntriesService {
+	return &EntriesService{dataDir: dataDir, keys: ks, blobs: blobs, index: newEntryIndex()}
 }
 
 func (s *EntriesService) entryDir(key string) string { return filepath.Join(s.dataDir, "entries", key) }
@@ -54,12 +166,17 @@ func (s *EntriesService) historyPath(key string) string {
 	return filepath.Join(s.entryDir(key), "history.ndjson")
 }
 
-func (s *EntriesService) SaveData(key string, data EntryData) error {
+// SaveData 创建或覆盖条目数据；initial 为新建条目的初始状态（草稿或已寄出），
+// 覆盖已有条目时保留原有的状态、时间线与回执。
+// 写入期间持有 key 的读锁（顺序与备份一致），作废未使用的 key 不会与创建条目交错
+func (s *EntriesService) SaveData(key string, data EntryData, initial EntryStatus) error {
 	if !models.ValidKey(key) {
 		return errors.New("invalid key")
 	}
-	if _, ok := s.keys.Get(key); !ok {
-		return errors.New("key not found")
+	s.keys.mu.RLock()
+	defer s.keys.mu.RUnlock()
+	if _, ok := s.keys.keys[key]; !ok {
+		return ErrKeyNotFound
 	}
 
 	s.mu.Lock()
@@ -75,15 +192,49 @@ func (s *EntriesService) SaveData(key string, data EntryData) error {
 	}
 
 	// If exists, keep CreatedAt
+	var oldImages []string
+	exists := false
 	if b, err := os.ReadFile(s.entryPath(key)); err == nil && len(b) > 0 {
 		var old EntryEnvelope
-		if json.Unmarshal(b, &old) == nil && !old.CreatedAt.IsZero() {
-			env.CreatedAt = old.CreatedAt
+		if json.Unmarshal(b, &old) == nil {
+			exists = true
+			if !old.CreatedAt.IsZero() {
+				env.CreatedAt = old.CreatedAt
+			}
+			env.Status, env.Timeline, env.Receipt = old.Status, old.Timeline, old.Receipt
+			oldImages = old.Data.ImageNames()
+		}
+	}
+	if !exists {
+		if initial != StatusDraft {
+			initial = StatusPosted
 		}
+		env.Status = initial
+		env.Timeline = []TrackingEvent{{Time: now, Status: initial, Actor: RoleAdmin}}
+	}
+
+	if err := s.writeEnvelopeLocked(key, &env); err != nil {
+		return err
 	}
 
-	b, _ := json.MarshalIndent(&env, "", "  ")
-	return os.WriteFile(s.entryPath(key), b, 0o644)
+	// 更新 blob 引用计数：先加后减，新旧共用的图片不会掉到 0
+	s.blobs.Retain(data.ImageNames())
+	s.blobs.Release(oldImages)
+	return nil
+}
+
+// 先写临时文件再 Rename，避免 blob GC 扫描时读到写了一半的文件；写入后同步更新搜索索引
+func (s *EntriesService) writeEnvelopeLocked(key string, env *EntryEnvelope) error {
+	b, _ := json.MarshalIndent(env, "", "  ")
+	tmp := s.entryPath(key) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.entryPath(key)); err != nil {
+		return err
+	}
+	s.index.put(key, env)
+	return nil
 }
 
 func (s *EntriesService) LoadData(key string) (*EntryEnvelope, error) {
@@ -108,3 +259,84 @@ func (s *EntriesService) HasData(key string) bool {
 	_, err := os.Stat(s.entryPath(key))
 	return err == nil
 }
+
+// ListKeys 返回所有已创建条目的 key
+func (s *EntriesService) ListKeys() ([]string, error) {
+	dirs, err := os.ReadDir(filepath.Join(s.dataDir, "entries"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []string
+	for _, d := range dirs {
+		if d.IsDir() && models.ValidKey(d.Name()) && s.HasData(d.Name()) {
+			keys = append(keys, d.Name())
+		}
+	}
+	return keys, nil
+}
+
+// MigrateImages 把旧格式（文件名数组）的 images 改写为结构化记录，并为缺少占位图的图片补算。
+// describe 用于补全大小、尺寸、hash、占位图等元数据。当前版本已尝试过、仍没有占位图的图片（如无法解码）不再重试；
+// 元数据没有变化的条目不改写。返回迁移的条目数。
+func (s *EntriesService) MigrateImages(describe func(key string, rec *ImageRecord) error) (int, error) {
+	keys, err := s.ListKeys()
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, key := range keys {
+		b, err := os.ReadFile(s.entryPath(key))
+		if err != nil {
+			return migrated, err
+		}
+		var raw struct {
+			Data struct {
+				Images []json.RawMessage `json:"images"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(b, &raw); err != nil {
+			log.Printf("skip migrating %s: %v", key, err)
+			continue
+		}
+		if len(raw.Data.Images) == 0 {
+			continue
+		}
+		legacy := bytes.TrimSpace(raw.Data.Images[0])[0] == '"'
+
+		env, err := s.LoadData(key)
+		if err != nil {
+			return migrated, err
+		}
+		changed := legacy
+		for i := range env.Data.Images {
+			rec := &env.Data.Images[i]
+			if !legacy && (rec.Placeholder != "" || rec.Described >= imageDescribeVersion) {
+				continue
+			}
+			before := *rec
+			// 读取失败（如存储暂时不可用）时保持原样，下次启动再试
+			if err := describe(key, rec); err != nil {
+				log.Printf("describe image %s/%s: %v", key, rec.File, err)
+				continue
+			}
+			if !reflect.DeepEqual(before, *rec) {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		// 文件名不变，不影响 blob 引用计数
+		s.mu.Lock()
+		err = s.writeEnvelopeLocked(key, env)
+		s.mu.Unlock()
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
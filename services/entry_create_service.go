@@ -0,0 +1,192 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mailtrackerProject/helper"
+	"mailtrackerProject/models"
+	"strings"
+	"time"
+)
+
+// MaxEntryImages 每个条目最多的图片数
+const MaxEntryImages = 9
+
+var (
+	ErrInvalidKeyFormat = errors.New("invalid key format")
+	ErrKeyNotFound      = errors.New("key not found")
+	ErrKeyUsed          = errors.New("key already used")
+	ErrTooManyImages    = fmt.Errorf("too many images (max %d)", MaxEntryImages)
+)
+
+// 查询加密方式与查询时间限制
+const (
+	EncryptNone      = "none"
+	EncryptRecipient = "recipient"
+	EncryptPassword  = "password"
+
+	LookupLimitNone  = "none"
+	LookupLimitAfter = "limitAfter"
+)
+
+// EntryForm 创建条目的输入，来自创建页表单或批量导入的一行
+type EntryForm struct {
+	Key                  string
+	RecipientName        string
+	Remarks              string
+	OriginLocation       string
+	OriginLat            *float64 // 浏览器定位，可选
+	OriginLon            *float64
+	OriginPlaceID        int // 从地名库选中的城市，0 表示按 OriginLocation 自动识别
+	PostDate             string
+	EncryptMethod        string
+	EncryptPassword      string // 选择密码加密但留空时随机生成
+	LookupLimitType      string
+	LookupAvailableAfter string
+	TrackingNumber       string
+	Carrier              string // 为空时按单号格式识别
+	Draft                bool
+}
+
+// ImageUpload 一张待保存的图片，Open 在保存时才调用
+type ImageUpload struct {
+	Name    string
+	Caption string
+	Open    func() (io.ReadCloser, error)
+}
+
+// EntryCreateService 创建/覆盖条目：创建页与批量导入共用同一套校验与保存逻辑
+type EntryCreateService struct {
+	entries *EntriesService
+	files   *FilesService
+	keys    *KeysService
+	hooks   *WebhookService
+	places  *GazetteerService
+}
+
+func NewEntryCreateService(entries *EntriesService, files *FilesService, keys *KeysService, hooks *WebhookService, places *GazetteerService) *EntryCreateService {
+	return &EntryCreateService{entries: entries, files: files, keys: keys, hooks: hooks, places: places}
+}
+
+// Validate 校验表单并组装 EntryData（不含图片），不写入任何数据，可用于导入预览
+func (s *EntryCreateService) Validate(f EntryForm, images int) (EntryData, error) {
+	if !models.ValidKey(f.Key) {
+		return EntryData{}, ErrInvalidKeyFormat
+	}
+	if ki, ok := s.keys.Get(f.Key); !ok {
+		return EntryData{}, ErrKeyNotFound
+	} else if ki.Lifecycle() != LifecycleActive {
+		return EntryData{}, ErrKeyInactive
+	}
+	if images > MaxEntryImages {
+		return EntryData{}, ErrTooManyImages
+	}
+	switch f.EncryptMethod {
+	case "", EncryptNone, EncryptRecipient, EncryptPassword:
+	default:
+		return EntryData{}, fmt.Errorf("unknown encrypt method %q", f.EncryptMethod)
+	}
+	switch f.LookupLimitType {
+	case "", LookupLimitNone, LookupLimitAfter:
+	default:
+		return EntryData{}, fmt.Errorf("unknown lookup limit %q", f.LookupLimitType)
+	}
+	for _, d := range []string{f.PostDate, f.LookupAvailableAfter} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return EntryData{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", d)
+		}
+	}
+
+	recipientName := strings.TrimSpace(f.RecipientName)
+	data := EntryData{
+		RecipientName:  &recipientName,
+		Remarks:        &f.Remarks,
+		OriginLocation: &f.OriginLocation,
+		PostDate:       &f.PostDate,
+	}
+
+	// 单号可选；未选择承运商时按单号格式自动识别
+	if strings.TrimSpace(f.TrackingNumber) != "" {
+		carrier, number, err := ValidateTrackingNumber(f.Carrier, f.TrackingNumber)
+		if err != nil {
+			return EntryData{}, fmt.Errorf("tracking number: %w", err)
+		}
+		data.TrackingNumber, data.Carrier = &number, &carrier
+	}
+	// 寄出地坐标可选，两者都有才保存
+	if f.OriginLat != nil && f.OriginLon != nil {
+		data.OriginLat, data.OriginLon = f.OriginLat, f.OriginLon
+	}
+	if p, ok := s.originPlace(f); ok {
+		data.ApplyOrigin(p)
+	}
+
+	method, password := f.EncryptMethod, strings.TrimSpace(f.EncryptPassword)
+	data.Encrypt = &Encrypt{Method: &method, Password: &password}
+	limitType, after := f.LookupLimitType, f.LookupAvailableAfter
+	data.LookupLimit = &LookupLimit{Type: &limitType, AvailableAfter: &after}
+	return data, nil
+}
+
+// originPlace 选中的城市优先，否则从发件地点原文中匹配
+func (s *EntryCreateService) originPlace(f EntryForm) (*Place, bool) {
+	if f.OriginPlaceID != 0 {
+		if p, err := s.places.ByID(f.OriginPlaceID); err == nil {
+			return p, true
+		}
+	}
+	return s.places.Geocode(f.OriginLocation)
+}
+
+// Create 校验、保存图片并写入条目，然后发出 entry.created / entry.updated。
+// 返回写入后的条目与是否覆盖了已有条目
+func (s *EntryCreateService) Create(f EntryForm, uploads []ImageUpload) (*EntryEnvelope, bool, error) {
+	data, err := s.Validate(f, len(uploads))
+	if err != nil {
+		return nil, false, err
+	}
+	if *data.Encrypt.Method == EncryptPassword && *data.Encrypt.Password == "" {
+		password, _ := helper.RandKey(4)
+		data.Encrypt.Password = &password
+	}
+
+	// 无论成功失败都要结束本次上传，失败时中途已写入的 blob 会被清理
+	defer func() { s.files.Settle(data.ImageNames()) }()
+	for i, up := range uploads {
+		rc, err := up.Open()
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", up.Name, err)
+		}
+		rec, err := s.files.SaveImageFrom(f.Key, rc, up.Name)
+		_ = rc.Close() // 立即关闭，避免在循环里 defer 堆积
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", up.Name, err)
+		}
+		rec.Order = i
+		rec.Caption = strings.TrimSpace(up.Caption)
+		data.Images = append(data.Images, rec)
+	}
+
+	initial := StatusPosted
+	if f.Draft {
+		initial = StatusDraft
+	}
+	existed := s.entries.HasData(f.Key)
+	if err := s.entries.SaveData(f.Key, data, initial); err != nil {
+		return nil, existed, err
+	}
+	env, err := s.entries.LoadData(f.Key)
+	if err != nil {
+		return nil, existed, err
+	}
+	event := WebhookEntryCreated
+	if existed {
+		event = WebhookEntryUpdated
+	}
+	s.hooks.Emit(event, EntryPayload(f.Key, env))
+	return env, existed, nil
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 导出格式
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+	ExportXLSX  = "xlsx"
+)
+
+// 导出的表；CSV 一次只能导出一张，JSONL 与 XLSX 可同时包含两张
+const (
+	ExportTableEntries = "entries"
+	ExportTableHistory = "history"
+)
+
+var ErrExportFormat = errors.New("unsupported export format")
+
+// ExportOptions 导出选项；Keys 为空时导出全部条目
+type ExportOptions struct {
+	Format  string
+	Keys    []string
+	Entries bool
+	History bool
+}
+
+// ContentType 下载时的 MIME 类型
+func (o ExportOptions) ContentType() string {
+	switch o.Format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportJSONL:
+		return "application/x-ndjson; charset=utf-8"
+	default:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+}
+
+// Validate 检查格式与表的组合
+func (o ExportOptions) Validate() error {
+	switch o.Format {
+	case ExportCSV:
+		if o.Entries == o.History {
+			return errors.New("csv export needs exactly one table")
+		}
+	case ExportJSONL, ExportXLSX:
+		if !o.Entries && !o.History {
+			return errors.New("nothing to export")
+		}
+	default:
+		return ErrExportFormat
+	}
+	return nil
+}
+
+// ExportService 导出条目与访问记录；按条目逐个读取，全程流式写出
+type ExportService struct {
+	entries  *EntriesService
+	keys     *KeysService
+	geo      *GeoService
+	visitors *VisitorFilter
+}
+
+func NewExportService(entries *EntriesService, keys *KeysService, geo *GeoService, visitors *VisitorFilter) *ExportService {
+	return &ExportService{entries: entries, keys: keys, geo: geo, visitors: visitors}
+}
+
+var entryColumns = []string{
+	"key", "key_created_at", "key_comment", "key_batch", "status", "created_at",
+	"post_date", "recipient_name", "origin_location", "origin_city", "origin_region", "origin_country", "origin_lat", "origin_lon",
+	"tracking_number", "carrier", "encrypt_method", "encrypt_password",
+	"lookup_limit_type", "lookup_available_after", "lookup_available_before",
+	"remarks", "image_count", "images", "timeline_events", "receipt_date", "receipt_message",
+}
+
+var historyColumns = []string{
+	"key", "time", "ip", "ua", "browser", "browser_version", "os", "os_version", "device", "mobile", "bot",
+	"country_iso", "country", "region", "city", "lat", "lon", "timezone", "asn", "as_org", "visitor", "hidden",
+}
+
+// entryRow 条目展开成一行，缺失的字段为 nil
+func entryRow(key string, ki KeyInfo, env *EntryEnvelope) []any {
+	str := func(s *string) any {
+		if s == nil {
+			return nil
+		}
+		return *s
+	}
+	num := func(f *float64) any {
+		if f == nil {
+			return nil
+		}
+		return *f
+	}
+	d := env.Data
+	row := []any{key, nil, nil, nil, string(env.CurrentStatus()), env.CreatedAt,
+		str(d.PostDate), str(d.RecipientName), str(d.OriginLocation), nil, nil, nil, num(d.OriginLat), num(d.OriginLon),
+		str(d.TrackingNumber), str(d.Carrier), nil, nil,
+		nil, nil, nil,
+		str(d.Remarks), len(d.Images), nil, len(env.Timeline), nil, nil,
+	}
+	if ki.Key != "" {
+		row[1], row[2], row[3] = ki.CreatedAt, ki.Comment, ki.BatchID()
+	}
+	if p := d.OriginPlace; p != nil {
+		row[9], row[10], row[11] = p.City, p.Region, p.CountryCode
+	}
+	if d.Encrypt != nil {
+		row[16], row[17] = str(d.Encrypt.Method), str(d.Encrypt.Password)
+	}
+	if d.LookupLimit != nil {
+		row[18], row[19], row[20] = str(d.LookupLimit.Type), str(d.LookupLimit.AvailableAfter), str(d.LookupLimit.AvailableBefore)
+	}
+	var files []string
+	for _, img := range d.Images.Sorted() {
+		files = append(files, img.File)
+	}
+	row[23] = strings.Join(files, " ")
+	if r := env.Receipt; r != nil {
+		row[25], row[26] = r.ArrivalDate, r.Message
+	}
+	return row
+}
+
+// historyRow 访问记录展开成一行
+func historyRow(key string, rec *HistoryRecord) []any {
+	row := make([]any, len(historyColumns))
+	row[0], row[1], row[2], row[3] = key, rec.Time, rec.IP, rec.UA
+	if ua := rec.UAObj; ua != nil {
+		row[4], row[5], row[6], row[7], row[8], row[9], row[10] = ua.Name, ua.Version, ua.OS, ua.OSVersion, ua.Device, ua.Mobile, ua.Bot
+	}
+	if g := rec.IPObj; g != nil {
+		row[11], row[12], row[13], row[14] = g.CountryISO, g.Country, g.Region, g.City
+		if g.HasCoords() {
+			row[15], row[16] = g.Latitude, g.Longitude
+		}
+		row[17], row[18], row[19] = g.Timezone, g.ASN, g.ASOrg
+	}
+	row[20], row[21] = rec.Visitor, rec.Hidden
+	return row
+}
+
+// exportEntry JSONL 中的条目行：保留原始结构，附带 key 信息
+type exportEntry struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	*EntryEnvelope
+	KeyInfo *KeyInfo `json:"keyInfo,omitempty"`
+}
+
+// exportHistory JSONL 中的访问记录行
+type exportHistory struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	HistoryRecord
+	Visitor string `json:"visitor"`
+	Hidden  string `json:"hidden,omitempty"`
+}
+
+// tableSink 不同格式的表格输出
+type tableSink interface {
+	begin(table string, columns []string) error
+	row(cells []any) error
+}
+
+type csvSink struct{ w *csv.Writer }
+
+func (s *csvSink) begin(_ string, columns []string) error { return s.w.Write(columns) }
+
+func (s *csvSink) row(cells []any) error {
+	out := make([]string, len(cells))
+	for i, v := range cells {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			out[i] = csvSafe(v)
+		case time.Time:
+			out[i] = v.Format(time.RFC3339)
+		default:
+			out[i] = fmt.Sprint(v)
+		}
+	}
+	return s.w.Write(out)
+}
+
+// csvSafe 以 = + - @（以及制表符、回车）开头的文本前加 '，防止 Excel 等把收件人、备注、UA 之类的外部输入当作公式执行。
+// 数字单元格不经过这里，负数不受影响
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type xlsxSink struct{ x *XLSXWriter }
+
+func (s *xlsxSink) begin(table string, columns []string) error {
+	header := make([]any, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	return s.x.Sheet(table, header...)
+}
+
+func (s *xlsxSink) row(cells []any) error { return s.x.Row(cells...) }
+
+// Export 把选中的条目写到 w。CSV 带 UTF-8 BOM 以便 Excel 识别中文
+func (s *ExportService) Export(w io.Writer, opt ExportOptions) error {
+	if err := opt.Validate(); err != nil {
+		return err
+	}
+	keys := opt.Keys
+	if len(keys) == 0 {
+		var err error
+		if keys, err = s.entries.ListKeys(); err != nil {
+			return err
+		}
+		// 不导出回收站中的条目
+		keys = slices.DeleteFunc(keys, func(k string) bool { return s.entries.Lifecycle(k) == LifecycleDeleted })
+	}
+
+	switch opt.Format {
+	case ExportJSONL:
+		return s.exportJSONL(w, keys, opt)
+	case ExportCSV:
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+		cw := csv.NewWriter(w)
+		sink := &csvSink{w: cw}
+		var err error
+		if opt.Entries {
+			err = s.writeEntries(sink, keys)
+		} else {
+			err = s.writeHistory(sink, keys)
+		}
+		cw.Flush()
+		if err != nil {
+			return err
+		}
+		return cw.Error()
+	default:
+		x := NewXLSXWriter(w)
+		sink := &xlsxSink{x: x}
+		if opt.Entries {
+			if err := s.writeEntries(sink, keys); err != nil {
+				return err
+			}
+		}
+		if opt.History {
+			if err := s.writeHistory(sink, keys); err != nil {
+				return err
+			}
+		}
+		return x.Close()
+	}
+}
+
+func (s *ExportService) writeEntries(sink tableSink, keys []string) error {
+	if err := sink.begin(ExportTableEntries, entryColumns); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		env, err := s.entries.LoadData(key)
+		if err != nil {
+			continue // 选中后被删除的条目跳过
+		}
+		ki, _ := s.keys.Get(key)
+		if err := sink.row(entryRow(key, ki, env)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ExportService) writeHistory(sink tableSink, keys []string) error {
+	if err := sink.begin(ExportTableHistory, historyColumns); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.scanHistory(key, func(rec *HistoryRecord) error {
+			return sink.row(historyRow(key, rec))
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanHistory 按时间正序读取访问记录，补全 GeoIP/UA 并标注爬虫与重复查看
+func (s *ExportService) scanHistory(key string, fn func(rec *HistoryRecord) error) error {
+	c := s.visitors.newClassifier()
+	var werr error
+	err := s.entries.ScanHistory(key, func(rec HistoryRecord) bool {
+		s.geo.Enrich(&rec)
+		c.add(&rec)
+		werr = fn(&rec)
+		return werr == nil
+	})
+	if werr != nil {
+		return werr
+	}
+	return err
+}
+
+func (s *ExportService) exportJSONL(w io.Writer, keys []string, opt ExportOptions) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, key := range keys {
+		env, err := s.entries.LoadData(key)
+		if err != nil {
+			continue
+		}
+		if opt.Entries {
+			line := exportEntry{Type: "entry", Key: key, EntryEnvelope: env}
+			if ki, ok := s.keys.Get(key); ok {
+				line.KeyInfo = &ki
+			}
+			if err := enc.Encode(line); err != nil {
+				return err
+			}
+		}
+		if opt.History {
+			if err := s.scanHistory(key, func(rec *HistoryRecord) error {
+				return enc.Encode(exportHistory{Type: "history", Key: key, HistoryRecord: *rec, Visitor: rec.Visitor, Hidden: rec.Hidden})
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -6,32 +6,44 @@ import (
 	"fmt"
 	"image"
 	_ "image/gif"
-	_ "image/jpeg"
+	"image/jpeg"
 	_ "image/png"
 	"io"
 	"log"
 	"mailtrackerProject/models"
 	"mime/multipart"
-	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/gabriel-vasile/mimetype"
-	"github.com/google/uuid"
 	"github.com/kolesa-team/go-webp/decoder"
 	"github.com/kolesa-team/go-webp/webp"
 	"github.com/strukturag/libheif/go/heif"
 )
 
-type FilesService struct{ dataDir string }
+type FilesService struct {
+	dataDir   string
+	blobs     *BlobStore
+	watermark *WatermarkService
+	derivedMu sync.Mutex // 派生图逐个生成，避免并发解码大图占满内存
+}
+
+func NewFilesService(dataDir string, blobs *BlobStore, watermark *WatermarkService) *FilesService {
+	return &FilesService{dataDir: dataDir, blobs: blobs, watermark: watermark}
+}
 
-func NewFilesService(dataDir string) *FilesService { return &FilesService{dataDir: dataDir} }
+// SaveImage 保存上传的图片并返回其元数据记录（Caption、Order 由调用方填写）
+func (s *FilesService) SaveImage(key string, file multipart.File, fh *multipart.FileHeader) (ImageRecord, error) {
+	defer file.Close()
+	return s.SaveImageFrom(key, file, fh.Filename)
+}
 
-func (s *FilesService) SaveImage(key string, file multipart.File, fh *multipart.FileHeader) (string, string, error) {
+// SaveImageFrom 从任意 Reader 保存图片，filename 只用于扩展名与记录原始文件名（批量导入时来自 ZIP）
+func (s *FilesService) SaveImageFrom(key string, file io.Reader, filename string) (ImageRecord, error) {
 	if !models.ValidKey(key) {
-		return "", "", errors.New("invalid key format")
+		return ImageRecord{}, errors.New("invalid key format")
 	}
-	defer file.Close()
 
 	const maxUpload = int64(40 << 20) // 40MB
 
@@ -39,55 +51,142 @@ func (s *FilesService) SaveImage(key string, file multipart.File, fh *multipart.
 	header := make([]byte, 8192)
 	n, err := io.ReadFull(io.LimitReader(file, int64(len(header))), header)
 	if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
-		return "", "", fmt.Errorf("read header failed: %w", err)
+		return ImageRecord{}, fmt.Errorf("read header failed: %w", err)
 	}
 	header = header[:n]
 
 	mediaType, err := detectMime(header)
 	if err != nil {
-		return "", "", fmt.Errorf("unsupported file type: %w", err)
+		return ImageRecord{}, fmt.Errorf("unsupported file type: %w", err)
 	}
 	log.Printf("detected media type: %s", mediaType)
 
-	// 确保目录存在
-	dir := filepath.Join(s.dataDir, "entries", key, "images")
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return "", "", fmt.Errorf("mkdir failed: %w", err)
-	}
-
 	// 把整个文件读到内存（仍然限制大小）
 	lr := io.LimitReader(io.MultiReader(bytes.NewReader(header), file), maxUpload+1)
 	buf, err := io.ReadAll(lr)
 	if err != nil {
-		return "", "", fmt.Errorf("read file failed: %w", err)
+		return ImageRecord{}, fmt.Errorf("read file failed: %w", err)
 	}
 	if int64(len(buf)) > maxUpload {
-		return "", "", errors.New("file too large")
-	}
-
-	baseName := uuid.New().String()
-	var origName string
-	ext := strings.ToLower(filepath.Ext(fh.Filename))
-
-	// 是否为 HEIF/HEIC/AVIF
-	// 如果是 HEIF 系列，则保存原始文件一份（扩展名来自原始文件名）
-	//ios上上传会自动转换为jpg
-	fileName := baseName + ext
-	if strings.HasPrefix(mediaType, "image/heif") || strings.HasPrefix(mediaType, "image/heic") || strings.HasPrefix(mediaType, "image/avif") {
-		origName = fileName
-		origPath := filepath.Join(dir, origName)
-		if err := os.WriteFile(origPath, buf, 0o644); err != nil {
-			return "", "", fmt.Errorf("write original file failed: %w", err)
-		}
+		return ImageRecord{}, errors.New("file too large")
 	}
 
-	abs := filepath.Join(dir, fileName)
-	err = os.WriteFile(abs, buf, 0o644)
+	// 内容寻址：文件名为内容的 sha256，相同图片只存一份
+	baseName, err := s.blobs.Put(buf, mediaType)
 	if err != nil {
-		return "", "", err
+		return ImageRecord{}, fmt.Errorf("write blob failed: %w", err)
 	}
+	// 扩展名来自原始文件名（ios上上传会自动转换为jpg）
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	rec := inspectImage(buf, mediaType)
+	rec.File = baseName + ext
+	rec.OriginalName = filepath.Base(filename)
+	return rec, nil
+}
+
+// DescribeImage 读取已保存的图片，补全记录中缺失的元数据（用于迁移旧数据）
+func (s *FilesService) DescribeImage(key string, rec *ImageRecord) error {
+	f, _, err := s.OpenImage(key, rec.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	mediaType := mimetype.Detect(buf).String()
+	info := inspectImage(buf, mediaType)
+	info.File, info.OriginalName = rec.File, rec.OriginalName
+	info.Variants, info.Caption, info.Order = rec.Variants, rec.Caption, rec.Order
+	*rec = info
+	return nil
+}
+
+// Settle 上传流程结束（无论成功与否）后调用，释放本次上传中未被条目引用的 blob
+func (s *FilesService) Settle(names []string) {
+	s.blobs.Settle(names)
+}
+
+// OpenImage 打开条目的图片：内容寻址的文件名从存储后端读取 blob，
+// 旧的 uuid 文件名始终位于本地 entries/<key>/images
+func (s *FilesService) OpenImage(key, name string) (io.ReadCloser, ObjectInfo, error) {
+	if !models.ValidKey(key) || name != filepath.Base(name) {
+		return nil, ObjectInfo{}, errors.New("invalid image path")
+	}
+	if hash, ok := BlobHash(name); ok {
+		return s.blobs.Open(hash)
+	}
+	return NewLocalStorage(filepath.Join(s.dataDir, "entries")).Get(key + "/images/" + name)
+}
 
-	return fileName, origName, nil
+// Watermarking 是否对外输出加水印的派生图
+func (s *FilesService) Watermarking() bool { return s.watermark.Enabled() }
+
+// EnsureWatermarked 返回加水印派生图在存储中的对象名，不存在时生成并缓存。
+// 派生图统一输出为 JPEG，原图不做任何修改。
+func (s *FilesService) EnsureWatermarked(key, name string) (string, error) {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	obj := derivedPrefix + key + "/" + base + "-" + s.watermark.Version() + ".jpg"
+	st := s.blobs.Storage()
+	if _, err := st.Stat(obj); err == nil {
+		return obj, nil
+	}
+
+	s.derivedMu.Lock()
+	defer s.derivedMu.Unlock()
+	// 拿到锁后再确认一次，可能已被其它请求生成
+	if _, err := st.Stat(obj); err == nil {
+		return obj, nil
+	}
+
+	f, _, err := s.OpenImage(key, name)
+	if err != nil {
+		return "", err
+	}
+	buf, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return "", err
+	}
+	mediaType := mimetype.Detect(buf).String()
+	img, err := decodeImage(buf, mediaType)
+	if err != nil {
+		return "", fmt.Errorf("decode image failed: %w", err)
+	}
+	orientation := parseExif(buf).orientation
+	if isHeif(mediaType) {
+		orientation = 0
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, s.watermark.Apply(img, orientation, key), &jpeg.Options{Quality: 85}); err != nil {
+		return "", err
+	}
+	if err := st.Put(obj, out.Bytes(), "image/jpeg"); err != nil {
+		return "", err
+	}
+	return obj, nil
+}
+
+// OpenObject 读取存储中的对象（派生图）
+func (s *FilesService) OpenObject(obj string) (io.ReadCloser, ObjectInfo, error) {
+	return s.blobs.Storage().Get(obj)
+}
+
+// ObjectURL 对象的限时直链，未启用直链时返回空字符串
+func (s *FilesService) ObjectURL(obj string) (string, error) {
+	return s.blobs.PresignObject(obj)
+}
+
+// ImageURL 返回图片在存储后端的限时直链，未启用直链时返回空字符串
+func (s *FilesService) ImageURL(name string) (string, error) {
+	hash, ok := BlobHash(name)
+	if !ok {
+		return "", nil
+	}
+	return s.blobs.PresignURL(hash)
 }
 
 // 根据 MIME 返回对应扩展名
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Place 地名库中的一个城市；保存在条目里时 Population 不落盘
+type Place struct {
+	ID          int     `json:"id"` // GeoNames geonameid
+	City        string  `json:"city"`
+	Region      string  `json:"region,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	CountryCode string  `json:"countryCode"` // ISO 3166-1 alpha-2
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	Population  int64   `json:"-"`
+}
+
+// Label 城市, 省份, 国家（省略空项与重复项）
+func (p *Place) Label() string {
+	var parts []string
+	for _, s := range []string{p.City, p.Region, p.Country} {
+		if s != "" && !slices.Contains(parts, s) {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// placeName 名称索引项，name 已规范化
+type placeName struct {
+	name string
+	idx  int32
+}
+
+// GazetteerService 离线地名库，数据来自 GeoNames 的 cities 导出（cities500/1000/5000/15000.txt）。
+// 加载后只读，可并发使用；未配置时为 nil，所有方法返回空结果。
+type GazetteerService struct {
+	places []Place
+	byID   map[int]int32
+	names  []placeName // 按 name 排序，前缀查询与精确查询共用
+}
+
+const (
+	gazetteerMaxScan = 20000 // 前缀查询最多检查的名称数
+	geocodeMaxRunes  = 24    // 从自由文本里匹配地名时的最长子串
+)
+
+var ErrPlaceNotFound = errors.New("place not found")
+
+// NewGazetteerService 加载 GeoNames 城市文件；admin1Path（admin1CodesASCII.txt）与
+// countriesPath（countryInfo.txt）可为空，为空时省份留空、国家只显示代码。
+func NewGazetteerService(citiesPath, admin1Path, countriesPath string) (*GazetteerService, error) {
+	admin1, err := readGeoNamesTable(admin1Path, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	countries, err := readGeoNamesTable(countriesPath, 0, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(citiesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	g := &GazetteerService{byID: map[int]int32{}}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4<<20) // alternatenames 可能很长
+	for sc.Scan() {
+		cols := strings.Split(sc.Text(), "\t")
+		if len(cols) < 15 {
+			continue
+		}
+		id, err1 := strconv.Atoi(cols[0])
+		lat, err2 := strconv.ParseFloat(cols[4], 64)
+		lon, err3 := strconv.ParseFloat(cols[5], 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		pop, _ := strconv.ParseInt(cols[14], 10, 64)
+		cc := cols[8]
+		country := countries[cc]
+		if country == "" {
+			country = cc
+		}
+		idx := int32(len(g.places))
+		g.places = append(g.places, Place{
+			ID:          id,
+			City:        cols[1],
+			Region:      admin1[cc+"."+cols[10]],
+			Country:     country,
+			CountryCode: cc,
+			Lat:         lat,
+			Lon:         lon,
+			Population:  pop,
+		})
+		g.byID[id] = idx
+
+		// 名称、ASCII 名与别名（含中文等各语言写法）都进索引
+		seen := map[string]bool{}
+		for _, n := range append([]string{cols[1], cols[2]}, strings.Split(cols[3], ",")...) {
+			n = normalizePlaceName(n)
+			if n == "" || seen[n] || utf8.RuneCountInString(n) > 64 || strings.Contains(n, "http") {
+				continue
+			}
+			seen[n] = true
+			g.names = append(g.names, placeName{name: n, idx: idx})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(g.names, func(i, j int) bool { return g.names[i].name < g.names[j].name })
+	return g, nil
+}
+
+// readGeoNamesTable 读取制表符分隔文件，返回 keyCol -> valCol；忽略 # 注释行。path 为空时返回空表
+func readGeoNamesTable(path string, keyCol, valCol int) (map[string]string, error) {
+	out := map[string]string{}
+	if path == "" {
+		return out, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		cols := strings.Split(line, "\t")
+		if len(cols) > max(keyCol, valCol) {
+			out[cols[keyCol]] = cols[valCol]
+		}
+	}
+	return out, sc.Err()
+}
+
+// normalizePlaceName 小写并合并空白；纯数字（邮编、代码）不作为地名
+func normalizePlaceName(s string) string {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	if strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != ' ' }) < 0 {
+		return ""
+	}
+	return s
+}
+
+// Len 已加载的城市数
+func (g *GazetteerService) Len() int {
+	if g == nil {
+		return 0
+	}
+	return len(g.places)
+}
+
+// ByID 按 geonameid 取城市
+func (g *GazetteerService) ByID(id int) (*Place, error) {
+	if g == nil {
+		return nil, ErrPlaceNotFound
+	}
+	idx, ok := g.byID[id]
+	if !ok {
+		return nil, ErrPlaceNotFound
+	}
+	p := g.places[idx]
+	return &p, nil
+}
+
+// PlaceMatch 自动补全结果：Name 为命中的写法（可能是别名）
+type PlaceMatch struct {
+	Place
+	Name  string `json:"name"`
+	Label string `json:"label"`
+}
+
+// Search 按名称前缀查找城市，人口多的在前
+func (g *GazetteerService) Search(q string, limit int) []PlaceMatch {
+	q = normalizePlaceName(q)
+	if g == nil || q == "" || limit <= 0 {
+		return nil
+	}
+	start := sort.Search(len(g.names), func(i int) bool { return g.names[i].name >= q })
+	// 每个城市只保留一个命中的名称：完全一致的优先，其次是正式名称
+	rank := func(n placeName) int {
+		switch n.name {
+		case q:
+			return 2
+		case normalizePlaceName(g.places[n.idx].City):
+			return 1
+		}
+		return 0
+	}
+	best := map[int32]string{}
+	for i := start; i < len(g.names) && i-start < gazetteerMaxScan && strings.HasPrefix(g.names[i].name, q); i++ {
+		n := g.names[i]
+		if cur, ok := best[n.idx]; !ok || rank(n) > rank(placeName{name: cur, idx: n.idx}) {
+			best[n.idx] = n.name
+		}
+	}
+
+	idxs := make([]int32, 0, len(best))
+	for idx := range best {
+		idxs = append(idxs, idx)
+	}
+	sort.Slice(idxs, func(i, j int) bool {
+		// 完全匹配优先，其次按人口
+		ei, ej := best[idxs[i]] == q, best[idxs[j]] == q
+		if ei != ej {
+			return ei
+		}
+		pi, pj := g.places[idxs[i]], g.places[idxs[j]]
+		if pi.Population != pj.Population {
+			return pi.Population > pj.Population
+		}
+		return pi.ID < pj.ID
+	})
+
+	out := make([]PlaceMatch, 0, min(limit, len(idxs)))
+	for _, idx := range idxs[:min(limit, len(idxs))] {
+		p := g.places[idx]
+		out = append(out, PlaceMatch{Place: p, Name: best[idx], Label: p.Label()})
+	}
+	return out
+}
+
+// lookupExact 名称完全一致的城市中人口最多的一个
+func (g *GazetteerService) lookupExact(name string) (int32, bool) {
+	i := sort.Search(len(g.names), func(i int) bool { return g.names[i].name >= name })
+	found := int32(-1)
+	for ; i < len(g.names) && g.names[i].name == name; i++ {
+		if found < 0 || g.places[g.names[i].idx].Population > g.places[found].Population {
+			found = g.names[i].idx
+		}
+	}
+	return found, found >= 0
+}
+
+// Geocode 从寄件人填写的自由文本（如“上海市徐汇区xx邮局”“Paris, France”）中找出地名：
+// 取能在地名库中精确命中的最长子串，同样长度时人口多的优先。拉丁字母的子串必须落在单词边界上。
+func (g *GazetteerService) Geocode(text string) (*Place, bool) {
+	if g == nil {
+		return nil, false
+	}
+	runes := []rune(normalizePlaceName(text))
+	wordChar := func(i int) bool {
+		return i >= 0 && i < len(runes) && runes[i] < utf8.RuneSelf &&
+			(unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+	}
+	for n := min(len(runes), geocodeMaxRunes); n >= 2; n-- {
+		found := int32(-1)
+		for i := 0; i+n <= len(runes); i++ {
+			// 拉丁字母地名不能从单词中间截取，且至少 3 个字母
+			if (wordChar(i) && wordChar(i-1)) || (wordChar(i+n-1) && wordChar(i+n)) {
+				continue
+			}
+			if wordChar(i) && n < 3 {
+				continue
+			}
+			idx, ok := g.lookupExact(string(runes[i : i+n]))
+			if ok && (found < 0 || g.places[idx].Population > g.places[found].Population) {
+				found = idx
+			}
+		}
+		if found >= 0 {
+			p := g.places[found]
+			return &p, true
+		}
+	}
+	return nil, false
+}
+
+// ApplyOrigin 记录结构化的寄出地；没有浏览器定位坐标时用城市坐标
+func (d *EntryData) ApplyOrigin(p *Place) {
+	d.OriginPlace = p
+	if d.OriginLat == nil || d.OriginLon == nil {
+		lat, lon := p.Lat, p.Lon
+		d.OriginLat, d.OriginLon = &lat, &lon
+	}
+}
+
+// BackfillOrigins 为还没有结构化寄出地的旧条目按 OriginLocation 文本补全，返回补全的条目数
+func (s *EntriesService) BackfillOrigins(g *GazetteerService) (int, error) {
+	keys, err := s.ListKeys()
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := 0
+	for _, key := range keys {
+		env, err := s.LoadData(key)
+		if err != nil {
+			return changed, err
+		}
+		if env.Data.OriginPlace != nil || env.Data.OriginLocation == nil {
+			continue
+		}
+		p, ok := g.Geocode(*env.Data.OriginLocation)
+		if !ok {
+			continue
+		}
+		env.Data.ApplyOrigin(p)
+		if err := s.writeEnvelopeLocked(key, env); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
@@ -89,3 +89,19 @@ func (s *GeoService) Lookup(ipStr string) (*IPInfo, error) {
 	}
 	return info, nil
 }
+
+// Enrich 补全访问记录中缺失的 UA 与 GeoIP 信息，已有的不覆盖。返回是否有改动
+func (s *GeoService) Enrich(rec *HistoryRecord) bool {
+	changed := false
+	if rec.UAObj == nil && rec.UA != "" {
+		rec.UAObj = ParseUAInfo(rec.UA)
+		changed = true
+	}
+	if rec.IPObj == nil && rec.IP != "" {
+		if info, err := s.Lookup(rec.IP); err == nil {
+			rec.IPObj = info
+			changed = true
+		}
+	}
+	return changed
+}
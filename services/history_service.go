@@ -0,0 +1,325 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 访问记录按文件轮转：history.ndjson 为当前文件，写满后改名为 history.<seq>.ndjson，
+// seq 递增且不重复使用（最近一次用到的序号记在 history.rotated），超过保留数量的旧归档被删除。
+
+// SetHistoryRotation 当前文件超过 maxBytes 时轮转，最多保留 keep 个归档；maxBytes<=0 不轮转
+func (s *EntriesService) SetHistoryRotation(maxBytes int64, keep int) {
+	s.histMaxBytes, s.histKeep = maxBytes, keep
+}
+
+func (s *EntriesService) historyLock(key string) *sync.Mutex {
+	mu, _ := s.histMu.LoadOrStore(key, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+func (s *EntriesService) archivePath(key string, seq int) string {
+	return filepath.Join(s.entryDir(key), fmt.Sprintf("history.%d.ndjson", seq))
+}
+
+// historyArchives 已有归档的序号，从新到旧
+func (s *EntriesService) historyArchives(key string) []int {
+	matches, _ := filepath.Glob(filepath.Join(s.entryDir(key), "history.*.ndjson"))
+	var seqs []int
+	for _, m := range matches {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), "history."), ".ndjson"))
+		if err == nil && n > 0 {
+			seqs = append(seqs, n)
+		}
+	}
+	slices.Sort(seqs)
+	slices.Reverse(seqs)
+	return seqs
+}
+
+// historySegments 所有记录文件，从旧到新
+func (s *EntriesService) historySegments(key string) []string {
+	seqs := s.historyArchives(key)
+	out := make([]string, 0, len(seqs)+1)
+	for i := len(seqs) - 1; i >= 0; i-- {
+		out = append(out, s.archivePath(key, seqs[i]))
+	}
+	return append(out, s.historyPath(key))
+}
+
+// HasHistory 是否已有访问记录
+func (s *EntriesService) HasHistory(key string) bool {
+	if fi, err := os.Stat(s.historyPath(key)); err == nil && fi.Size() > 0 {
+		return true
+	}
+	return len(s.historyArchives(key)) > 0
+}
+
+func (s *EntriesService) rotatedPath(key string) string {
+	return filepath.Join(s.entryDir(key), "history.rotated")
+}
+
+// nextArchiveSeq 当前文件轮转后将得到的序号，也用作当前文件在游标中的编号。
+// 归档全部被删除（保留数量为 0）时由 history.rotated 保证序号不回退
+func (s *EntriesService) nextArchiveSeq(key string) int {
+	last := 0
+	if seqs := s.historyArchives(key); len(seqs) > 0 {
+		last = seqs[0]
+	}
+	if b, err := os.ReadFile(s.rotatedPath(key)); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && n > last {
+			last = n
+		}
+	}
+	return last + 1
+}
+
+func (s *EntriesService) rotateHistoryLocked(key string, f *os.File) error {
+	if s.histMaxBytes <= 0 {
+		return nil
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Size() < s.histMaxBytes {
+		return err
+	}
+	seqs := s.historyArchives(key)
+	next := s.nextArchiveSeq(key)
+	if err := os.Rename(s.historyPath(key), s.archivePath(key, next)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.rotatedPath(key), []byte(strconv.Itoa(next)), 0o644); err != nil {
+		return err
+	}
+	seqs = append([]int{next}, seqs...)
+	for _, seq := range seqs[min(len(seqs), max(s.histKeep, 0)):] {
+		if err := os.Remove(s.archivePath(key, seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanHistory 按时间顺序（从旧到新）流式读取全部记录，fn 返回 false 时停止
+func (s *EntriesService) ScanHistory(key string, fn func(rec HistoryRecord) bool) error {
+	for _, p := range s.historySegments(key) {
+		stop := false
+		err := scanHistoryFile(p, func(rec HistoryRecord) bool {
+			if !fn(rec) {
+				stop = true
+				return false
+			}
+			return true
+		})
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanHistoryFile(p string, fn func(rec HistoryRecord) bool) error {
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var rec HistoryRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if !fn(rec) {
+			return nil
+		}
+	}
+}
+
+// HistoryVersion 记录文件的版本标识，文件变化后改变，用于缓存统计结果
+func (s *EntriesService) HistoryVersion(key string) string {
+	var b strings.Builder
+	for _, p := range s.historySegments(key) {
+		if fi, err := os.Stat(p); err == nil {
+			fmt.Fprintf(&b, "%s:%d:%d;", filepath.Base(p), fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// HistoryPage 一页访问记录。Context 是紧接在本页之前、时间在 lookback 内的记录，
+// 只用于判断重复查看，不展示。
+type HistoryPage struct {
+	Records []HistoryRecord
+	Context []HistoryRecord
+	Next    string // 下一页游标，空表示没有更多
+}
+
+// historyCursor 游标格式 "<seq>:<offset>"，offset 为空表示从文件末尾开始。
+// seq 是分段的绝对编号：当前文件用它轮转后将得到的归档序号，翻页期间发生轮转游标仍指向同一份数据。
+// 旧版本的游标用 0 表示当前文件，按当前文件处理
+type historyCursor struct {
+	seq    int
+	offset int64 // -1 表示文件末尾
+}
+
+func parseHistoryCursor(c string) (historyCursor, error) {
+	if c == "" {
+		return historyCursor{offset: -1}, nil
+	}
+	seqStr, offStr, ok := strings.Cut(c, ":")
+	seq, err := strconv.Atoi(seqStr)
+	if !ok || err != nil || seq < 0 {
+		return historyCursor{}, fmt.Errorf("invalid cursor %q", c)
+	}
+	cur := historyCursor{seq: seq, offset: -1}
+	if offStr != "" {
+		if cur.offset, err = strconv.ParseInt(offStr, 10, 64); err != nil || cur.offset < 0 {
+			return historyCursor{}, fmt.Errorf("invalid cursor %q", c)
+		}
+	}
+	return cur, nil
+}
+
+func (c historyCursor) String() string {
+	if c.offset < 0 {
+		return strconv.Itoa(c.seq) + ":"
+	}
+	return strconv.Itoa(c.seq) + ":" + strconv.FormatInt(c.offset, 10)
+}
+
+// HistoryPage 从游标处往前读取最多 limit 条记录（最新在前），只读取文件尾部需要的部分。
+// 读取期间持有记录锁，分段编号与文件内容不会因轮转错开
+func (s *EntriesService) HistoryPage(key, cursor string, limit int, lookback time.Duration) (HistoryPage, error) {
+	var page HistoryPage
+	cur, err := parseHistoryCursor(cursor)
+	if err != nil {
+		return page, err
+	}
+	mu := s.historyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// 按从新到旧排列的分段：当前文件，然后是归档
+	live := s.nextArchiveSeq(key)
+	if cur.seq == 0 {
+		cur.seq = live
+	}
+	segs := append([]int{live}, s.historyArchives(key)...)
+	i := slices.Index(segs, cur.seq)
+	if i < 0 {
+		return page, nil // 游标指向的归档已被删除
+	}
+	var oldest time.Time
+	for ; i < len(segs); i++ {
+		seq := segs[i]
+		p := s.historyPath(key)
+		if seq != live {
+			p = s.archivePath(key, seq)
+		}
+		end := int64(-1)
+		if seq == cur.seq {
+			end = cur.offset
+		}
+		done := false
+		err := scanFileBackward(p, end, func(line []byte, start int64) bool {
+			var rec HistoryRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				// 游标落在被重写过的文件中间时可能读到半行，跳过
+				log.Printf("history %s: skip line at %d: %v", key, start, err)
+				return true
+			}
+			if len(page.Records) < limit {
+				page.Records = append(page.Records, rec)
+				oldest = rec.Time
+				page.Next = historyCursor{seq: seq, offset: start}.String()
+				if start == 0 {
+					page.Next = ""
+					if i+1 < len(segs) {
+						page.Next = historyCursor{seq: segs[i+1], offset: -1}.String()
+					}
+				}
+				return true
+			}
+			if lookback > 0 && oldest.Sub(rec.Time) < lookback {
+				page.Context = append(page.Context, rec)
+				return true
+			}
+			done = true
+			return false
+		})
+		if err != nil {
+			return page, err
+		}
+		if done || (len(page.Records) >= limit && lookback <= 0) {
+			break
+		}
+	}
+	return page, nil
+}
+
+// scanFileBackward 从 end（-1 或超出文件长度时为文件末尾）往前逐行读取，fn 收到行内容与该行的起始偏移
+func scanFileBackward(p string, end int64, fn func(line []byte, start int64) bool) error {
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	// 文件被重写变短后，旧游标的偏移可能超出文件末尾
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if end < 0 || end > fi.Size() {
+		end = fi.Size()
+	}
+
+	const chunk = 64 << 10
+	pos := end
+	var carry []byte // 还没读到行首的部分
+	for pos > 0 {
+		n := min(int64(chunk), pos)
+		pos -= n
+		buf := make([]byte, int(n)+len(carry))
+		if _, err := f.ReadAt(buf[:n], pos); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		copy(buf[n:], carry)
+		data := buf
+		for {
+			trimmed := bytes.TrimSuffix(data, []byte("\n"))
+			i := bytes.LastIndexByte(trimmed, '\n')
+			if i < 0 {
+				break
+			}
+			if line := trimmed[i+1:]; len(line) > 0 && !fn(line, pos+int64(i+1)) {
+				return nil
+			}
+			data = trimmed[:i+1]
+		}
+		carry = data
+	}
+	if line := bytes.TrimSuffix(carry, []byte("\n")); len(line) > 0 {
+		fn(line, 0)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordVisits(t *testing.T, s *EntriesService, key string, from, n int) {
+	t.Helper()
+	base := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	for i := from; i < from+n; i++ {
+		rec := HistoryRecord{Time: base.Add(time.Duration(i) * time.Minute), IP: fmt.Sprintf("10.0.0.%d", i),
+			UA: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"}
+		if _, err := s.RecorduaNewlinejson(key, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func visitIPs(records []HistoryRecord) []string {
+	out := make([]string, 0, len(records))
+	for _, rec := range records {
+		out = append(out, rec.IP)
+	}
+	return out
+}
+
+// readPages 从 cursor 开始逐页读到末尾
+func readPages(t *testing.T, s *EntriesService, key, cursor string, limit int) []HistoryRecord {
+	t.Helper()
+	var out []HistoryRecord
+	for i := 0; ; i++ {
+		if i > 1000 {
+			t.Fatal("paging does not terminate")
+		}
+		page, err := s.HistoryPage(key, cursor, limit, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, page.Records...)
+		if page.Next == "" {
+			return out
+		}
+		cursor = page.Next
+	}
+}
+
+func TestHistoryPageAcrossRotation(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 1)
+	key := kis[0].Key
+	entries.SetHistoryRotation(600, 100)
+	recordVisits(t, entries, key, 0, 20)
+	if n := len(entries.historyArchives(key)); n < 2 {
+		t.Fatalf("%d archives, want several", n)
+	}
+	all, err := entries.ReadUARecords(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := visitIPs(all)
+
+	for _, limit := range []int{1, 3, 7, 100} {
+		if got := visitIPs(readPages(t, entries, key, "", limit)); !slices.Equal(got, want) {
+			t.Fatalf("limit %d: pages = %v, want %v", limit, got, want)
+		}
+	}
+
+	// 翻页期间发生轮转：游标中的分段编号不变，后续页面接着第一页往下读
+	first, err := entries.HistoryPage(key, "", 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordVisits(t, entries, key, 20, 10)
+	got := append(visitIPs(first.Records), visitIPs(readPages(t, entries, key, first.Next, 4))...)
+	if !slices.Equal(got, want) {
+		t.Fatalf("pages across rotation = %v, want %v", got, want)
+	}
+
+	// 旧版本的游标 "0:" 表示当前文件
+	latest, _ := entries.HistoryPage(key, "", 2, 0)
+	legacy, err := entries.HistoryPage(key, "0:", 2, 0)
+	if err != nil || !slices.Equal(visitIPs(legacy.Records), visitIPs(latest.Records)) {
+		t.Fatalf("legacy cursor = %v, %v; want %v", visitIPs(legacy.Records), err, visitIPs(latest.Records))
+	}
+
+	// 游标指向的归档已被删除时返回空页
+	entries.SetHistoryRotation(600, 1)
+	recordVisits(t, entries, key, 30, 10)
+	page, err := entries.HistoryPage(key, first.Next, 3, 0)
+	if err != nil || len(page.Records) != 0 || page.Next != "" {
+		t.Fatalf("cursor into deleted archive = %+v, %v", page, err)
+	}
+
+	for _, c := range []string{"x", "1", "-1:", "1:-5", "1:x"} {
+		if _, err := entries.HistoryPage(key, c, 3, 0); err == nil {
+			t.Errorf("cursor %q accepted", c)
+		}
+	}
+}
+
+// 文件被重写后旧游标的偏移不再落在行首，甚至超出文件末尾：跳过半行，不报错
+func TestHistoryPageStaleCursor(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 1)
+	key := kis[0].Key
+	if err := entries.SaveData(key, EntryData{}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	recordVisits(t, entries, key, 0, 10)
+	all, _ := entries.ReadUARecords(key)
+	known := map[string]bool{}
+	for _, rec := range all {
+		known[rec.Time.String()] = true
+	}
+
+	first, err := entries.HistoryPage(key, "", 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 匿名化改写每一行，偏移随之错开
+	entries.SetPrivacy(PrivacyPolicy{IPMode: IPModeFull, Retention: time.Hour, Action: RetentionAnonymize})
+	if changed, _, err := entries.EnforcePrivacy(); err != nil || changed != 10 {
+		t.Fatalf("EnforcePrivacy = %d, %v", changed, err)
+	}
+	page, err := entries.HistoryPage(key, first.Next, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Records) > len(all) {
+		t.Fatalf("%d records from a stale cursor", len(page.Records))
+	}
+	for i, rec := range page.Records {
+		if !known[rec.Time.String()] {
+			t.Fatalf("record %d = %+v, not in the history", i, rec)
+		}
+		if i > 0 && rec.Time.After(page.Records[i-1].Time) {
+			t.Fatalf("records out of order: %v after %v", rec.Time, page.Records[i-1].Time)
+		}
+	}
+
+	if _, err := entries.EraseVisitors(key); err != nil {
+		t.Fatal(err)
+	}
+	page, err = entries.HistoryPage(key, first.Next, 100, 0)
+	if err != nil || len(page.Records) != 0 {
+		t.Fatalf("stale cursor after erase = %d records, %v", len(page.Records), err)
+	}
+}
+
+type scannedLine struct {
+	line  string
+	start int64
+}
+
+// 行跨越 64KB 读取块的边界、超过一个块、空行与末尾没有换行时，倒序读出的行与偏移都正确
+func TestScanFileBackwardChunks(t *testing.T) {
+	const chunk = 64 << 10
+	tail := "tail\n"
+	for d := -3; d <= 3; d++ {
+		for _, trailingNewline := range []bool{true, false} {
+			var b strings.Builder
+			for i := 0; i < 5; i++ {
+				fmt.Fprintf(&b, "short-%d\n", i)
+			}
+			b.WriteString("\n") // 空行跳过
+			b.WriteString("huge:" + strings.Repeat("h", 2*chunk+17) + "\n")
+			b.WriteString("short-5\n")
+			// 末尾的块从文件末尾往前 chunk 字节开始，让 big 的行首落在边界附近 d 字节处
+			b.WriteString("big:" + strings.Repeat("b", chunk-len(tail)-1-d-4) + "\n")
+			content := b.String() + tail
+			if !trailingNewline {
+				content = strings.TrimSuffix(content, "\n")
+			}
+			p := filepath.Join(t.TempDir(), "history.ndjson")
+			if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			var lines []scannedLine
+			var off int64
+			for _, l := range strings.SplitAfter(content, "\n") {
+				if s := strings.TrimSuffix(l, "\n"); s != "" {
+					lines = append(lines, scannedLine{s, off})
+				}
+				off += int64(len(l))
+			}
+			ends := []int64{-1, int64(len(content)) + 100}
+			for _, l := range lines {
+				ends = append(ends, l.start)
+			}
+			for _, end := range ends {
+				var want []scannedLine
+				for _, l := range slices.Backward(lines) {
+					if end < 0 || end > int64(len(content)) || l.start < end {
+						want = append(want, l)
+					}
+				}
+				var got []scannedLine
+				err := scanFileBackward(p, end, func(line []byte, start int64) bool {
+					got = append(got, scannedLine{string(line), start})
+					return true
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(got, want) {
+					t.Fatalf("d=%d newline=%v end=%d: got %d lines, want %d", d, trailingNewline, end, len(got), len(want))
+				}
+			}
+
+			n := 0
+			_ = scanFileBackward(p, -1, func([]byte, int64) bool { n++; return n < 2 })
+			if n != 2 {
+				t.Fatalf("scan continued after fn returned false: %d calls", n)
+			}
+		}
+	}
+}
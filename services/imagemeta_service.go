@@ -0,0 +1,271 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/kolesa-team/go-webp/decoder"
+	"github.com/kolesa-team/go-webp/webp"
+)
+
+// imageDescribeVersion 图片元数据的生成规则版本；支持新的格式或改了占位图算法时加一，启动迁移会重新处理旧版本没生成出占位图的图片
+const imageDescribeVersion = 1
+
+// inspectImage 从原始字节中提取图片元数据：大小、hash、尺寸、拍摄时间
+func inspectImage(buf []byte, mediaType string) ImageRecord {
+	sum := sha256.Sum256(buf)
+	rec := ImageRecord{
+		MIME:      mediaType,
+		Size:      int64(len(buf)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		Described: imageDescribeVersion,
+	}
+	rec.Width, rec.Height = imageSize(buf, mediaType)
+
+	ex := parseExif(buf)
+	if !ex.captured.IsZero() {
+		t := ex.captured
+		rec.CapturedAt = &t
+	}
+	// HEIF 的旋转由容器内的 irot 描述，libheif 解码时已经处理
+	if isHeif(mediaType) {
+		ex.orientation = 0
+	}
+	// EXIF 方向 5~8 表示旋转 90°，显示尺寸需要互换
+	if ex.orientation >= 5 && ex.orientation <= 8 {
+		rec.Width, rec.Height = rec.Height, rec.Width
+	}
+	rec.Placeholder = lqip(buf, mediaType, ex.orientation)
+	return rec
+}
+
+// lqipSize 占位图长边像素数，浏览器放大后自然呈现模糊效果
+const lqipSize = 16
+
+// lqip 生成极小的 JPEG 占位图（data URI），在原图加载完成前作为背景显示
+func lqip(buf []byte, mediaType string, orientation int) string {
+	img, err := decodeImage(buf, mediaType)
+	if err != nil {
+		return ""
+	}
+	small := orient(downscale(img, lqipSize), orientation)
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, small, &jpeg.Options{Quality: 60}); err != nil {
+		return ""
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(out.Bytes())
+}
+
+// downscale 区域平均缩小到长边不超过 size
+func downscale(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return image.NewRGBA(image.Rect(0, 0, 1, 1))
+	}
+	dw, dh := size, size
+	if w >= h {
+		dh = int(math.Max(1, math.Round(float64(h)*float64(size)/float64(w))))
+	} else {
+		dw = int(math.Max(1, math.Round(float64(w)*float64(size)/float64(h))))
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+			// 大图只抽样，避免逐像素累加太慢
+			step := max(1, (x1-x0)/8)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy += step {
+				for sx := x0; sx < x1; sx += step {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// orient 按 EXIF 方向旋转/翻转（src 须以 (0,0) 为原点）
+func orient(src *image.RGBA, o int) *image.RGBA {
+	if o < 2 || o > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			si, di := src.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// imageSize 读取图片尺寸；能只读头部的格式不做完整解码
+func imageSize(buf []byte, mediaType string) (int, int) {
+	switch {
+	case isHeif(mediaType):
+		img, err := decodeImage(buf, mediaType)
+		if err != nil {
+			return 0, 0
+		}
+		b := img.Bounds()
+		return b.Dx(), b.Dy()
+	case mediaType == "image/webp" || hasWebPMagic(buf):
+		cfg, err := webp.DecodeConfig(bytes.NewReader(buf), &decoder.Options{})
+		if err != nil {
+			return 0, 0
+		}
+		return cfg.Width, cfg.Height
+	default:
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(buf))
+		if err != nil {
+			return 0, 0
+		}
+		return cfg.Width, cfg.Height
+	}
+}
+
+func isHeif(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "image/heic") || strings.HasPrefix(mediaType, "image/heif") || mediaType == "image/avif"
+}
+
+type exifInfo struct {
+	captured    time.Time
+	orientation int
+}
+
+const (
+	exifTagOrientation        = 0x0112
+	exifTagDateTime           = 0x0132
+	exifTagExifIFD            = 0x8769
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagOffsetTimeOriginal = 0x9011
+)
+
+// parseExif 在文件中查找 "Exif\0\0" 后的 TIFF 结构并读取拍摄时间与方向。
+// JPEG 的 APP1 段和 HEIC 的 Exif item 都是这个格式，所以不区分容器直接搜索。
+func parseExif(buf []byte) exifInfo {
+	var out exifInfo
+	i := bytes.Index(buf, []byte("Exif\x00\x00"))
+	if i < 0 {
+		return out
+	}
+	tiff := buf[i+6:]
+	if len(tiff) < 8 {
+		return out
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return out
+	}
+	if bo.Uint16(tiff[2:4]) != 42 {
+		return out
+	}
+
+	tags := map[uint16][]byte{}
+	readIFD(tiff, bo, bo.Uint32(tiff[4:8]), tags)
+	if p, ok := tags[exifTagExifIFD]; ok && len(p) >= 4 {
+		readIFD(tiff, bo, bo.Uint32(p), tags)
+	}
+
+	if v, ok := tags[exifTagOrientation]; ok && len(v) >= 2 {
+		out.orientation = int(bo.Uint16(v))
+	}
+	raw := exifString(tags[exifTagDateTimeOriginal])
+	if raw == "" {
+		raw = exifString(tags[exifTagDateTime])
+	}
+	if raw == "" {
+		return out
+	}
+	// 有时区偏移就用，否则按服务器本地时区理解
+	if off := exifString(tags[exifTagOffsetTimeOriginal]); off != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", raw+off); err == nil {
+			out.captured = t
+			return out
+		}
+	}
+	if t, err := time.ParseInLocation("2006:01:02 15:04:05", raw, time.Local); err == nil {
+		out.captured = t
+	}
+	return out
+}
+
+// readIFD 读取一个 IFD 中的条目，值大于 4 字节时按偏移取数据
+func readIFD(tiff []byte, bo binary.ByteOrder, off uint32, tags map[uint16][]byte) {
+	if int(off)+2 > len(tiff) {
+		return
+	}
+	n := int(bo.Uint16(tiff[off:]))
+	typeSize := map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+	for k := 0; k < n; k++ {
+		e := int(off) + 2 + k*12
+		if e+12 > len(tiff) {
+			return
+		}
+		tag := bo.Uint16(tiff[e:])
+		typ := bo.Uint16(tiff[e+2:])
+		count := bo.Uint32(tiff[e+4:])
+		size := typeSize[typ] * count
+		if size == 0 || size > 1<<16 {
+			continue
+		}
+		if size <= 4 {
+			tags[tag] = tiff[e+8 : e+8+int(size)]
+			continue
+		}
+		vo := bo.Uint32(tiff[e+8:])
+		if uint64(vo)+uint64(size) > uint64(len(tiff)) {
+			continue
+		}
+		tags[tag] = tiff[vo : vo+size]
+	}
+}
+
+func exifString(v []byte) string {
+	return strings.TrimSpace(strings.TrimRight(string(v), "\x00"))
+}
@@ -0,0 +1,593 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailtrackerProject/helper"
+	"mailtrackerProject/models"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 批量导入的限制
+const (
+	ImportMaxRows     = 1000
+	ImportMaxCSV      = 5 << 20   // 5MB
+	ImportMaxZIP      = 512 << 20 // 512MB
+	importStageMaxAge = 24 * time.Hour
+	importMaxImage    = 40 << 20 // 与单张上传的限制一致
+)
+
+var ErrImportNotFound = errors.New("import not found or expired")
+
+// ImportAutoKey key 列填这些值（或留空）时自动分配最早生成的未使用 key
+var ImportAutoKey = []string{"", "auto", "next"}
+
+// importColumns CSV 表头（不区分大小写）到字段的映射，兼容创建表单里的字段名
+var importColumns = map[string]string{
+	"key":                           "key",
+	"entryid":                       "key",
+	"recipient":                     "recipient",
+	"recipientname":                 "recipient",
+	"postdate":                      "postDate",
+	"origin":                        "origin",
+	"originlocation":                "origin",
+	"originlat":                     "originLat",
+	"originlon":                     "originLon",
+	"remarks":                       "remarks",
+	"encryptmethod":                 "encryptMethod",
+	"password":                      "password",
+	"encryptpassword":               "password",
+	"lookuplimit":                   "lookupLimit",
+	"lookupavailableafter":          "lookupLimit",
+	"lookuplimitavailableafterdate": "lookupLimit",
+	"trackingnumber":                "trackingNumber",
+	"carrier":                       "carrier",
+	"images":                        "images",
+	"captions":                      "captions",
+	"draft":                         "draft",
+}
+
+// 导入行的处理方式
+const (
+	ImportCreate    = "create"
+	ImportOverwrite = "overwrite"
+	ImportSkip      = "skip"
+)
+
+// ImportRow CSV 中一行的校验与导入结果
+type ImportRow struct {
+	Line      int // CSV 中的行号（表头为第 1 行）
+	Key       string
+	Allocated bool // key 是自动分配的
+	Recipient string
+	PostDate  string
+	Images    []string
+	Draft     bool
+	Action    string
+	Error     string
+	Done      bool   // 已写入（仅提交后）
+	Password  string // 密码加密时的查询密码，留空时为随机生成的（仅提交后）
+
+	form importForm
+}
+
+// ImportReport 预览或提交的结果
+type ImportReport struct {
+	ID        string
+	Overwrite bool
+	Committed bool
+	Error     string // 整个文件的错误，如表头不对
+	Rows      []ImportRow
+	ZipImages int
+	Valid     int
+	Invalid   int
+	Created   int
+	Updated   int
+	Failed    int
+}
+
+// ImportService 管理后台的批量创建：上传的 CSV 与图片 ZIP 先暂存到 data/imports/<id>/，
+// 预览时只做校验，确认后按行调用 EntryCreateService 创建，与创建页走同一套逻辑
+type ImportService struct {
+	dir     string
+	creator *EntryCreateService
+	entries *EntriesService
+	keys    *KeysService
+	mu      sync.Mutex // 同一时间只提交一个导入，避免自动分配到同一个 key
+}
+
+func NewImportService(dir string, creator *EntryCreateService, entries *EntriesService, keys *KeysService) *ImportService {
+	return &ImportService{dir: dir, creator: creator, entries: entries, keys: keys}
+}
+
+func (s *ImportService) stageDir(id string) (string, error) {
+	if !models.ValidKey(id) {
+		return "", ErrImportNotFound
+	}
+	dir := filepath.Join(s.dir, id)
+	if _, err := os.Stat(filepath.Join(dir, "entries.csv")); err != nil {
+		return "", ErrImportNotFound
+	}
+	return dir, nil
+}
+
+// Stage 暂存上传的 CSV 与可选的图片 ZIP（zipFile 为 nil 表示没有），返回导入 ID。
+// 顺带清理超过 24 小时未提交的暂存
+func (s *ImportService) Stage(csvFile, zipFile io.Reader) (string, error) {
+	s.cleanup()
+	suffix, err := helper.RandKey(6)
+	if err != nil {
+		return "", err
+	}
+	id := time.Now().Format("20060102-150405") + "-" + suffix
+	dir := filepath.Join(s.dir, id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	if err := writeLimited(filepath.Join(dir, "entries.csv"), csvFile, ImportMaxCSV); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("csv: %w", err)
+	}
+	if zipFile != nil {
+		if err := writeLimited(filepath.Join(dir, "images.zip"), zipFile, ImportMaxZIP); err != nil {
+			_ = os.RemoveAll(dir)
+			return "", fmt.Errorf("zip: %w", err)
+		}
+	}
+	return id, nil
+}
+
+func writeLimited(name string, r io.Reader, limit int64) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("file too large (max %d MB)", limit>>20)
+	}
+	return err
+}
+
+func (s *ImportService) cleanup() {
+	des, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, d := range des {
+		info, err := d.Info()
+		if err != nil || !d.IsDir() || time.Since(info.ModTime()) < importStageMaxAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, d.Name())); err != nil {
+			log.Printf("remove import stage %s: %v", d.Name(), err)
+		}
+	}
+}
+
+// Discard 放弃一个暂存的导入
+func (s *ImportService) Discard(id string) error {
+	dir, err := s.stageDir(id)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// importAllocation 预览时自动分配的 key（CSV 行号 -> key），保存在暂存目录，提交时按它写入
+const importAllocation = "allocation.json"
+
+// Preview 校验暂存的导入，除记下自动分配的 key 外不写入任何数据。overwrite 为 false 时已有内容的 key 报错
+func (s *ImportService) Preview(id string, overwrite bool) (*ImportReport, error) {
+	dir, err := s.stageDir(id)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := openStageZip(dir)
+	if err != nil {
+		return nil, err
+	}
+	if zr != nil {
+		defer zr.Close()
+	}
+	rep := s.plan(id, dir, zr, overwrite, nil)
+	alloc := map[int]string{}
+	for _, row := range rep.Rows {
+		if row.Allocated {
+			alloc[row.Line] = row.Key
+		}
+	}
+	if err := writeJSONFile(filepath.Join(dir, importAllocation), alloc); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// Commit 重新校验后逐行创建条目（跳过校验失败的行），完成后删除暂存。
+// 自动分配的 key 与预览时显示的一致；该 key 之后被用掉的行报错，不会改写到别的 key 或覆盖已有内容
+func (s *ImportService) Commit(id string, overwrite bool) (*ImportReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir, err := s.stageDir(id)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := openStageZip(dir)
+	if err != nil {
+		return nil, err
+	}
+	if zr != nil {
+		defer zr.Close()
+	}
+	// 没有预览过（allocation.json 不存在）时重新分配
+	var alloc map[int]string
+	if err := readJSONFile(filepath.Join(dir, importAllocation), &alloc); err != nil {
+		return nil, err
+	}
+	rep := s.plan(id, dir, zr, overwrite, alloc)
+	if rep.Error != "" {
+		return rep, nil
+	}
+	images := zipIndex(zr)
+	rep.Committed = true
+	for i := range rep.Rows {
+		row := &rep.Rows[i]
+		if row.Action == ImportSkip {
+			continue
+		}
+		// 预览之后可能有人在创建页用掉了这个 key
+		if s.entries.HasData(row.Key) && (!overwrite || row.Allocated) {
+			row.Error, row.Action = "entry already exists", ImportSkip
+			if row.Allocated {
+				row.Error = errAllocatedKeyUsed
+			}
+			rep.Failed++
+			continue
+		}
+		captions := splitList(row.form.caption)
+		uploads := make([]ImageUpload, len(row.Images))
+		for j, name := range row.Images {
+			zf := images[name]
+			uploads[j] = ImageUpload{Name: name, Open: func() (io.ReadCloser, error) { return zf.Open() }}
+			if j < len(captions) {
+				uploads[j].Caption = captions[j]
+			}
+		}
+		env, existed, err := s.creator.Create(row.form.EntryForm, uploads)
+		if err != nil {
+			row.Error = err.Error()
+			rep.Failed++
+			continue
+		}
+		row.Done = true
+		if existed {
+			rep.Updated++
+		} else {
+			rep.Created++
+		}
+		if e := env.Data.Encrypt; e != nil && e.Method != nil && *e.Method == EncryptPassword && e.Password != nil {
+			row.Password = *e.Password
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("remove import stage %s: %v", id, err)
+	}
+	return rep, nil
+}
+
+func openStageZip(dir string) (*zip.ReadCloser, error) {
+	zr, err := zip.OpenReader(filepath.Join(dir, "images.zip"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("zip: %w", err)
+	}
+	return zr, nil
+}
+
+// zipIndex 按文件名（不含目录）索引 ZIP 中的文件；重名的记为 nil，引用时报错
+func zipIndex(zr *zip.ReadCloser) map[string]*zip.File {
+	idx := map[string]*zip.File{}
+	if zr == nil {
+		return idx
+	}
+	for _, f := range zr.File {
+		name := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		if _, dup := idx[name]; dup {
+			idx[name] = nil
+			continue
+		}
+		idx[name] = f
+	}
+	return idx
+}
+
+// importForm 一行 CSV 解析出的表单，图片说明单独保存到提交时再用
+type importForm struct {
+	EntryForm
+	auto     bool
+	images   []string
+	caption  string
+	rowError string
+}
+
+// splitList 图片与说明列用分号分隔
+func splitList(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	parts := strings.Split(s, ";")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+func truthy(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "y", "on", "是":
+		return true
+	}
+	return false
+}
+
+// parseImportCSV 读取 CSV，返回每行的表单（行内的格式错误记在 rowError 里）
+func parseImportCSV(name string) ([]importForm, []int, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	b = bytes.TrimPrefix(b, []byte("\ufeff")) // Excel 另存的 CSV 带 BOM
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read header: %w", err)
+	}
+	cols := make([]string, len(header))
+	var unknown []string
+	for i, h := range header {
+		field, ok := importColumns[strings.ToLower(strings.TrimSpace(h))]
+		if !ok {
+			unknown = append(unknown, h)
+			continue
+		}
+		if slices.Contains(cols, field) {
+			return nil, nil, fmt.Errorf("duplicate column %q", h)
+		}
+		cols[i] = field
+	}
+	if len(unknown) > 0 {
+		return nil, nil, fmt.Errorf("unknown columns: %s", strings.Join(unknown, ", "))
+	}
+	for _, need := range []string{"recipient", "postDate", "origin"} {
+		if !slices.Contains(cols, need) {
+			return nil, nil, fmt.Errorf("missing column %q", need)
+		}
+	}
+
+	var forms []importForm
+	var lines []int
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := r.FieldPos(0)
+		if slices.IndexFunc(rec, func(v string) bool { return strings.TrimSpace(v) != "" }) < 0 {
+			continue // 空行
+		}
+		if len(forms) == ImportMaxRows {
+			return nil, nil, fmt.Errorf("too many rows (max %d)", ImportMaxRows)
+		}
+		v := map[string]string{}
+		for i, field := range cols {
+			if i < len(rec) {
+				v[field] = strings.TrimSpace(rec[i])
+			}
+		}
+		forms = append(forms, rowForm(v))
+		lines = append(lines, line)
+	}
+	if len(forms) == 0 {
+		return nil, nil, errors.New("no rows")
+	}
+	return forms, lines, nil
+}
+
+// rowForm 把一行的值转成创建表单；缺省值与创建页一致（按收件人姓名加密）
+func rowForm(v map[string]string) importForm {
+	f := importForm{
+		EntryForm: EntryForm{
+			Key:             v["key"],
+			RecipientName:   v["recipient"],
+			Remarks:         v["remarks"],
+			OriginLocation:  v["origin"],
+			PostDate:        v["postDate"],
+			EncryptMethod:   strings.ToLower(v["encryptMethod"]),
+			EncryptPassword: v["password"],
+			LookupLimitType: LookupLimitNone,
+			TrackingNumber:  v["trackingNumber"],
+			Carrier:         v["carrier"],
+			Draft:           truthy(v["draft"]),
+		},
+		images:  splitList(v["images"]),
+		caption: v["captions"],
+	}
+	f.auto = slices.Contains(ImportAutoKey, strings.ToLower(f.Key))
+	if f.EncryptMethod == "" {
+		f.EncryptMethod = EncryptRecipient
+	}
+	// 查询时间限制：留空或 none 为不限，填日期表示该日期之后才能查询
+	if l := v["lookupLimit"]; l != "" && !strings.EqualFold(l, LookupLimitNone) {
+		f.LookupLimitType, f.LookupAvailableAfter = LookupLimitAfter, l
+	}
+	if v["originLat"] != "" || v["originLon"] != "" {
+		lat, errLat := strconv.ParseFloat(v["originLat"], 64)
+		lon, errLon := strconv.ParseFloat(v["originLon"], 64)
+		if errLat != nil || errLon != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			f.rowError = "invalid originLat/originLon"
+		} else {
+			f.OriginLat, f.OriginLon = &lat, &lon
+		}
+	}
+	for _, need := range []struct{ name, val string }{
+		{"recipient", f.RecipientName}, {"postDate", f.PostDate}, {"origin", f.OriginLocation},
+	} {
+		if need.val == "" && f.rowError == "" {
+			f.rowError = need.name + " is required"
+		}
+	}
+	return f
+}
+
+// unusedKeys 还没有内容且未归档的 key，按生成时间从早到晚
+func (s *ImportService) unusedKeys() []string {
+	list := s.keys.List() // 从新到旧
+	var out []string
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].ArchivedAt == nil && !s.entries.HasData(list[i].Key) {
+			out = append(out, list[i].Key)
+		}
+	}
+	return out
+}
+
+const errAllocatedKeyUsed = "allocated key is no longer free"
+
+// plan 解析、分配 key 并逐行校验。alloc 不为 nil 时自动分配的行使用其中预览时的 key，而不是重新分配
+func (s *ImportService) plan(id, dir string, zr *zip.ReadCloser, overwrite bool, alloc map[int]string) *ImportReport {
+	rep := &ImportReport{ID: id, Overwrite: overwrite}
+	forms, lines, err := parseImportCSV(filepath.Join(dir, "entries.csv"))
+	if err != nil {
+		rep.Error = err.Error()
+		return rep
+	}
+	images := zipIndex(zr)
+	rep.ZipImages = len(images)
+
+	// 先收集显式写了的 key，自动分配时跳过它们
+	explicit := map[string]int{}
+	for _, f := range forms {
+		if !f.auto {
+			explicit[f.Key]++
+		}
+	}
+	var pool []string
+	for _, k := range s.unusedKeys() {
+		if explicit[k] == 0 {
+			pool = append(pool, k)
+		}
+	}
+
+	for i, f := range forms {
+		row := ImportRow{Line: lines[i], Recipient: f.RecipientName, PostDate: f.PostDate, Images: f.images, Draft: f.Draft, Action: ImportCreate}
+		if f.auto {
+			f.Key = ""
+			if alloc != nil {
+				f.Key = alloc[lines[i]]
+				row.Allocated = f.Key != ""
+			} else if len(pool) > 0 {
+				f.Key, pool = pool[0], pool[1:]
+				row.Allocated = true
+			}
+		}
+		row.Key = f.Key
+		switch {
+		case f.auto && !row.Allocated:
+			row.Error = "no unused key left"
+		case alloc != nil && row.Allocated && s.entries.HasData(f.Key):
+			row.Error = errAllocatedKeyUsed
+		default:
+			row.Error = s.checkRow(f, explicit, images, overwrite)
+		}
+		if row.Error != "" {
+			if row.Allocated && alloc == nil { // 未通过的行不占用 key，留给后面的行
+				pool = append([]string{f.Key}, pool...)
+				f.Key, row.Key, row.Allocated = "", "", false
+			}
+			row.Action = ImportSkip
+			rep.Invalid++
+		} else {
+			if s.entries.HasData(f.Key) {
+				row.Action = ImportOverwrite
+			}
+			rep.Valid++
+		}
+		row.form = f
+		rep.Rows = append(rep.Rows, row)
+	}
+	return rep
+}
+
+func (s *ImportService) checkRow(f importForm, explicit map[string]int, images map[string]*zip.File, overwrite bool) string {
+	switch {
+	case f.rowError != "":
+		return f.rowError
+	case !f.auto && explicit[f.Key] > 1:
+		return "duplicate key in csv"
+	}
+	if _, err := s.creator.Validate(f.EntryForm, len(f.images)); err != nil {
+		return err.Error()
+	}
+	if s.entries.HasData(f.Key) && !overwrite {
+		return "entry already exists"
+	}
+	for _, name := range f.images {
+		zf, ok := images[name]
+		switch {
+		case !ok:
+			return "image not found in zip: " + name
+		case zf == nil:
+			return "ambiguous image name in zip: " + name
+		}
+		if err := checkZipImage(zf); err != nil {
+			return name + ": " + err.Error()
+		}
+	}
+	return ""
+}
+
+// checkZipImage 预览时检查大小并按文件头判断是否为图片，与保存时的检查一致
+func checkZipImage(zf *zip.File) error {
+	if zf.UncompressedSize64 > importMaxImage {
+		return errors.New("file too large")
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	header := make([]byte, 8192)
+	n, err := io.ReadFull(rc, header)
+	if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if _, err := detectMime(header[:n]); err != nil {
+		return fmt.Errorf("unsupported file type: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newImportFixture(t *testing.T, n int) (*ImportService, *EntriesService, []KeyInfo) {
+	t.Helper()
+	entries, kis := newEntriesFixture(t, n)
+	files := NewFilesService(entries.dataDir, entries.blobs, nil)
+	creator := NewEntryCreateService(entries, files, entries.keys, nil, nil)
+	return NewImportService(filepath.Join(entries.dataDir, "imports"), creator, entries, entries.keys), entries, kis
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// stageImport 暂存 CSV 与按文件名（可含目录）打包的 ZIP；files 为 nil 时不上传 ZIP
+func stageImport(t *testing.T, s *ImportService, csv string, files map[string][]byte) string {
+	t.Helper()
+	var zipFile io.Reader
+	if files != nil {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, b := range files {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		zipFile = &buf
+	}
+	id, err := s.Stage(strings.NewReader(csv), zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func recipientOf(t *testing.T, entries *EntriesService, key string) string {
+	t.Helper()
+	env, err := entries.LoadData(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return derefString(env.Data.RecipientName)
+}
+
+// 提交时使用预览时分配的 key；该 key 之后被用掉的行报错，不改用别的 key，也不覆盖
+func TestImportAllocation(t *testing.T) {
+	s, entries, kis := newImportFixture(t, 3)
+	const csv = "key,recipient,postDate,origin\nauto,甲,2026-10-01,Berlin\n,乙,2026-10-01,Berlin\n"
+	id := stageImport(t, s, csv, nil)
+	rep, err := s.Preview(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Valid != 2 || !rep.Rows[0].Allocated || !rep.Rows[1].Allocated || rep.Rows[0].Key == rep.Rows[1].Key {
+		t.Fatalf("preview rows = %+v", rep.Rows)
+	}
+	first, second := rep.Rows[0].Key, rep.Rows[1].Key
+	var alloc map[int]string
+	if err := readJSONFile(filepath.Join(s.dir, id, importAllocation), &alloc); err != nil || alloc[2] != first || alloc[3] != second {
+		t.Fatalf("allocation file = %v, %v", alloc, err)
+	}
+
+	// 预览之后有人在创建页用掉了第一个 key
+	name := "别人"
+	if err := entries.SaveData(first, EntryData{RecipientName: &name}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	rep, err = s.Commit(id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Rows[0].Error != errAllocatedKeyUsed || rep.Rows[0].Done || rep.Rows[0].Key != first {
+		t.Fatalf("row with used key = %+v", rep.Rows[0])
+	}
+	if !rep.Rows[1].Done || rep.Rows[1].Key != second || rep.Created != 1 {
+		t.Fatalf("commit = %+v, rows %+v", rep, rep.Rows)
+	}
+	if got := recipientOf(t, entries, first); got != "别人" {
+		t.Fatalf("used key overwritten: recipient %q", got)
+	}
+	if got := recipientOf(t, entries, second); got != "乙" {
+		t.Fatalf("second row recipient %q", got)
+	}
+	for _, ki := range kis {
+		if ki.Key != first && ki.Key != second && entries.HasData(ki.Key) {
+			t.Fatalf("row moved to unallocated key %s", ki.Key)
+		}
+	}
+	if _, err := s.Preview(id, false); !errors.Is(err, ErrImportNotFound) {
+		t.Fatalf("stage kept after commit: %v", err)
+	}
+}
+
+// 没有预览直接提交时重新分配，没有可用 key 的行报错
+func TestImportCommitWithoutPreview(t *testing.T) {
+	s, entries, _ := newImportFixture(t, 1)
+	id := stageImport(t, s, "key,recipient,postDate,origin\nauto,甲,2026-10-01,Berlin\nauto,乙,2026-10-01,Berlin\n", nil)
+	rep, err := s.Commit(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Created != 1 || !rep.Rows[0].Done || rep.Rows[1].Error != "no unused key left" {
+		t.Fatalf("commit = %+v, rows %+v", rep, rep.Rows)
+	}
+	if got := recipientOf(t, entries, rep.Rows[0].Key); got != "甲" {
+		t.Fatalf("recipient %q", got)
+	}
+}
+
+// ZIP 中按文件名（不含目录）查找图片：重名的、不存在的、不是图片的都报错；隐藏文件与 __MACOSX 忽略
+func TestImportZipImages(t *testing.T) {
+	s, entries, kis := newImportFixture(t, 6)
+	img := testPNG(t)
+	files := map[string][]byte{
+		"a.png":          img,
+		"one/b.png":      img,
+		"two/b.png":      img,
+		"__MACOSX/c.png": img,
+		"two/.d.png":     img,
+		"text.png":       []byte("not an image"),
+	}
+	rows := []struct{ key, images, want string }{
+		{kis[0].Key, "a.png", ""},
+		{kis[1].Key, "b.png", "ambiguous image name in zip: b.png"},
+		{kis[2].Key, "c.png", "image not found in zip: c.png"},
+		{kis[3].Key, ".d.png", "image not found in zip: .d.png"},
+		{kis[4].Key, "text.png", "text.png: unsupported file type"},
+		{kis[5].Key, "a.png; a.png", ""},
+	}
+	var b strings.Builder
+	b.WriteString("key,recipient,postDate,origin,images\n")
+	for _, r := range rows {
+		b.WriteString(r.key + ",甲,2026-10-01,Berlin," + r.images + "\n")
+	}
+	id := stageImport(t, s, b.String(), files)
+	rep, err := s.Preview(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.ZipImages != 3 {
+		t.Errorf("zip images = %d, want 3", rep.ZipImages)
+	}
+	for i, r := range rows {
+		if got := rep.Rows[i].Error; (r.want == "") != (got == "") || !strings.HasPrefix(got, r.want) {
+			t.Errorf("row %d (%s): error %q, want %q", i, r.images, got, r.want)
+		}
+	}
+
+	rep, err = s.Commit(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Created != 2 || rep.Invalid != 4 {
+		t.Fatalf("commit = %+v", rep)
+	}
+	env, err := entries.LoadData(kis[5].Key)
+	if err != nil || len(env.Data.Images) != 2 || env.Data.Images[0].File != env.Data.Images[1].File {
+		t.Fatalf("imported images = %+v, %v", env, err)
+	}
+	hash, _ := BlobHash(env.Data.Images[0].File)
+	if _, err := entries.blobs.Storage().Stat(blobName(hash)); err != nil {
+		t.Fatalf("blob: %v", err)
+	}
+	for _, k := range []string{kis[1].Key, kis[2].Key, kis[3].Key, kis[4].Key} {
+		if entries.HasData(k) {
+			t.Errorf("invalid row %s written", k)
+		}
+	}
+}
+
+// CSV 中重复的 key 两行都报错；已有内容的 key 不勾选覆盖时跳过，勾选后覆盖
+func TestImportOverwriteSkip(t *testing.T) {
+	s, entries, kis := newImportFixture(t, 3)
+	old := "旧"
+	if err := entries.SaveData(kis[0].Key, EntryData{RecipientName: &old}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	csv := "key,recipient,postDate,origin\n" +
+		kis[0].Key + ",新,2026-10-01,Berlin\n" +
+		kis[1].Key + ",乙,2026-10-01,Berlin\n" +
+		kis[2].Key + ",丙,2026-10-01,Berlin\n" +
+		kis[2].Key + ",丁,2026-10-01,Berlin\n"
+	actions := func(rep *ImportReport) []string {
+		var out []string
+		for _, r := range rep.Rows {
+			out = append(out, r.Action)
+		}
+		return out
+	}
+
+	id := stageImport(t, s, csv, nil)
+	rep, err := s.Preview(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(actions(rep), []string{ImportSkip, ImportCreate, ImportSkip, ImportSkip}) ||
+		rep.Rows[0].Error != "entry already exists" || rep.Rows[2].Error != "duplicate key in csv" || rep.Rows[3].Error != "duplicate key in csv" {
+		t.Fatalf("preview without overwrite = %+v", rep.Rows)
+	}
+	rep, err = s.Preview(id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(actions(rep), []string{ImportOverwrite, ImportCreate, ImportSkip, ImportSkip}) {
+		t.Fatalf("preview with overwrite = %+v", rep.Rows)
+	}
+
+	rep, err = s.Commit(id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Created != 1 || rep.Updated != 0 || recipientOf(t, entries, kis[0].Key) != "旧" {
+		t.Fatalf("commit without overwrite = %+v", rep)
+	}
+	if entries.HasData(kis[2].Key) {
+		t.Fatal("duplicate key written")
+	}
+
+	id = stageImport(t, s, "key,recipient,postDate,origin\n"+kis[0].Key+",新,2026-10-01,Berlin\n"+kis[1].Key+",乙2,2026-10-01,Berlin\n", nil)
+	rep, err = s.Commit(id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Updated != 2 || rep.Created != 0 {
+		t.Fatalf("commit with overwrite = %+v", rep)
+	}
+	if recipientOf(t, entries, kis[0].Key) != "新" || recipientOf(t, entries, kis[1].Key) != "乙2" {
+		t.Fatal("entries not overwritten")
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, id)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stage kept after commit: %v", err)
+	}
+}
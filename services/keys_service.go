@@ -15,6 +15,37 @@ type KeyInfo struct {
 	Key       string    `json:"key"`
 	CreatedAt time.Time `json:"created_at"`
 	Comment   string    `json:"comment"`
+	Batch     string    `json:"batch,omitempty"` // 同一次生成的 key 共用
+
+	ArchivedAt *time.Time `json:"archived_at,omitempty"` // 归档：列表中隐藏，查询时提示已归档
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`  // 移入回收站：对外视为不存在，可在保留期内恢复
+}
+
+// Lifecycle key（及其条目）的生命周期状态
+type Lifecycle string
+
+const (
+	LifecycleActive   Lifecycle = "active"
+	LifecycleArchived Lifecycle = "archived"
+	LifecycleDeleted  Lifecycle = "deleted"
+)
+
+func (ki KeyInfo) Lifecycle() Lifecycle {
+	switch {
+	case ki.DeletedAt != nil:
+		return LifecycleDeleted
+	case ki.ArchivedAt != nil:
+		return LifecycleArchived
+	}
+	return LifecycleActive
+}
+
+// BatchID 生成批次；旧数据没有记录批次，按备注与生成时间（分钟）归组
+func (ki KeyInfo) BatchID() string {
+	if ki.Batch != "" {
+		return ki.Batch
+	}
+	return ki.CreatedAt.Format("200601021504") + "-" + ki.Comment
 }
 
 type KeysService struct {
@@ -76,6 +107,12 @@ func (s *KeysService) Generate(n int, length int, comment string) ([]KeyInfo, er
 
 	out := make([]KeyInfo, 0, n)
 	newKeys := make([]string, 0, n)
+	now := time.Now()
+	suffix, err := helper.RandKey(4)
+	if err != nil {
+		return nil, err
+	}
+	batch := now.Format("20060102-150405") + "-" + suffix
 
 	// 最多尝试次数，避免极端情况下死循环
 	const maxAttemptsPerKey = 10_000
@@ -108,7 +145,7 @@ func (s *KeysService) Generate(n int, length int, comment string) ([]KeyInfo, er
 			return nil, errors.New("failed to generate unique key without collision")
 		}
 
-		ki := KeyInfo{Key: k, CreatedAt: time.Now(), Comment: comment}
+		ki := KeyInfo{Key: k, CreatedAt: now, Comment: comment, Batch: batch}
 		// 先写入内存；若 flush 失败我们会回滚
 		s.keys[k] = ki
 		newKeys = append(newKeys, k)
@@ -132,13 +169,104 @@ func (s *KeysService) Get(k string) (KeyInfo, bool) {
 	return ki, ok
 }
 
+// Revoke 从白名单移除 key；失败时回滚
+func (s *KeysService) Revoke(k string) (KeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ki, ok := s.keys[k]
+	if !ok {
+		return KeyInfo{}, ErrKeyNotFound
+	}
+	delete(s.keys, k)
+	if err := s.flushLocked(); err != nil {
+		s.keys[k] = ki
+		return KeyInfo{}, err
+	}
+	return ki, nil
+}
+
+// RevokeUnused 作废还没有条目的 key；在锁内调用 used 确认，创建条目的 SaveData 持有读锁，两者不会交错
+func (s *KeysService) RevokeUnused(k string, used func(key string) bool) (KeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ki, ok := s.keys[k]
+	if !ok {
+		return KeyInfo{}, ErrKeyNotFound
+	}
+	if used(k) {
+		return ki, ErrKeyUsed
+	}
+	delete(s.keys, k)
+	if err := s.flushLocked(); err != nil {
+		s.keys[k] = ki
+		return KeyInfo{}, err
+	}
+	return ki, nil
+}
+
+// RevokeDeleted 从白名单移除回收站中的 key；在锁内确认仍在回收站，已被恢复时返回 ErrNotInTrash
+func (s *KeysService) RevokeDeleted(k string) (KeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ki, ok := s.keys[k]
+	if !ok {
+		return KeyInfo{}, ErrKeyNotFound
+	}
+	if ki.DeletedAt == nil {
+		return ki, ErrNotInTrash
+	}
+	delete(s.keys, k)
+	if err := s.flushLocked(); err != nil {
+		s.keys[k] = ki
+		return KeyInfo{}, err
+	}
+	return ki, nil
+}
+
+// List 未删除的 key（含已归档），从新到旧
 func (s *KeysService) List() []KeyInfo {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	list := make([]KeyInfo, 0, len(s.keys))
 	for _, ki := range s.keys {
-		list = append(list, ki)
+		if ki.DeletedAt == nil {
+			list = append(list, ki)
+		}
 	}
 	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
 	return list
 }
+
+// Trash 回收站中的 key，最近删除的在前
+func (s *KeysService) Trash() []KeyInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []KeyInfo
+	for _, ki := range s.keys {
+		if ki.DeletedAt != nil {
+			list = append(list, ki)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DeletedAt.After(*list[j].DeletedAt) })
+	return list
+}
+
+// Update 修改一个 key 并落盘；fn 返回错误或落盘失败时不做改动
+func (s *KeysService) Update(k string, fn func(ki *KeyInfo) error) (KeyInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.keys[k]
+	if !ok {
+		return KeyInfo{}, ErrKeyNotFound
+	}
+	ki := old
+	if err := fn(&ki); err != nil {
+		return old, err
+	}
+	s.keys[k] = ki
+	if err := s.flushLocked(); err != nil {
+		s.keys[k] = old
+		return old, err
+	}
+	return ki, nil
+}
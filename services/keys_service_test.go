@@ -0,0 +1,38 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// 作废与创建条目同时进行：要么条目创建成功且 key 保留，要么 key 被作废且没有条目
+func TestRevokeUnusedConcurrentWithSave(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 50)
+	var wg sync.WaitGroup
+	for _, ki := range kis {
+		wg.Add(2)
+		go func(key string) {
+			defer wg.Done()
+			if err := entries.SaveData(key, EntryData{}, StatusPosted); err != nil && !errors.Is(err, ErrKeyNotFound) {
+				t.Error(err)
+			}
+		}(ki.Key)
+		go func(key string) {
+			defer wg.Done()
+			if _, err := entries.keys.RevokeUnused(key, entries.HasData); err != nil && !errors.Is(err, ErrKeyUsed) {
+				t.Error(err)
+			}
+		}(ki.Key)
+	}
+	wg.Wait()
+	for _, ki := range kis {
+		_, kept := entries.keys.Get(ki.Key)
+		if used := entries.HasData(ki.Key); used != kept {
+			t.Errorf("%s: entry %v, key %v", ki.Key, used, kept)
+		}
+	}
+	if _, err := entries.keys.Revoke("NOSUCHKEY"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Revoke unknown key = %v", err)
+	}
+}
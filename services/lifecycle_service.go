@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrKeyInactive = errors.New("key is archived or deleted")
+	ErrNotInTrash  = errors.New("key is not in trash")
+)
+
+// LifecycleService key 与条目的归档、回收站与彻底删除。
+// 归档与删除只修改 keys.json 中的标记，条目数据与图片保持不动，可随时恢复；
+// 回收站中超过保留期限的 key 由后台任务彻底删除
+type LifecycleService struct {
+	keys    *KeysService
+	entries *EntriesService
+	audit   *AuditLog
+	window  time.Duration // 回收站保留期限
+}
+
+func NewLifecycleService(keys *KeysService, entries *EntriesService, audit *AuditLog, window time.Duration) *LifecycleService {
+	return &LifecycleService{keys: keys, entries: entries, audit: audit, window: window}
+}
+
+// Window 回收站保留期限
+func (s *LifecycleService) Window() time.Duration { return s.window }
+
+// PurgeAt 回收站中的 key 被自动彻底删除的时间
+func (s *LifecycleService) PurgeAt(ki KeyInfo) time.Time {
+	if ki.DeletedAt == nil {
+		return time.Time{}
+	}
+	return ki.DeletedAt.Add(s.window)
+}
+
+func (s *LifecycleService) Archive(key string) (KeyInfo, error) {
+	return s.keys.Update(key, func(ki *KeyInfo) error {
+		if ki.DeletedAt != nil {
+			return ErrKeyInactive
+		}
+		if ki.ArchivedAt == nil {
+			now := time.Now()
+			ki.ArchivedAt = &now
+		}
+		return nil
+	})
+}
+
+func (s *LifecycleService) Unarchive(key string) (KeyInfo, error) {
+	return s.keys.Update(key, func(ki *KeyInfo) error {
+		ki.ArchivedAt = nil
+		return nil
+	})
+}
+
+// Delete 移入回收站
+func (s *LifecycleService) Delete(key string) (KeyInfo, error) {
+	return s.keys.Update(key, func(ki *KeyInfo) error {
+		if ki.DeletedAt == nil {
+			now := time.Now()
+			ki.DeletedAt = &now
+		}
+		return nil
+	})
+}
+
+// Restore 从回收站恢复，归档状态保持不变；彻底删除中途失败留下的条目目录一并移回。
+// 先恢复 key 再移回目录：与 Purge 同时进行时，Purge 删除 key 前会发现它已不在回收站
+func (s *LifecycleService) Restore(key string) (KeyInfo, error) {
+	ki, err := s.keys.Update(key, func(ki *KeyInfo) error {
+		if ki.DeletedAt == nil {
+			return ErrNotInTrash
+		}
+		ki.DeletedAt = nil
+		return nil
+	})
+	if err != nil {
+		return ki, err
+	}
+	return ki, s.entries.Unpurge(key)
+}
+
+// Purge 彻底删除回收站中的 key：entry.json、访问记录、图片引用与 key 记录
+func (s *LifecycleService) Purge(key string) error {
+	ki, ok := s.keys.Get(key)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if ki.DeletedAt == nil {
+		return ErrNotInTrash
+	}
+	// 删除 key 时在 key 的锁内再确认一次，期间被恢复的 key 不删，条目目录移回原处
+	return s.entries.Purge(key, func() error {
+		_, err := s.keys.RevokeDeleted(key)
+		return err
+	})
+}
+
+// PurgeExpired 彻底删除回收站中超过保留期限的 key，返回删除的数量。
+// 单个 key 失败不影响其余的，失败原因合并返回；期间被恢复的 key 直接跳过
+func (s *LifecycleService) PurgeExpired() (int, error) {
+	n := 0
+	var errs []error
+	for _, ki := range s.keys.Trash() {
+		if time.Now().Before(s.PurgeAt(ki)) {
+			continue
+		}
+		if err := s.Purge(ki.Key); err != nil {
+			if !errors.Is(err, ErrNotInTrash) && !errors.Is(err, ErrKeyNotFound) {
+				errs = append(errs, fmt.Errorf("purge %s: %w", ki.Key, err))
+			}
+			continue
+		}
+		n++
+		if err := s.audit.Record(AuditEvent{Actor: AuditActorSystem, Action: AuditKeyPurge, Target: ki.Key,
+			Detail: "回收站保留期已过，删除于 " + ki.DeletedAt.Format(time.RFC3339)}); err != nil {
+			log.Printf("audit: %v", err)
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// StartPurge 每隔 interval 清理一次回收站
+func (s *LifecycleService) StartPurge(interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for range t.C {
+			n, err := s.PurgeExpired()
+			if err != nil {
+				log.Printf("trash purge failed: %v", err)
+			}
+			if n > 0 {
+				log.Printf("trash purge removed %d keys", n)
+			}
+		}
+	}()
+}
+
+// Lifecycle 条目所属 key 的生命周期状态；key 不存在时返回空
+func (s *EntriesService) Lifecycle(key string) Lifecycle {
+	ki, ok := s.keys.Get(key)
+	if !ok {
+		return ""
+	}
+	return ki.Lifecycle()
+}
+
+// Purge 删除条目目录并释放图片引用，分两步进行：
+//  1. 在条目锁内把目录改名移到 purging/，之后条目对外即不存在；
+//  2. 释放条目锁后调用 removeKey 删除 key 记录，失败时把目录移回原处。
+//
+// removeKey 会获取 key 的锁，必须在条目锁之外调用：备份按 key、条目的顺序加锁，
+// 这里反过来持有会互相等待。两步都成功后才真正删除文件；
+// 中途退出时留在 purging/ 的目录在下次 Purge 同一个 key 时一并处理，或在 Restore 时移回
+func (s *EntriesService) Purge(key string, removeKey func() error) error {
+	trash := s.purgingDir(key)
+	moved, err := s.movePurging(key)
+	if err != nil {
+		return err
+	}
+	if err := removeKey(); err != nil {
+		if moved {
+			if rerr := s.Unpurge(key); rerr != nil {
+				log.Printf("purge %s: restore entry dir: %v", key, rerr)
+			}
+		}
+		return err
+	}
+	s.index.remove(key)
+	if _, err := os.Stat(trash); err != nil {
+		return nil
+	}
+	// 引用计数只影响 GC 的时机，GC 会重新扫描，读不到时不影响删除
+	var env EntryEnvelope
+	if err := readJSONFile(filepath.Join(trash, "entry.json"), &env); err == nil {
+		s.blobs.Release(env.ImageNames())
+	}
+	return os.RemoveAll(trash)
+}
+
+func (s *EntriesService) purgingDir(key string) string {
+	return filepath.Join(s.dataDir, "purging", key)
+}
+
+// movePurging 第一步：在条目锁与访问记录锁内把条目目录移到 purging/。
+// 返回 purging/ 下是否有待删除的目录（含上次中断留下的）
+func (s *EntriesService) movePurging(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mu := s.historyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	dir, trash := s.entryDir(key), s.purgingDir(key)
+	if _, err := os.Stat(dir); err == nil {
+		if err := os.MkdirAll(filepath.Dir(trash), 0o755); err != nil {
+			return false, err
+		}
+		if err := os.RemoveAll(trash); err != nil {
+			return false, err
+		}
+		if err := os.Rename(dir, trash); err != nil {
+			return false, err
+		}
+	}
+	_, err := os.Stat(trash)
+	return err == nil, nil
+}
+
+// Unpurge 把 purging/ 中尚未删除的条目目录移回原处；没有时什么也不做。
+// 原处已有目录时保留两者，返回错误交给管理员处理
+func (s *EntriesService) Unpurge(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mu := s.historyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	dir, trash := s.entryDir(key), s.purgingDir(key)
+	if _, err := os.Stat(trash); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("both %s and %s exist", dir, trash)
+	}
+	if err := os.Rename(trash, dir); err != nil {
+		return err
+	}
+	if env, err := s.LoadData(key); err == nil {
+		s.index.put(key, env)
+		s.index.setFirstView(key, s.firstView(key))
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newEntriesFixture 临时数据目录中的条目服务与 n 个尚未创建条目的 key
+func newEntriesFixture(t *testing.T, n int) (*EntriesService, []KeyInfo) {
+	t.Helper()
+	dir := t.TempDir()
+	keys := NewKeysService(filepath.Join(dir, "keys.json"))
+	if err := keys.Load(); err != nil {
+		t.Fatal(err)
+	}
+	blobs := NewBlobStore(dir, NewLocalStorage(filepath.Join(dir, "blobs")), 0)
+	kis, err := keys.Generate(n, 8, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEntriesService(dir, keys, blobs), kis
+}
+
+func newLifecycleFixture(t *testing.T, n int) (*LifecycleService, *BackupService, []KeyInfo) {
+	t.Helper()
+	entries, kis := newEntriesFixture(t, n)
+	name := "收件人"
+	for _, ki := range kis {
+		if err := entries.SaveData(ki.Key, EntryData{RecipientName: &name}, StatusPosted); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := entries.dataDir
+	audit := NewAuditLog(filepath.Join(dir, "audit.ndjson"), filepath.Join(dir, "audit.head"), nil)
+	lc := NewLifecycleService(entries.keys, entries, audit, time.Hour)
+	return lc, NewBackupService(dir, entries.keys, entries, entries.blobs, nil, nil), kis
+}
+
+// 彻底删除与备份同时进行时不能互相等待
+func TestPurgeConcurrentWithBackup(t *testing.T) {
+	lc, backups, kis := newLifecycleFixture(t, 40)
+	for _, ki := range kis {
+		if _, err := lc.Delete(ki.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 2)
+	stop := make(chan struct{})
+	go func() {
+		for _, ki := range kis {
+			if err := lc.Purge(ki.Key); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	go func() {
+		for {
+			select {
+			case <-stop:
+				done <- nil
+				return
+			default:
+			}
+			if _, err := backups.Backup(io.Discard); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				close(stop)
+			}
+		case <-timeout:
+			t.Fatal("purge and backup deadlocked")
+		}
+	}
+	if n := len(lc.keys.Trash()); n != 0 {
+		t.Fatalf("trash has %d keys after purge", n)
+	}
+	if lc.entries.HasData(kis[0].Key) {
+		t.Fatal("entry still exists after purge")
+	}
+}
+
+// 删除 key 记录失败时条目目录移回原处，恢复后可继续使用
+func TestPurgeRollback(t *testing.T) {
+	lc, _, kis := newLifecycleFixture(t, 1)
+	key := kis[0].Key
+	err := lc.entries.Purge(key, func() error { return io.ErrUnexpectedEOF })
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("Purge error = %v", err)
+	}
+	if !lc.entries.HasData(key) {
+		t.Fatal("entry not restored after failed purge")
+	}
+}
+
+// 检查回收站之后、删除 key 之前被恢复：key 与条目都保留
+func TestPurgeRestoredMeanwhile(t *testing.T) {
+	lc, _, kis := newLifecycleFixture(t, 1)
+	key := kis[0].Key
+	if _, err := lc.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	err := lc.entries.Purge(key, func() error {
+		if _, err := lc.Restore(key); err != nil {
+			return err
+		}
+		_, err := lc.keys.RevokeDeleted(key)
+		return err
+	})
+	if !errors.Is(err, ErrNotInTrash) {
+		t.Fatalf("Purge error = %v, want ErrNotInTrash", err)
+	}
+	if ki, ok := lc.keys.Get(key); !ok || ki.DeletedAt != nil {
+		t.Fatalf("key after restore = %+v, %v", ki, ok)
+	}
+	if !lc.entries.HasData(key) {
+		t.Fatal("entry lost after restore")
+	}
+}
+
+// 到期清理时已被恢复的 key 跳过，其余照常删除
+func TestPurgeExpiredSkipsRestored(t *testing.T) {
+	lc, _, kis := newLifecycleFixture(t, 3)
+	lc.window = 0
+	for _, ki := range kis {
+		if _, err := lc.Delete(ki.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := lc.Restore(kis[1].Key); err != nil {
+		t.Fatal(err)
+	}
+	n, err := lc.PurgeExpired()
+	if err != nil || n != 2 {
+		t.Fatalf("PurgeExpired = %d, %v", n, err)
+	}
+	if !lc.entries.HasData(kis[1].Key) || lc.entries.HasData(kis[0].Key) || lc.entries.HasData(kis[2].Key) {
+		t.Fatal("wrong entries purged")
+	}
+}
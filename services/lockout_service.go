@@ -0,0 +1,96 @@
+package services
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// LookupGuard 统计查询口令错误次数，连续失败过多时暂时锁定。
+// 按 key+客户端分别计数，只锁定出错的客户端，见过二维码的人无法借此把真正的收件人锁在外面。
+// 不设按 key 合计的锁定：换着网段就能把 key 整个锁住；换 IP 猜口令时每次提交仍要通过验证码
+type LookupGuard struct {
+	maxFailures int           // 单个客户端
+	window      time.Duration // 统计失败次数的时间窗口
+	lockout     time.Duration
+
+	mu    sync.Mutex
+	state map[string]*lookupFailures // key+"|"+客户端
+}
+
+type lookupFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func NewLookupGuard(maxFailures int, window, lockout time.Duration) *LookupGuard {
+	return &LookupGuard{maxFailures: maxFailures, window: window, lockout: lockout, state: map[string]*lookupFailures{}}
+}
+
+// lookupClient 客户端标识：IPv4 取完整地址，IPv6 取 /64（通常分给同一个用户）
+func lookupClient(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is6() {
+		p, _ := addr.Prefix(64)
+		return p.String()
+	}
+	return addr.String()
+}
+
+func lookupClientKey(key, ip string) string { return key + "|" + lookupClient(ip) }
+
+// Locked 返回客户端对该 key 的锁定截止时间；未锁定时返回零值
+func (g *LookupGuard) Locked(key, ip string) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if st, ok := g.state[lookupClientKey(key, ip)]; ok && time.Now().Before(st.lockedUntil) {
+		return st.lockedUntil
+	}
+	return time.Time{}
+}
+
+// Fail 记录一次失败；本次失败触发锁定时返回 true
+func (g *LookupGuard) Fail(key, ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	g.sweepLocked(now)
+	id := lookupClientKey(key, ip)
+	st, ok := g.state[id]
+	if !ok || now.Sub(st.first) > g.window {
+		st = &lookupFailures{first: now}
+		g.state[id] = st
+	}
+	st.count++
+	if st.count < g.maxFailures {
+		return false
+	}
+	// 锁定后重新计数
+	st.lockedUntil = now.Add(g.lockout)
+	st.count, st.first = 0, now
+	return true
+}
+
+// sweepLocked 清理窗口与锁定都已过期的记录，避免大量不同 IP 让内存一直增长
+func (g *LookupGuard) sweepLocked(now time.Time) {
+	if len(g.state) < 1024 {
+		return
+	}
+	for id, st := range g.state {
+		if now.Sub(st.first) > g.window && now.After(st.lockedUntil) {
+			delete(g.state, id)
+		}
+	}
+}
+
+// Succeed 验证通过后清空该客户端的失败记录
+func (g *LookupGuard) Succeed(key, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.state, lookupClientKey(key, ip))
+}
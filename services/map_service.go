@@ -0,0 +1,153 @@
+package services
+
+import "math"
+
+const earthRadiusKm = 6371.0088
+
+// GreatCircleKm 两点间的大圆距离（公里），haversine 公式
+func GreatCircleKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// GeoFeature GeoJSON Feature，坐标顺序为 [经度, 纬度]
+type GeoFeature struct {
+	Type       string         `json:"type"`
+	Geometry   GeoGeometry    `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type GeoGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// GeoCollection GeoJSON FeatureCollection；DistanceKm 为寄出地到首次查看地的大圆距离
+type GeoCollection struct {
+	Type       string       `json:"type"`
+	Features   []GeoFeature `json:"features"`
+	DistanceKm *float64     `json:"distance_km,omitempty"`
+}
+
+// 地图上的点类型
+const (
+	GeoKindOrigin     = "origin"
+	GeoKindCheckpoint = "checkpoint"
+	GeoKindViewer     = "viewer"
+	GeoKindRoute      = "route"
+)
+
+// MaxGeoViewers 地图上最多展示的查看者点数，超出时保留最早的（含首次查看）
+const MaxGeoViewers = 500
+
+func geoPoint(lat, lon float64, props map[string]any) GeoFeature {
+	return GeoFeature{
+		Type:       "Feature",
+		Geometry:   GeoGeometry{Type: "Point", Coordinates: [2]float64{lon, lat}},
+		Properties: props,
+	}
+}
+
+// HasCoords GeoIP 查不到位置时经纬度为 0
+func (i *IPInfo) HasCoords() bool {
+	return i != nil && (i.Latitude != 0 || i.Longitude != 0)
+}
+
+// OriginCoords 寄出地坐标，未填写时 ok 为 false
+func (d EntryData) OriginCoords() (lat, lon float64, ok bool) {
+	if d.OriginLat == nil || d.OriginLon == nil {
+		return 0, 0, false
+	}
+	return *d.OriginLat, *d.OriginLon, true
+}
+
+// EntryGeoJSON 组装条目地图数据：寄出地、中转点、查看者位置，以及按时间连成的路线。
+// viewers 需按时间正序，调用方负责过滤掉爬虫。
+func EntryGeoJSON(env *EntryEnvelope, viewers []HistoryRecord) GeoCollection {
+	out := GeoCollection{Type: "FeatureCollection", Features: []GeoFeature{}}
+	var route [][2]float64
+
+	originLat, originLon, hasOrigin := env.Data.OriginCoords()
+	if hasOrigin {
+		out.Features = append(out.Features, geoPoint(originLat, originLon, map[string]any{
+			"kind":  GeoKindOrigin,
+			"label": derefString(env.Data.OriginLocation),
+			"time":  env.CreatedAt.UnixMilli(),
+		}))
+		route = append(route, [2]float64{originLon, originLat})
+	}
+
+	// 时间线按追加顺序保存，这里按时间排一次
+	events := env.TimelineDesc()
+	for i := len(events) - 1; i >= 0; i-- {
+		ev := events[i]
+		if ev.Lat == nil || ev.Lon == nil {
+			continue
+		}
+		out.Features = append(out.Features, geoPoint(*ev.Lat, *ev.Lon, map[string]any{
+			"kind":   GeoKindCheckpoint,
+			"label":  ev.Location,
+			"status": ev.Status.Label(),
+			"by":     ev.By,
+			"time":   ev.Time.UnixMilli(),
+		}))
+		route = append(route, [2]float64{*ev.Lon, *ev.Lat})
+	}
+
+	first := true
+	for _, rec := range viewers {
+		if !rec.IPObj.HasCoords() {
+			continue
+		}
+		geo := rec.IPObj
+		out.Features = append(out.Features, geoPoint(geo.Latitude, geo.Longitude, map[string]any{
+			"kind":  GeoKindViewer,
+			"label": geoPlace(geo),
+			"first": first,
+			"time":  rec.Time.UnixMilli(),
+		}))
+		if first {
+			// 首次查看视为送达地，路线在此结束
+			route = append(route, [2]float64{geo.Longitude, geo.Latitude})
+			if hasOrigin {
+				d := math.Round(GreatCircleKm(originLat, originLon, geo.Latitude, geo.Longitude)*10) / 10
+				out.DistanceKm = &d
+			}
+			first = false
+		}
+	}
+
+	if len(route) >= 2 {
+		props := map[string]any{"kind": GeoKindRoute}
+		if out.DistanceKm != nil {
+			props["distance_km"] = *out.DistanceKm
+		}
+		out.Features = append(out.Features, GeoFeature{
+			Type:       "Feature",
+			Geometry:   GeoGeometry{Type: "LineString", Coordinates: route},
+			Properties: props,
+		})
+	}
+	return out
+}
+
+// geoPlace 城市、省份、国家中第一个非空的，用于地图提示
+func geoPlace(i *IPInfo) string {
+	for _, s := range []string{i.City, i.Region, i.Country} {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
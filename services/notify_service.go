@@ -0,0 +1,338 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailtrackerProject/helper"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NotifyEvent 可订阅的事件
+type NotifyEvent string
+
+const (
+	EventFirstView NotifyEvent = "first_view" // 第一次查询成功
+	EventLookup    NotifyEvent = "lookup"     // 之后的每次查询
+	EventReceipt   NotifyEvent = "receipt"    // 收件人确认收货
+	EventLockout   NotifyEvent = "lockout"    // 口令错误次数过多被锁定
+)
+
+var AllNotifyEvents = []NotifyEvent{EventFirstView, EventLookup, EventReceipt, EventLockout}
+
+func (e NotifyEvent) Label() string {
+	switch e {
+	case EventFirstView:
+		return "首次查看"
+	case EventLookup:
+		return "再次查看"
+	case EventReceipt:
+		return "确认收货"
+	case EventLockout:
+		return "查询被锁定"
+	default:
+		return string(e)
+	}
+}
+
+// Notification 一次待发送的通知
+type Notification struct {
+	Event  NotifyEvent       `json:"event"`
+	Key    string            `json:"key"`
+	Time   time.Time         `json:"time"`
+	Fields map[string]string `json:"fields,omitempty"` // 事件附带信息，如 ip、ua、message
+}
+
+// Subject 邮件标题
+func (n Notification) Subject() string {
+	return fmt.Sprintf("[邮件查询] %s %s", n.Key, n.Event.Label())
+}
+
+// Text 纯文本正文
+func (n Notification) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s：%s\n时间：%s\n", n.Event.Label(), n.Key, n.Time.Format("2006-01-02 15:04:05 MST"))
+	names := make([]string, 0, len(n.Fields))
+	for k := range n.Fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(&b, "%s：%s\n", k, n.Fields[k])
+	}
+	return b.String()
+}
+
+// Subscription 一个接收人对某些事件的订阅；Keys 为空表示订阅所有条目
+type Subscription struct {
+	ID        string        `json:"id"`
+	Channel   string        `json:"channel"` // email / webhook
+	Target    string        `json:"target"`  // 邮箱地址或 URL
+	Events    []NotifyEvent `json:"events"`
+	Keys      []string      `json:"keys,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+func (s Subscription) Matches(n Notification) bool {
+	return slices.Contains(s.Events, n.Event) && (len(s.Keys) == 0 || slices.Contains(s.Keys, n.Key))
+}
+
+// Notifier 通知渠道
+type Notifier interface {
+	Send(ctx context.Context, target string, n Notification) error
+}
+
+// SMTPConfig 邮件渠道配置；Username 为空时不做认证（本地调试用的 SMTP sink）
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+type EmailNotifier struct {
+	cfg SMTPConfig
+}
+
+func NewEmailNotifier(cfg SMTPConfig) *EmailNotifier {
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	return &EmailNotifier{cfg: cfg}
+}
+
+// Send 连接、读写都受 ctx 的期限约束，SMTP 服务器无响应时不会一直挂着
+func (e *EmailNotifier) Send(ctx context.Context, target string, n Notification) error {
+	if strings.ContainsAny(target, "\r\n") {
+		return errors.New("invalid email address")
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", target)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", n.Subject()))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Text(), "\n", "\r\n"))
+
+	addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port))
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	c, err := smtp.NewClient(conn, e.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	// 与 smtp.SendMail 相同：服务器支持时 STARTTLS，配置了用户名时认证
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: e.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if e.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.cfg.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(target); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// WebhookNotifier 把通知以 JSON POST 到订阅的 URL
+type WebhookNotifier struct {
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) Send(ctx context.Context, target string, n Notification) error {
+	body, _ := json.Marshal(n)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", target, resp.Status)
+	}
+	return nil
+}
+
+// NotificationService 保存订阅并把事件分发到对应渠道，发送在后台进行，不阻塞请求
+type NotificationService struct {
+	filePath string
+	channels map[string]Notifier
+
+	mu   sync.RWMutex
+	subs []Subscription
+}
+
+func NewNotificationService(filePath string, channels map[string]Notifier) *NotificationService {
+	return &NotificationService{filePath: filePath, channels: channels}
+}
+
+// Channels 已配置的渠道名称
+func (s *NotificationService) Channels() []string {
+	out := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *NotificationService) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := os.ReadFile(s.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, &s.subs)
+}
+
+// 原子落盘：写入临时文件后 Rename 覆盖
+func (s *NotificationService) flushLocked() error {
+	b, _ := json.MarshalIndent(s.subs, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0o755); err != nil {
+		return err
+	}
+	tmp := s.filePath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.filePath)
+}
+
+func (s *NotificationService) List() []Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.subs)
+}
+
+func (s *NotificationService) Subscribe(sub Subscription) (Subscription, error) {
+	if _, ok := s.channels[sub.Channel]; !ok {
+		return sub, fmt.Errorf("channel %q not configured", sub.Channel)
+	}
+	if sub.Target == "" || len(sub.Events) == 0 {
+		return sub, errors.New("target and events are required")
+	}
+	id, err := helper.RandKey(10)
+	if err != nil {
+		return sub, err
+	}
+	sub.ID, sub.CreatedAt = id, time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = append(s.subs, sub)
+	if err := s.flushLocked(); err != nil {
+		s.subs = s.subs[:len(s.subs)-1]
+		return sub, err
+	}
+	return sub, nil
+}
+
+func (s *NotificationService) Unsubscribe(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.subs, func(sub Subscription) bool { return sub.ID == id })
+	if i < 0 {
+		return errors.New("subscription not found")
+	}
+	old := slices.Clone(s.subs)
+	s.subs = slices.Delete(s.subs, i, i+1)
+	if err := s.flushLocked(); err != nil {
+		s.subs = old
+		return err
+	}
+	return nil
+}
+
+// Notify 异步发送给所有匹配的订阅；nil 安全
+func (s *NotificationService) Notify(n Notification) {
+	if s == nil {
+		return
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	s.mu.RLock()
+	var matched []Subscription
+	for _, sub := range s.subs {
+		if sub.Matches(n) {
+			matched = append(matched, sub)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, sub := range matched {
+		ch, ok := s.channels[sub.Channel]
+		if !ok {
+			continue
+		}
+		go func(sub Subscription) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := ch.Send(ctx, sub.Target, n); err != nil {
+				log.Printf("notify %s via %s to %s: %v", n.Event, sub.Channel, sub.Target, err)
+			}
+		}(sub)
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink 本地 SMTP 接收端，不支持 STARTTLS 与认证，收到的邮件原文写入 got
+func smtpSink(t *testing.T, got chan<- string) (string, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 sink ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250-sink")
+				reply("250 8BITMIME")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var msg strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					msg.WriteString(l)
+				}
+				got <- msg.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestEmailNotifierSend(t *testing.T) {
+	got := make(chan string, 1)
+	host, port := smtpSink(t, got)
+	e := NewEmailNotifier(SMTPConfig{Host: host, Port: port, From: "tracker@example.com"})
+	n := Notification{Event: EventReceipt, Key: "ABC123", Time: time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC),
+		Fields: map[string]string{"ip": "203.0.113.7"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Send(ctx, "owner@example.com", n); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(<-got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"From":         "tracker@example.com",
+		"To":           "owner@example.com",
+		"Date":         n.Time.Format(time.RFC1123Z),
+		"Content-Type": "text/plain; charset=UTF-8",
+	} {
+		if v := msg.Header.Get(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+	if subject != n.Subject() {
+		t.Errorf("Subject = %q, want %q", subject, n.Subject())
+	}
+	var body strings.Builder
+	if _, err := bufio.NewReader(msg.Body).WriteTo(&body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body.String(), "ip：203.0.113.7\r\n") {
+		t.Errorf("body = %q", body.String())
+	}
+
+	if err := e.Send(ctx, "owner@example.com\r\nBcc: x@example.com", n); err == nil {
+		t.Error("address with CRLF accepted")
+	}
+}
+
+// 服务器接受连接后不响应，Send 在 ctx 到期时返回
+func TestEmailNotifierTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(5 * time.Second)
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	e := NewEmailNotifier(SMTPConfig{Host: addr.IP.String(), Port: addr.Port, From: "tracker@example.com"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = e.Send(ctx, "owner@example.com", Notification{Event: EventLookup, Key: "ABC123"})
+	if err == nil {
+		t.Fatal("Send succeeded against a silent server")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("Send returned after %v", d)
+	}
+}
+
+// 落盘失败时订阅保持不变
+func TestUnsubscribeFlushFailure(t *testing.T) {
+	dir := t.TempDir()
+	s := NewNotificationService(filepath.Join(dir, "notifications.json"), map[string]Notifier{"webhook": &WebhookNotifier{}})
+	sub, err := s.Subscribe(Subscription{Channel: "webhook", Target: "https://example.com/hook", Events: []NotifyEvent{EventLookup}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 让目标路径的上级变成普通文件，写入必然失败
+	blocker := filepath.Join(dir, "blocked")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.filePath = filepath.Join(blocker, "notifications.json")
+	if err := s.Unsubscribe(sub.ID); err == nil {
+		t.Fatal("Unsubscribe succeeded without writing")
+	}
+	if list := s.List(); len(list) != 1 || list[0].ID != sub.ID {
+		t.Fatalf("subscriptions after failed unsubscribe = %+v", list)
+	}
+}
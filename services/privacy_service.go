@@ -0,0 +1,192 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// 访问记录中 IP 的保存方式
+const (
+	IPModeFull     = "full"     // 原样保存
+	IPModeTruncate = "truncate" // IPv4 保留 /24，IPv6 保留 /48
+	IPModeHash     = "hash"     // 加盐 HMAC，仍能区分同一访客但无法还原
+)
+
+// 超过保留期限的访问记录的处理方式
+const (
+	RetentionAnonymize = "anonymize" // 清除 IP、原始 UA 以及城市、坐标、ASN，保留时间、国家/省份与 UA 解析结果
+	RetentionDelete    = "delete"    // 删除整条记录
+)
+
+// hashedIPPrefix 散列后的 IP 以此开头，重复处理时不会再散列一次
+const hashedIPPrefix = "h:"
+
+// PrivacyPolicy 访问记录的隐私设置。IPMode 在写入时生效，
+// 后台任务把 IPMode 与保留期限应用到已有的全部记录
+type PrivacyPolicy struct {
+	IPMode    string
+	Salt      []byte        // IPModeHash 使用
+	Retention time.Duration // 0 表示永久保存
+	Action    string        // RetentionAnonymize 或 RetentionDelete
+}
+
+// SetPrivacy 设置访问记录的隐私策略
+func (s *EntriesService) SetPrivacy(p PrivacyPolicy) { s.privacy = p }
+
+// ApplyPrivacy 按写入时的规则处理一条记录，用于把同样处理过的记录交给通知与 Webhook
+func (s *EntriesService) ApplyPrivacy(rec *HistoryRecord) { s.privacy.scrub(rec) }
+
+// scrub 按 IPMode 处理 IP，重复调用结果不变。返回是否有改动。
+// 截断或散列时 GeoIP 快照是按完整 IP 查到的，同样只保留国家与省份
+func (p PrivacyPolicy) scrub(rec *HistoryRecord) bool {
+	geo := false
+	if p.IPMode == IPModeTruncate || p.IPMode == IPModeHash {
+		geo = anonymizeIPInfo(rec.IPObj)
+	}
+	if rec.IP == "" || strings.HasPrefix(rec.IP, hashedIPPrefix) {
+		return geo
+	}
+	ip := rec.IP
+	switch p.IPMode {
+	case IPModeTruncate:
+		ip = truncateIP(ip)
+	case IPModeHash:
+		mac := hmac.New(sha256.New, p.Salt)
+		mac.Write([]byte(ip))
+		ip = hashedIPPrefix + hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+	if ip == rec.IP {
+		return geo
+	}
+	rec.IP = ip
+	return true
+}
+
+// truncateIP IPv4 抹掉最后一段，IPv6 只保留前 48 位；无法解析时原样返回
+func truncateIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// enforce 对已有记录应用 IPMode 与保留期限，返回 (是否改动, 是否删除)
+func (p PrivacyPolicy) enforce(rec *HistoryRecord, cutoff time.Time) (bool, bool) {
+	if p.Retention > 0 && rec.Time.Before(cutoff) {
+		if p.Action == RetentionDelete {
+			return false, true
+		}
+		geo := anonymizeIPInfo(rec.IPObj)
+		if rec.IP == "" && rec.UA == "" {
+			return geo, false
+		}
+		if rec.UAObj == nil && rec.UA != "" {
+			rec.UAObj = ParseUAInfo(rec.UA) // 匿名化前保留解析结果，统计与爬虫识别仍可用
+		}
+		rec.IP, rec.UA = "", ""
+		return true, false
+	}
+	return p.scrub(rec), false
+}
+
+// anonymizeIPInfo 去掉 GeoIP 快照中足以定位到人的部分（城市、坐标、ASN 与运营商），只留国家、省份与时区。返回是否有改动
+func anonymizeIPInfo(g *IPInfo) bool {
+	if g == nil || (g.City == "" && g.Latitude == 0 && g.Longitude == 0 && g.ASN == 0 && g.ASOrg == "") {
+		return false
+	}
+	g.City, g.Latitude, g.Longitude, g.ASN, g.ASOrg = "", 0, 0, 0, ""
+	return true
+}
+
+// EnforcePrivacy 把隐私策略应用到所有条目的访问记录（含归档），返回改动与删除的条数
+func (s *EntriesService) EnforcePrivacy() (changed, removed int, err error) {
+	keys, err := s.ListKeys()
+	if err != nil {
+		return 0, 0, err
+	}
+	cutoff := time.Now().Add(-s.privacy.Retention)
+	for _, key := range keys {
+		c, r, err := s.rewriteHistory(key, func(rec *HistoryRecord) (bool, bool) {
+			return s.privacy.enforce(rec, cutoff)
+		})
+		changed, removed = changed+c, removed+r
+		if err != nil {
+			return changed, removed, err
+		}
+	}
+	return changed, removed, nil
+}
+
+// StartPrivacyJob 启动时执行一次，之后每隔 interval 执行一次 EnforcePrivacy
+func (s *EntriesService) StartPrivacyJob(interval time.Duration) {
+	run := func() {
+		c, r, err := s.EnforcePrivacy()
+		if err != nil {
+			log.Printf("privacy job failed: %v", err)
+		}
+		if c > 0 || r > 0 {
+			log.Printf("privacy job: %d records anonymized, %d deleted", c, r)
+		}
+	}
+	go func() {
+		run()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for range t.C {
+			run()
+		}
+	}()
+}
+
+// EraseVisitors 删除条目的全部访问记录（含归档），返回删除的条数
+func (s *EntriesService) EraseVisitors(key string) (int, error) {
+	_, n, err := s.rewriteHistory(key, func(*HistoryRecord) (bool, bool) { return false, true })
+	return n, err
+}
+
+// rewriteHistory 在记录锁内逐个文件重写
+func (s *EntriesService) rewriteHistory(key string, fn func(rec *HistoryRecord) (bool, bool)) (changed, removed int, err error) {
+	mu := s.historyLock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, p := range s.historySegments(key) {
+		c, r, err := rewriteHistoryFile(p, fn)
+		changed, removed = changed+c, removed+r
+		if err != nil {
+			return changed, removed, err
+		}
+	}
+	if removed > 0 {
+		s.index.setFirstView(key, s.firstView(key))
+	}
+	return changed, removed, nil
+}
+
+// LoadOrCreateSalt 读取散列 IP 用的盐，不存在时随机生成并保存，重启后散列结果保持一致
+func LoadOrCreateSalt(p string) ([]byte, error) {
+	b, err := os.ReadFile(p)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(b)))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	b = make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, os.WriteFile(p, []byte(hex.EncodeToString(b)), 0o600)
+}
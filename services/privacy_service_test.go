@@ -0,0 +1,174 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testGeo() *IPInfo {
+	return &IPInfo{CountryISO: "DE", Country: "Germany", Region: "Berlin", City: "Berlin", Latitude: 52.5, Longitude: 13.4,
+		Timezone: "Europe/Berlin", ASN: 3320, ASOrg: "Deutsche Telekom"}
+}
+
+// 只保留国家、省份与时区
+func coarseGeo(g *IPInfo) bool {
+	return g != nil && g.Country == "Germany" && g.Region == "Berlin" && g.Timezone == "Europe/Berlin" &&
+		g.City == "" && g.Latitude == 0 && g.Longitude == 0 && g.ASN == 0 && g.ASOrg == ""
+}
+
+func TestPrivacyScrub(t *testing.T) {
+	salt := []byte("salt")
+	for _, tc := range []struct {
+		mode, ip string
+		wantIP   string // 为 "h:" 时只检查前缀与长度
+		coarse   bool
+	}{
+		{IPModeFull, "203.0.113.7", "203.0.113.7", false},
+		{IPModeTruncate, "203.0.113.7", "203.0.113.0", true},
+		{IPModeTruncate, "2001:db8:1234:5678::1", "2001:db8:1234::", true},
+		{IPModeTruncate, "", "", true},
+		{IPModeHash, "203.0.113.7", "h:", true},
+	} {
+		p := PrivacyPolicy{IPMode: tc.mode, Salt: salt}
+		rec := HistoryRecord{IP: tc.ip, IPObj: testGeo()}
+		p.scrub(&rec)
+		if tc.wantIP == hashedIPPrefix {
+			if !strings.HasPrefix(rec.IP, hashedIPPrefix) || len(rec.IP) != len(hashedIPPrefix)+16 {
+				t.Errorf("%s %s: ip = %q", tc.mode, tc.ip, rec.IP)
+			}
+		} else if rec.IP != tc.wantIP {
+			t.Errorf("%s %s: ip = %q, want %q", tc.mode, tc.ip, rec.IP, tc.wantIP)
+		}
+		if coarseGeo(rec.IPObj) != tc.coarse {
+			t.Errorf("%s %s: geo = %+v", tc.mode, tc.ip, rec.IPObj)
+		}
+		// 重复处理结果不变
+		again := rec
+		geo := *rec.IPObj
+		again.IPObj = &geo
+		if p.scrub(&again) || again.IP != rec.IP {
+			t.Errorf("%s %s: scrub not idempotent: %q -> %q", tc.mode, tc.ip, rec.IP, again.IP)
+		}
+	}
+
+	// 散列结果只取决于盐
+	a, b := HistoryRecord{IP: "203.0.113.7"}, HistoryRecord{IP: "203.0.113.7"}
+	PrivacyPolicy{IPMode: IPModeHash, Salt: salt}.scrub(&a)
+	PrivacyPolicy{IPMode: IPModeHash, Salt: []byte("other")}.scrub(&b)
+	if a.IP == b.IP {
+		t.Error("hash does not depend on the salt")
+	}
+}
+
+func TestPrivacyEnforce(t *testing.T) {
+	now := time.Now()
+	cutoff := now.Add(-24 * time.Hour)
+	const ua = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"
+
+	p := PrivacyPolicy{IPMode: IPModeFull, Retention: 24 * time.Hour, Action: RetentionAnonymize}
+	old := HistoryRecord{Time: now.Add(-48 * time.Hour), IP: "203.0.113.7", UA: ua, IPObj: testGeo()}
+	if changed, drop := p.enforce(&old, cutoff); !changed || drop {
+		t.Fatalf("enforce old = %v, %v", changed, drop)
+	}
+	if old.IP != "" || old.UA != "" || old.UAObj == nil || old.UAObj.Name != "Chrome" || !coarseGeo(old.IPObj) {
+		t.Fatalf("anonymized = %+v, ua %+v, geo %+v", old, old.UAObj, old.IPObj)
+	}
+	if changed, _ := p.enforce(&old, cutoff); changed {
+		t.Fatal("anonymizing twice reported a change")
+	}
+
+	recent := HistoryRecord{Time: now, IP: "203.0.113.7", UA: ua, IPObj: testGeo()}
+	if changed, drop := p.enforce(&recent, cutoff); changed || drop || recent.IP == "" || recent.IPObj.City == "" {
+		t.Fatalf("recent record changed in full mode: %+v", recent)
+	}
+
+	p.Action = RetentionDelete
+	old = HistoryRecord{Time: now.Add(-48 * time.Hour), IP: "203.0.113.7"}
+	if _, drop := p.enforce(&old, cutoff); !drop {
+		t.Fatal("old record not dropped")
+	}
+}
+
+// 写入时截断 IP 并去掉细粒度的 GeoIP；后台任务跨归档处理旧记录；擦除删除全部记录
+func TestEntriesHistoryPrivacy(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 1)
+	key := kis[0].Key
+	if err := entries.SaveData(key, EntryData{}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	entries.SetHistoryRotation(300, 10)
+	entries.SetPrivacy(PrivacyPolicy{IPMode: IPModeTruncate, Retention: 24 * time.Hour, Action: RetentionAnonymize})
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		rec := HistoryRecord{Time: now.Add(time.Duration(i-8)*12*time.Hour - time.Hour), IP: "203.0.113.7", UA: "curl/8.0", IPObj: testGeo()}
+		if _, err := entries.RecorduaNewlinejson(key, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(entries.historyArchives(key)); n == 0 {
+		t.Fatal("history did not rotate")
+	}
+	records, err := entries.ReadUARecords(key)
+	if err != nil || len(records) != 8 {
+		t.Fatalf("ReadUARecords = %d, %v", len(records), err)
+	}
+	for _, rec := range records {
+		if rec.IP != "203.0.113.0" || !coarseGeo(rec.IPObj) {
+			t.Fatalf("stored record = %+v, geo %+v", rec, rec.IPObj)
+		}
+	}
+
+	// 早于 24 小时的 7 条（-97h … -25h）被匿名化
+	changed, removed, err := entries.EnforcePrivacy()
+	if err != nil || changed != 7 || removed != 0 {
+		t.Fatalf("EnforcePrivacy = %d, %d, %v", changed, removed, err)
+	}
+	records, _ = entries.ReadUARecords(key)
+	anon := 0
+	for _, rec := range records {
+		if rec.IP == "" && rec.UA == "" {
+			anon++
+		}
+	}
+	if anon != 7 {
+		t.Fatalf("%d anonymized records, want 7", anon)
+	}
+	if c, r, _ := entries.EnforcePrivacy(); c != 0 || r != 0 {
+		t.Fatalf("second EnforcePrivacy = %d, %d", c, r)
+	}
+
+	n, err := entries.EraseVisitors(key)
+	if err != nil || n != 8 {
+		t.Fatalf("EraseVisitors = %d, %v", n, err)
+	}
+	if entries.HasHistory(key) {
+		t.Fatal("history left after erase")
+	}
+}
+
+// 已匿名化的记录没有指纹，每条计为一次查看，不折叠也不算作同一访客
+func TestClassifyAnonymized(t *testing.T) {
+	f := NewVisitorFilter(nil, nil, time.Hour)
+	now := time.Now()
+	records := []HistoryRecord{ // 最新在前
+		{Time: now, IP: "203.0.113.7", UA: "curl/8.0"},
+		{Time: now.Add(-time.Minute), IP: "203.0.113.7", UA: "curl/8.0"},
+		{Time: now.Add(-2 * time.Minute)},
+		{Time: now.Add(-3 * time.Minute)},
+		{Time: now.Add(-4 * time.Minute)},
+	}
+	sum := f.Classify(records)
+	if sum.Views != 5 || sum.Unique != 1 || sum.Hidden != 1 {
+		t.Fatalf("summary = %+v", sum)
+	}
+	for _, rec := range records[2:] {
+		if rec.Visitor != "" || rec.Hidden != "" {
+			t.Fatalf("anonymized record classified as %q / %q", rec.Visitor, rec.Hidden)
+		}
+	}
+	if records[0].Hidden != HiddenRepeat {
+		t.Fatalf("repeat view not folded: %+v", records[0])
+	}
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Receipt 收件人确认收货时留下的回执
+type Receipt struct {
+	ArrivalDate string       `json:"arrivalDate"`       // 收到日期 yyyy-mm-dd
+	Message     string       `json:"message,omitempty"` // 给寄件人的留言
+	Photo       *ImageRecord `json:"photo,omitempty"`   // 到货照片
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+var ErrReceiptExists = errors.New("receipt already submitted")
+
+// receiptTarget 确认收货后的状态：未送达的直接标记送达，已送达的标记为已确认
+func receiptTarget(from EntryStatus) EntryStatus {
+	if from == StatusDelivered {
+		return StatusConfirmed
+	}
+	return StatusDelivered
+}
+
+// CanConfirmReceipt 当前状态下收件人能否提交回执（每个条目只能提交一次）
+func (e *EntryEnvelope) CanConfirmReceipt() bool {
+	from := e.CurrentStatus()
+	return e.Receipt == nil && CanTransition(from, receiptTarget(from), RoleRecipient)
+}
+
+// ImageNames 条目引用的全部图片，包括回执照片
+func (e *EntryEnvelope) ImageNames() []string {
+	out := e.Data.ImageNames()
+	if e.Receipt != nil && e.Receipt.Photo != nil {
+		out = append(out, EntryData{Images: ImageList{*e.Receipt.Photo}}.ImageNames()...)
+	}
+	return out
+}
+
+// ConfirmReceipt 保存收件人回执，并以收件人身份推进状态
+func (s *EntriesService) ConfirmReceipt(key string, r Receipt) (*EntryEnvelope, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	env, err := s.LoadData(key)
+	if err != nil {
+		return nil, err
+	}
+	if env.Receipt != nil {
+		return nil, ErrReceiptExists
+	}
+	from := env.CurrentStatus()
+	to := receiptTarget(from)
+	if !CanTransition(from, to, RoleRecipient) {
+		return nil, fmt.Errorf("%w: %s -> %s by %s", ErrTransitionNotAllowed, from, to, RoleRecipient)
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	env.Receipt = &r
+	env.Status = to
+	env.Timeline = append(env.Timeline, TrackingEvent{Time: r.CreatedAt, Status: to, Actor: RoleRecipient, Note: "收件人确认收货"})
+	if err := s.writeEnvelopeLocked(key, env); err != nil {
+		return nil, err
+	}
+	if r.Photo != nil {
+		s.blobs.Retain(EntryData{Images: ImageList{*r.Photo}}.ImageNames())
+	}
+	return env, nil
+}
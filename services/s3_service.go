@@ -0,0 +1,438 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config S3 兼容存储（AWS S3 / MinIO / R2 等）的连接参数
+type S3Config struct {
+	Endpoint  string // 例如 https://s3.amazonaws.com 或 http://127.0.0.1:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // 对象名前缀，可为空
+	PathStyle bool   // MinIO 等通常需要 path-style
+	// PublicEndpoint 生成直链时使用的地址（内外网地址不同时设置），为空则同 Endpoint
+	PublicEndpoint string
+}
+
+// S3Storage 基于 SigV4 签名的最小 S3 客户端，只实现 blob 存储需要的几个操作
+type S3Storage struct {
+	cfg S3Config
+	cli *http.Client
+}
+
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("s3: endpoint, bucket and credentials are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	cfg.PublicEndpoint = strings.TrimRight(cfg.PublicEndpoint, "/")
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &S3Storage{cfg: cfg, cli: &http.Client{Timeout: 60 * time.Second}}, nil
+}
+
+func (s *S3Storage) key(name string) string {
+	if s.cfg.Prefix == "" {
+		return name
+	}
+	return s.cfg.Prefix + "/" + name
+}
+
+// objectURL 拼出对象地址；key 为空时返回 bucket 地址
+func (s *S3Storage) objectURL(endpoint, key string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.PathStyle {
+		u.Path = "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return u, nil
+}
+
+func (s *S3Storage) do(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u, err := s.objectURL(s.cfg.Endpoint, key)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	s.sign(req, body, time.Now().UTC())
+	return s.cli.Do(req)
+}
+
+func (s *S3Storage) Put(name string, data []byte, contentType string) error {
+	h := http.Header{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(http.MethodPut, s.key(name), nil, data, h)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s3Error(resp)
+}
+
+// s3PartSize 分段上传每段的大小；不超过一段的对象直接 PUT
+var s3PartSize int64 = 16 << 20
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// PutStream 大对象用分段上传，内存中最多只有一段
+func (s *S3Storage) PutStream(name string, r io.Reader, size int64, contentType string) error {
+	if size <= s3PartSize {
+		b, err := io.ReadAll(io.LimitReader(r, size))
+		if err != nil {
+			return err
+		}
+		if int64(len(b)) != size {
+			return fmt.Errorf("put %s: read %d of %d bytes", name, len(b), size)
+		}
+		return s.Put(name, b, contentType)
+	}
+
+	key := s.key(name)
+	h := http.Header{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, h)
+	if err != nil {
+		return err
+	}
+	var init initiateMultipartUploadResult
+	err = s3Error(resp)
+	if err == nil {
+		err = xml.NewDecoder(resp.Body).Decode(&init)
+	}
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if err := s.uploadParts(key, init.UploadID, r, size); err != nil {
+		// 放弃未完成的上传，否则已传的分段会一直占用空间
+		if resp, aerr := s.do(http.MethodDelete, key, url.Values{"uploadId": {init.UploadID}}, nil, nil); aerr == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3Storage) uploadParts(key, uploadID string, r io.Reader, size int64) error {
+	var done completeMultipartUpload
+	buf := make([]byte, s3PartSize)
+	var sent int64
+	for part := 1; sent < size; part++ {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size-sent)])
+		if err != nil {
+			return fmt.Errorf("read part %d: %w", part, err)
+		}
+		q := url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {uploadID}}
+		resp, err := s.do(http.MethodPut, key, q, buf[:n], nil)
+		if err != nil {
+			return err
+		}
+		err = s3Error(resp)
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("part %d: %w", part, err)
+		}
+		done.Parts = append(done.Parts, completedPart{PartNumber: part, ETag: resp.Header.Get("ETag")})
+		sent += int64(n)
+	}
+
+	body, _ := xml.Marshal(done)
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return err
+	}
+	// 完成请求可能返回 200 但正文是错误
+	var out struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&out); err == nil && out.XMLName.Local == "Error" {
+		return fmt.Errorf("s3: complete upload: %s: %s", out.Code, out.Message)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(name string) (io.ReadCloser, ObjectInfo, error) {
+	resp, err := s.do(http.MethodGet, s.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	if err := s3Error(resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return resp.Body, objectInfoFromHeader(name, resp), nil
+}
+
+func (s *S3Storage) Stat(name string) (ObjectInfo, error) {
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil {
+		return ObjectInfo{}, err
+	}
+	return objectInfoFromHeader(name, resp), nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	resp, err := s.do(http.MethodDelete, s.key(name), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := s3Error(resp); err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List 使用 ListObjectsV2 分页遍历
+func (s *S3Storage) List(prefix string, fn func(ObjectInfo) error) error {
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", s.key(prefix))
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", q, nil, nil)
+		if err != nil {
+			return err
+		}
+		var out listBucketResult
+		err = s3Error(resp)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&out)
+		}
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, o := range out.Contents {
+			name := strings.TrimPrefix(o.Key, s.cfg.Prefix+"/")
+			if err := fn(ObjectInfo{Name: name, Size: o.Size, ModTime: o.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !out.IsTruncated || out.NextContinuationToken == "" {
+			return nil
+		}
+		token = out.NextContinuationToken
+	}
+}
+
+// PresignGet 生成 SigV4 查询串签名的限时下载地址
+func (s *S3Storage) PresignGet(name string, expires time.Duration) (string, error) {
+	return s.presignGet(name, expires, time.Now().UTC())
+}
+
+func (s *S3Storage) presignGet(name string, expires time.Duration, now time.Time) (string, error) {
+	endpoint := s.cfg.PublicEndpoint
+	if endpoint == "" {
+		endpoint = s.cfg.Endpoint
+	}
+	u, err := s.objectURL(endpoint, s.key(name))
+	if err != nil {
+		return "", err
+	}
+	amzDate := now.Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		uriEncode(u.Path, false),
+		canonicalQuery(q),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	sig := s.signature(now, amzDate, scope, canonical)
+	u.RawQuery = canonicalQuery(q) + "&X-Amz-Signature=" + sig
+	return u.String(), nil
+}
+
+// ====== SigV4 ======
+
+func (s *S3Storage) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+func (s *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// 签名 host 以及所有 x-amz-* / content-type 头
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var ch strings.Builder
+	for _, k := range names {
+		ch.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		ch.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := s.scope(now)
+	sig := s.signature(now, amzDate, scope, canonical)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signed, sig))
+}
+
+func (s *S3Storage) signature(now time.Time, amzDate, scope, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	k := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	k = hmacSHA256(k, s.cfg.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(k, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// canonicalQuery 按 key 排序并按 SigV4 规则编码
+func canonicalQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode 除 unreserved 字符外全部百分号编码；encodeSlash=false 时保留路径分隔符
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func objectInfoFromHeader(name string, resp *http.Response) ObjectInfo {
+	info := ObjectInfo{Name: name, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info
+}
+
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrObjectNotFound
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
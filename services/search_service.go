@@ -0,0 +1,259 @@
+package services
+
+import (
+	"cmp"
+	"log"
+	"mailtrackerProject/helper"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EntryHit 搜索结果中的一个条目
+type EntryHit struct {
+	Key          string
+	Recipient    string
+	Remarks      string
+	Origin       string
+	PostedAt     time.Time
+	CreatedAt    time.Time
+	Status       EntryStatus
+	FirstView    *time.Time // 第一条访问记录的时间，没有访问记录时为 nil
+	Batch        string     // 以下取自 key 记录，查询时填入
+	BatchComment string
+	Lifecycle    Lifecycle
+}
+
+// indexedEntry 索引中的条目，另存一份规整后的文本用于匹配
+type indexedEntry struct {
+	hit       EntryHit
+	recipient string // helper.NormalizeString 后的收件人
+	remarks   string // 小写
+	origin    string // 小写，含地名库匹配到的城市
+}
+
+// entryIndex 条目的内存索引：启动时整体建立，之后随条目写入、访问记录与清除增量更新
+type entryIndex struct {
+	mu      sync.RWMutex
+	entries map[string]*indexedEntry
+}
+
+func newEntryIndex() *entryIndex {
+	return &entryIndex{entries: map[string]*indexedEntry{}}
+}
+
+func newIndexedEntry(key string, env *EntryEnvelope, firstView *time.Time) *indexedEntry {
+	d := env.Data
+	hit := EntryHit{
+		Key:       key,
+		Recipient: derefString(d.RecipientName),
+		Remarks:   derefString(d.Remarks),
+		Origin:    derefString(d.OriginLocation),
+		PostedAt:  env.PostedAt(),
+		CreatedAt: env.CreatedAt,
+		Status:    env.CurrentStatus(),
+		FirstView: firstView,
+	}
+	origin := hit.Origin
+	if d.OriginPlace != nil {
+		origin += " " + d.OriginPlace.Label()
+	}
+	return &indexedEntry{
+		hit:       hit,
+		recipient: helper.NormalizeString(hit.Recipient),
+		remarks:   strings.ToLower(hit.Remarks),
+		origin:    strings.ToLower(origin),
+	}
+}
+
+// put 写入或替换条目，保留已知的首次访问时间
+func (x *entryIndex) put(key string, env *EntryEnvelope) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var firstView *time.Time
+	if old, ok := x.entries[key]; ok {
+		firstView = old.hit.FirstView
+	}
+	x.entries[key] = newIndexedEntry(key, env, firstView)
+}
+
+// viewed 记下首次访问时间，已有时不变
+func (x *entryIndex) viewed(key string, t time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.entries[key]; ok && e.hit.FirstView == nil {
+		e.hit.FirstView = &t
+	}
+}
+
+// setFirstView 访问记录被改写或清除后重新设置首次访问时间
+func (x *entryIndex) setFirstView(key string, t *time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.entries[key]; ok {
+		e.hit.FirstView = t
+	}
+}
+
+func (x *entryIndex) remove(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.entries, key)
+}
+
+// firstView 第一条访问记录的时间
+func (s *EntriesService) firstView(key string) *time.Time {
+	var first *time.Time
+	_ = s.ScanHistory(key, func(rec HistoryRecord) bool {
+		t := rec.Time
+		first = &t
+		return false
+	})
+	return first
+}
+
+// BuildIndex 读取全部条目建立搜索索引，返回索引与跳过的条目数。启动时调用一次；
+// 读不出的条目记日志后跳过，之后被重新保存时会加入索引
+func (s *EntriesService) BuildIndex() (indexed, skipped int, err error) {
+	keys, err := s.ListKeys()
+	if err != nil {
+		return 0, 0, err
+	}
+	entries := make(map[string]*indexedEntry, len(keys))
+	for _, key := range keys {
+		env, err := s.LoadData(key)
+		if err != nil {
+			log.Printf("skip indexing %s: %v", key, err)
+			skipped++
+			continue
+		}
+		entries[key] = newIndexedEntry(key, env, s.firstView(key))
+	}
+	s.index.mu.Lock()
+	s.index.entries = entries
+	s.index.mu.Unlock()
+	return len(entries), skipped, nil
+}
+
+// 搜索结果的排序字段
+const (
+	SortPosted    = "posted"
+	SortCreated   = "created"
+	SortViewed    = "viewed"
+	SortRecipient = "recipient"
+	SortStatus    = "status"
+	SortKey       = "key"
+)
+
+// SearchSorts 可选的排序字段
+var SearchSorts = []string{SortPosted, SortCreated, SortViewed, SortRecipient, SortStatus, SortKey}
+
+// EntrySearch 条目搜索条件，零值表示不限
+type EntrySearch struct {
+	Key       string // 包含匹配，不区分大小写
+	Recipient string // 按 helper.NormalizeString 规整后包含匹配
+	Remarks   string // 包含匹配，不区分大小写
+	Origin    string // 在寄出地原文与匹配到的城市中包含匹配
+	From      time.Time
+	To        time.Time // 寄出日期，含当天
+	Status    EntryStatus
+	Batch     string
+	Viewed    *bool     // 是否有访问记录
+	Lifecycle Lifecycle // 空表示未删除的全部条目；回收站中的条目不参与搜索
+	Sort      string
+	Desc      bool
+}
+
+func (q *EntrySearch) match(e *indexedEntry) bool {
+	h := &e.hit
+	switch {
+	case q.Key != "" && !strings.Contains(strings.ToUpper(h.Key), strings.ToUpper(q.Key)):
+		return false
+	case q.Recipient != "" && !strings.Contains(e.recipient, helper.NormalizeString(q.Recipient)):
+		return false
+	case q.Remarks != "" && !strings.Contains(e.remarks, strings.ToLower(q.Remarks)):
+		return false
+	case q.Origin != "" && !strings.Contains(e.origin, strings.ToLower(q.Origin)):
+		return false
+	case !q.From.IsZero() && h.PostedAt.Before(q.From):
+		return false
+	case !q.To.IsZero() && !h.PostedAt.Before(q.To.AddDate(0, 0, 1)):
+		return false
+	case q.Status != "" && h.Status != q.Status:
+		return false
+	case q.Viewed != nil && (h.FirstView != nil) != *q.Viewed:
+		return false
+	}
+	return true
+}
+
+func (q *EntrySearch) compare(a, b *EntryHit) int {
+	var c int
+	switch q.Sort {
+	case SortCreated:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	case SortViewed:
+		// 没有访问记录的排在最前（倒序时最后）
+		switch {
+		case a.FirstView == nil && b.FirstView == nil:
+		case a.FirstView == nil:
+			c = -1
+		case b.FirstView == nil:
+			c = 1
+		default:
+			c = a.FirstView.Compare(*b.FirstView)
+		}
+	case SortRecipient:
+		c = strings.Compare(helper.NormalizeString(a.Recipient), helper.NormalizeString(b.Recipient))
+	case SortStatus:
+		c = cmp.Compare(slices.Index(AllStatuses, a.Status), slices.Index(AllStatuses, b.Status))
+	case SortKey:
+	default:
+		c = a.PostedAt.Compare(b.PostedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Key, b.Key)
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+// Search 在索引中查找条目，排序后返回 [offset, offset+limit) 的结果与总数。
+// 批次与生命周期取自当前的 key 记录；key 已删除或不存在的条目不返回
+func (s *EntriesService) Search(q EntrySearch, offset, limit int) ([]EntryHit, int) {
+	s.index.mu.RLock()
+	var hits []EntryHit
+	for key, e := range s.index.entries {
+		if !q.match(e) {
+			continue
+		}
+		ki, ok := s.keys.Get(key)
+		if !ok {
+			continue
+		}
+		lc := ki.Lifecycle()
+		if lc == LifecycleDeleted || (q.Lifecycle != "" && lc != q.Lifecycle) {
+			continue
+		}
+		if q.Batch != "" && ki.BatchID() != q.Batch {
+			continue
+		}
+		h := e.hit
+		h.Batch, h.BatchComment, h.Lifecycle = ki.BatchID(), ki.Comment, lc
+		hits = append(hits, h)
+	}
+	s.index.mu.RUnlock()
+
+	slices.SortFunc(hits, func(a, b EntryHit) int { return q.compare(&a, &b) })
+	total := len(hits)
+	offset = min(max(offset, 0), total)
+	return hits[offset:min(offset+limit, total)], total
+}
+
+// Batches 未删除 key 的批次，从新到旧，供按批次筛选
+func (s *EntriesService) Batches() []BatchStats {
+	return batchStats(s.keys.List(), nil, s.HasData)
+}
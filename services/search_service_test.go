@@ -0,0 +1,129 @@
+package services
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// indexed 索引中的条目，不经过 key 记录的过滤
+func indexed(s *EntriesService, key string) (EntryHit, bool) {
+	s.index.mu.RLock()
+	defer s.index.mu.RUnlock()
+	e, ok := s.index.entries[key]
+	if !ok {
+		return EntryHit{}, false
+	}
+	return e.hit, true
+}
+
+func wantFirstView(t *testing.T, s *EntriesService, key string, want *time.Time) {
+	t.Helper()
+	h, ok := indexed(s, key)
+	if !ok {
+		t.Fatalf("%s not indexed", key)
+	}
+	switch {
+	case want == nil && h.FirstView != nil:
+		t.Fatalf("first view = %v, want none", h.FirstView)
+	case want != nil && (h.FirstView == nil || !h.FirstView.Equal(*want)):
+		t.Fatalf("first view = %v, want %v", h.FirstView, want)
+	}
+}
+
+func searchKeys(s *EntriesService, q EntrySearch) []string {
+	hits, _ := s.Search(q, 0, 100)
+	out := make([]string, 0, len(hits))
+	for _, h := range hits {
+		out = append(out, h.Key)
+	}
+	return out
+}
+
+// 索引随保存、访问记录、改写与彻底删除增量更新，结果与重新建立的索引一致
+func TestEntryIndexUpdates(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 1)
+	key := kis[0].Key
+	name, remarks := "张三", "Birthday card"
+	if err := entries.SaveData(key, EntryData{RecipientName: &name, Remarks: &remarks}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchKeys(entries, EntrySearch{Recipient: "张三", Remarks: "birthday"}); len(got) != 1 {
+		t.Fatalf("search after save = %v", got)
+	}
+	wantFirstView(t, entries, key, nil)
+
+	// 访问记录：第一条设置首次访问时间，之后的不变
+	now := time.Now().Truncate(time.Second)
+	first := now.Add(-48 * time.Hour)
+	for _, ts := range []time.Time{first, now.Add(-time.Hour)} {
+		if _, err := entries.RecorduaNewlinejson(key, HistoryRecord{Time: ts, IP: "203.0.113.7"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantFirstView(t, entries, key, &first)
+	viewed := true
+	if got := searchKeys(entries, EntrySearch{Viewed: &viewed}); len(got) != 1 {
+		t.Fatalf("viewed search = %v", got)
+	}
+
+	// 重新保存替换文本，保留首次访问时间
+	remarks = "Postcard"
+	if err := entries.SaveData(key, EntryData{RecipientName: &name, Remarks: &remarks}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchKeys(entries, EntrySearch{Remarks: "birthday"}); len(got) != 0 {
+		t.Fatalf("stale remarks still match: %v", got)
+	}
+	if got := searchKeys(entries, EntrySearch{Remarks: "postcard"}); len(got) != 1 {
+		t.Fatalf("new remarks do not match: %v", got)
+	}
+	wantFirstView(t, entries, key, &first)
+
+	// 删掉最早的记录后首次访问时间后移，全部擦除后清空
+	entries.SetPrivacy(PrivacyPolicy{IPMode: IPModeFull, Retention: 24 * time.Hour, Action: RetentionDelete})
+	if _, removed, err := entries.EnforcePrivacy(); err != nil || removed != 1 {
+		t.Fatalf("EnforcePrivacy removed %d, %v", removed, err)
+	}
+	second := now.Add(-time.Hour)
+	wantFirstView(t, entries, key, &second)
+	if _, err := entries.EraseVisitors(key); err != nil {
+		t.Fatal(err)
+	}
+	wantFirstView(t, entries, key, nil)
+	if got := searchKeys(entries, EntrySearch{Viewed: &viewed}); len(got) != 0 {
+		t.Fatalf("viewed search after erase = %v", got)
+	}
+
+	third := now
+	if _, err := entries.RecorduaNewlinejson(key, HistoryRecord{Time: third, IP: "203.0.113.7"}); err != nil {
+		t.Fatal(err)
+	}
+	wantFirstView(t, entries, key, &third)
+
+	// 删除 key 失败时条目移回原处，索引恢复为移动前的内容
+	if err := entries.Purge(key, func() error { return io.ErrUnexpectedEOF }); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Purge error = %v", err)
+	}
+	if h, ok := indexed(entries, key); !ok || h.Remarks != "Postcard" {
+		t.Fatalf("index after failed purge = %+v, %v", h, ok)
+	}
+	wantFirstView(t, entries, key, &third)
+
+	// 与重新建立的索引一致
+	before, _ := indexed(entries, key)
+	if n, skipped, err := entries.BuildIndex(); err != nil || n != 1 || skipped != 0 {
+		t.Fatalf("BuildIndex = %d, %d, %v", n, skipped, err)
+	}
+	after, _ := indexed(entries, key)
+	if before.Recipient != after.Recipient || before.Remarks != after.Remarks || !before.FirstView.Equal(*after.FirstView) {
+		t.Fatalf("incremental %+v, rebuilt %+v", before, after)
+	}
+
+	if err := entries.Purge(key, func() error { _, err := entries.keys.Revoke(key); return err }); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := indexed(entries, key); ok {
+		t.Fatal("purged entry still indexed")
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// EntryStatus 邮件的投递状态
+type EntryStatus string
+
+const (
+	StatusDraft     EntryStatus = "draft"      // 草稿，尚未寄出
+	StatusPosted    EntryStatus = "posted"     // 已寄出
+	StatusInTransit EntryStatus = "in_transit" // 运输中
+	StatusDelivered EntryStatus = "delivered"  // 已送达
+	StatusConfirmed EntryStatus = "confirmed"  // 收件人已确认
+	StatusLost      EntryStatus = "lost"       // 丢失
+	StatusReturned  EntryStatus = "returned"   // 退回
+)
+
+// AllStatuses 按生命周期顺序排列的全部状态
+var AllStatuses = []EntryStatus{
+	StatusDraft, StatusPosted, StatusInTransit, StatusDelivered, StatusConfirmed, StatusLost, StatusReturned,
+}
+
+func (st EntryStatus) Label() string {
+	switch st {
+	case StatusDraft:
+		return "草稿"
+	case StatusPosted:
+		return "已寄出"
+	case StatusInTransit:
+		return "运输中"
+	case StatusDelivered:
+		return "已送达"
+	case StatusConfirmed:
+		return "已确认收货"
+	case StatusLost:
+		return "丢失"
+	case StatusReturned:
+		return "已退回"
+	default:
+		return string(st)
+	}
+}
+
+// Final 是否为终态
+func (st EntryStatus) Final() bool {
+	return st == StatusConfirmed || st == StatusReturned
+}
+
+func ParseStatus(s string) (EntryStatus, bool) {
+	st := EntryStatus(s)
+	return st, slices.Contains(AllStatuses, st)
+}
+
+// Role 触发状态变化的角色
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"      // 寄件人/管理员
+	RoleRecipient  Role = "recipient"  // 收件人
+	RoleCheckpoint Role = "checkpoint" // 中转经手人
+	RoleCarrier    Role = "carrier"    // 承运商（物流接口同步）
+)
+
+func (r Role) Label() string {
+	switch r {
+	case RoleAdmin:
+		return "寄件人"
+	case RoleRecipient:
+		return "收件人"
+	case RoleCheckpoint:
+		return "中转"
+	case RoleCarrier:
+		return "承运商"
+	default:
+		return string(r)
+	}
+}
+
+// transitions 状态机：from -> to -> 允许执行该转换的角色
+var transitions = map[EntryStatus]map[EntryStatus][]Role{
+	StatusDraft: {
+		StatusPosted: {RoleAdmin},
+	},
+	StatusPosted: {
+		StatusInTransit: {RoleAdmin, RoleCheckpoint, RoleCarrier},
+		StatusDelivered: {RoleAdmin, RoleRecipient, RoleCarrier},
+		StatusLost:      {RoleAdmin, RoleCarrier},
+		StatusReturned:  {RoleAdmin, RoleCarrier},
+	},
+	StatusInTransit: {
+		// 经过多个中转点时重复记录运输中事件
+		StatusInTransit: {RoleAdmin, RoleCheckpoint, RoleCarrier},
+		StatusDelivered: {RoleAdmin, RoleRecipient, RoleCarrier},
+		StatusLost:      {RoleAdmin, RoleCarrier},
+		StatusReturned:  {RoleAdmin, RoleCarrier},
+	},
+	StatusDelivered: {
+		StatusConfirmed: {RoleAdmin, RoleRecipient},
+		StatusReturned:  {RoleAdmin, RoleCarrier},
+	},
+	StatusLost: {
+		// 找回
+		StatusInTransit: {RoleAdmin, RoleCarrier},
+		StatusDelivered: {RoleAdmin, RoleRecipient, RoleCarrier},
+		StatusReturned:  {RoleAdmin, RoleCarrier},
+	},
+}
+
+var ErrTransitionNotAllowed = errors.New("status transition not allowed")
+
+// CanTransition 判断 role 能否把状态从 from 改为 to
+func CanTransition(from, to EntryStatus, role Role) bool {
+	return slices.Contains(transitions[from][to], role)
+}
+
+// NextStatuses role 在 from 状态下可以转到的状态（按生命周期顺序）
+func NextStatuses(from EntryStatus, role Role) []EntryStatus {
+	var out []EntryStatus
+	for _, to := range AllStatuses {
+		if CanTransition(from, to, role) {
+			out = append(out, to)
+		}
+	}
+	return out
+}
+
+// TrackingEvent 时间线上的一个事件
+type TrackingEvent struct {
+	Time     time.Time   `json:"time"`
+	Status   EntryStatus `json:"status"` // 事件发生后的状态
+	Actor    Role        `json:"actor"`
+	By       string      `json:"by,omitempty"` // 具体经手人，如中转凭证上的名称
+	Note     string      `json:"note,omitempty"`
+	Location string      `json:"location,omitempty"`
+	Lat      *float64    `json:"lat,omitempty"`
+	Lon      *float64    `json:"lon,omitempty"`
+	ExtID    string      `json:"extId,omitempty"` // 承运商事件 ID，用于去重
+}
+
+// CurrentStatus 旧数据没有状态，视为已寄出
+func (e *EntryEnvelope) CurrentStatus() EntryStatus {
+	if e.Status == "" {
+		return StatusPosted
+	}
+	return e.Status
+}
+
+// TimelineDesc 按时间倒序（最新在前）返回时间线
+func (e *EntryEnvelope) TimelineDesc() []TrackingEvent {
+	out := slices.Clone(e.Timeline)
+	slices.SortStableFunc(out, func(a, b TrackingEvent) int { return b.Time.Compare(a.Time) })
+	return out
+}
+
+// Transition 追加事件并修改状态；不允许的转换返回 ErrTransitionNotAllowed
+func (s *EntriesService) Transition(key string, ev TrackingEvent) (*EntryEnvelope, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	env, err := s.LoadData(key)
+	if err != nil {
+		return nil, err
+	}
+	from := env.CurrentStatus()
+	if !CanTransition(from, ev.Status, ev.Actor) {
+		return nil, fmt.Errorf("%w: %s -> %s by %s", ErrTransitionNotAllowed, from, ev.Status, ev.Actor)
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	env.Status = ev.Status
+	env.Timeline = append(env.Timeline, ev)
+	if err := s.writeEnvelopeLocked(key, env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Status 返回条目当前状态，条目不存在时返回空字符串
+func (s *EntriesService) Status(key string) EntryStatus {
+	env, err := s.LoadData(key)
+	if err != nil {
+		return ""
+	}
+	return env.CurrentStatus()
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ObjectInfo 对象元数据
+type ObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ObjectStorage blob 的存储后端：本地磁盘或 S3 兼容的对象存储。
+// name 一律使用 "/" 分隔的相对路径，例如 blobs/ab/abcdef...
+type ObjectStorage interface {
+	Put(name string, data []byte, contentType string) error
+	// PutStream 写入 size 字节的大对象，不把内容整体读进内存
+	PutStream(name string, r io.Reader, size int64, contentType string) error
+	// Get 返回的 ReadCloser 若同时实现 io.Seeker（本地文件），可直接用于 http.ServeContent
+	Get(name string) (io.ReadCloser, ObjectInfo, error)
+	Stat(name string) (ObjectInfo, error)
+	Delete(name string) error
+	// List 遍历 prefix 下的所有对象
+	List(prefix string, fn func(ObjectInfo) error) error
+	// PresignGet 生成限时直链；不支持时返回空字符串
+	PresignGet(name string, expires time.Duration) (string, error)
+}
+
+// ErrObjectNotFound 对象不存在
+var ErrObjectNotFound = errors.New("object not found")
+
+// LocalStorage 以 root 目录为根的本地磁盘存储
+type LocalStorage struct{ root string }
+
+func NewLocalStorage(root string) *LocalStorage { return &LocalStorage{root: root} }
+
+func (s *LocalStorage) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+// Put 原子写入：先写临时文件再 Rename
+func (s *LocalStorage) Put(name string, data []byte, _ string) error {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// PutStream 边读边写到临时文件，完成后 Rename
+func (s *LocalStorage) PutStream(name string, r io.Reader, size int64, _ string) error {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != size {
+		err = fmt.Errorf("put %s: wrote %d of %d bytes", name, n, size)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// PutFile 把本地文件移动到 name；不在同一分区时复制后删除源文件
+func (s *LocalStorage) PutFile(name, src string) error {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, p); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := p + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func (s *LocalStorage) Get(name string) (io.ReadCloser, ObjectInfo, error) {
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ObjectInfo{}, ErrObjectNotFound
+		}
+		return nil, ObjectInfo{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return f, ObjectInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *LocalStorage) Stat(name string) (ObjectInfo, error) {
+	fi, err := os.Stat(s.path(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *LocalStorage) Delete(name string) error {
+	err := os.Remove(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStorage) List(prefix string, fn func(ObjectInfo) error) error {
+	dir := s.path(prefix)
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		return fn(ObjectInfo{Name: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// PresignGet 本地存储没有直链
+func (s *LocalStorage) PresignGet(string, time.Duration) (string, error) { return "", nil }
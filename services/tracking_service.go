@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// CarrierEvent 承运商返回的一条物流事件
+type CarrierEvent struct {
+	ID          string      `json:"id,omitempty"` // 承运商的事件 ID，没有时按时间与描述生成
+	Time        time.Time   `json:"time"`
+	Status      EntryStatus `json:"status,omitempty"` // 可映射到本系统的状态，纯信息事件留空
+	Description string      `json:"description"`
+	Location    string      `json:"location,omitempty"`
+}
+
+func (e CarrierEvent) key() string {
+	if e.ID != "" {
+		return e.ID
+	}
+	sum := sha1.Sum([]byte(e.Time.UTC().Format(time.RFC3339) + "|" + e.Description))
+	return hex.EncodeToString(sum[:8])
+}
+
+// TrackingProvider 物流查询接口，按承运商代码区分
+type TrackingProvider interface {
+	Supports(carrier string) bool
+	Track(ctx context.Context, carrier, number string) ([]CarrierEvent, error)
+}
+
+// FileTrackingProvider 从本地目录读取 <dir>/<carrier>/<number>.json，用于测试和手工录入
+type FileTrackingProvider struct {
+	Dir string
+}
+
+func (p FileTrackingProvider) Supports(string) bool { return true }
+
+func (p FileTrackingProvider) Track(_ context.Context, carrier, number string) ([]CarrierEvent, error) {
+	b, err := os.ReadFile(filepath.Join(p.Dir, filepath.Base(carrier), filepath.Base(number)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var events []CarrierEvent
+	if err := json.Unmarshal(b, &events); err != nil {
+		return nil, fmt.Errorf("parse %s/%s: %w", carrier, number, err)
+	}
+	return events, nil
+}
+
+// HTTPTrackingProvider 调用聚合查询接口：GET URL?carrier=&number=，返回 {"events": [...]}。
+// 接口格式与 FileTrackingProvider 的文件一致，可以用任意静态服务做桩。
+type HTTPTrackingProvider struct {
+	URL      string
+	Token    string   // 非空时作为 Bearer token
+	Carriers []string // 为空表示支持全部承运商
+	Client   *http.Client
+}
+
+func (p HTTPTrackingProvider) Supports(carrier string) bool {
+	return len(p.Carriers) == 0 || slices.Contains(p.Carriers, carrier)
+}
+
+func (p HTTPTrackingProvider) Track(ctx context.Context, carrier, number string) ([]CarrierEvent, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("carrier", carrier)
+	q.Set("number", number)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("tracking api %s: %s", resp.Status, b)
+	}
+	var out struct {
+		Events []CarrierEvent `json:"events"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Events, nil
+}
+
+// TrackingService 定期查询承运商并把事件合并进条目时间线
+type TrackingService struct {
+	entries   *EntriesService
+	providers []TrackingProvider
+}
+
+func NewTrackingService(entries *EntriesService, providers ...TrackingProvider) *TrackingService {
+	return &TrackingService{entries: entries, providers: providers}
+}
+
+func (s *TrackingService) provider(carrier string) TrackingProvider {
+	for _, p := range s.providers {
+		if p.Supports(carrier) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Sync 查询单个条目，返回新合并的事件数
+func (s *TrackingService) Sync(ctx context.Context, key string) (int, error) {
+	env, err := s.entries.LoadData(key)
+	if err != nil {
+		return 0, err
+	}
+	d := env.Data
+	if d.TrackingNumber == nil || *d.TrackingNumber == "" || d.Carrier == nil {
+		return 0, nil
+	}
+	p := s.provider(*d.Carrier)
+	if p == nil {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownCarrier, *d.Carrier)
+	}
+	fetched := time.Now()
+	events, err := p.Track(ctx, *d.Carrier, *d.TrackingNumber)
+	if err != nil {
+		return 0, err
+	}
+	return s.entries.MergeCarrierEvents(key, *d.Carrier, events, fetched)
+}
+
+// PollAll 查询所有填写了单号、已寄出且未到终态的条目
+func (s *TrackingService) PollAll(ctx context.Context) {
+	keys, err := s.entries.ListKeys()
+	if err != nil {
+		log.Printf("tracking poll: %v", err)
+		return
+	}
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+		st := s.entries.Status(key)
+		if st == StatusDraft || st.Final() || s.entries.Lifecycle(key) != LifecycleActive {
+			continue
+		}
+		n, err := s.Sync(ctx, key)
+		if err != nil {
+			log.Printf("tracking sync %s: %v", key, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("tracking sync %s: %d new events", key, n)
+		}
+	}
+}
+
+func (s *TrackingService) Start(interval time.Duration) {
+	if len(s.providers) == 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for range t.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			s.PollAll(ctx)
+			cancel()
+		}
+	}()
+}
+
+// MergeCarrierEvents 按事件 ID 去重后追加到时间线。能映射到状态且状态机允许的事件推进状态，
+// 其余事件只作为记录，状态保持不变。没有时间的事件记为 fetched（查询时间）；
+// 去重用的 ID 在此之前算出，之后重复查询到同一事件不会因为时间不同而重复添加
+func (s *EntriesService) MergeCarrierEvents(key, carrier string, events []CarrierEvent, fetched time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	env, err := s.LoadData(key)
+	if err != nil {
+		return 0, err
+	}
+	seen := map[string]bool{}
+	for _, ev := range env.Timeline {
+		if ev.ExtID != "" {
+			seen[ev.ExtID] = true
+		}
+	}
+	name := carrier
+	if c, ok := CarrierByCode(carrier); ok {
+		name = c.Name
+	}
+
+	events = slices.Clone(events)
+	for i := range events {
+		if events[i].ID == "" {
+			events[i].ID = events[i].key()
+		}
+		if events[i].Time.IsZero() {
+			events[i].Time = fetched
+		}
+	}
+	slices.SortStableFunc(events, func(a, b CarrierEvent) int { return a.Time.Compare(b.Time) })
+	added := 0
+	for _, ce := range events {
+		id := ce.ID
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		to := env.CurrentStatus()
+		if ce.Status != "" && CanTransition(to, ce.Status, RoleCarrier) {
+			to = ce.Status
+		}
+		env.Status = to
+		env.Timeline = append(env.Timeline, TrackingEvent{
+			Time:     ce.Time,
+			Status:   to,
+			Actor:    RoleCarrier,
+			By:       name,
+			Note:     ce.Description,
+			Location: ce.Location,
+			ExtID:    id,
+		})
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, s.writeEnvelopeLocked(key, env)
+}
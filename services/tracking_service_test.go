@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrackingNumbers(t *testing.T) {
+	for _, tc := range []struct {
+		carrier, number string
+		wantCode        string
+		wantErr         error
+	}{
+		// S10 校验位：12345678 -> 5，00000000 -> 5（余 0），00000050 -> 0（余 1）
+		{"upu", "RR123456785DE", "upu", nil},
+		{"upu", "RR123456784DE", "upu", ErrInvalidTrackingNumber},
+		{"upu", "RR000000005DE", "upu", nil},
+		{"upu", "RR000000500DE", "upu", nil},
+		{"upu", "RR000000505DE", "upu", ErrInvalidTrackingNumber},
+		{"ems", "EA123456785CN", "ems", nil},
+		{"ems", "EA123456780CN", "ems", ErrInvalidTrackingNumber},
+		{"chinapost", "RR123456785CN", "chinapost", nil},
+		{"chinapost", "RR123456789CN", "chinapost", ErrInvalidTrackingNumber},
+		{"chinapost", "1123456789012", "chinapost", nil},
+		{"nope", "RR123456785DE", "", ErrUnknownCarrier},
+
+		// 自动识别，越具体的格式越靠前
+		{"", "ea 1234-5678 5cn", "ems", nil},
+		{"", "RR123456785CN", "chinapost", nil},
+		{"", "RR123456785DE", "upu", nil},
+		{"", "RR123456784DE", "", ErrUnknownCarrier},
+		{"", "SF1234567890123", "sf", nil},
+		{"", "1Z999AA10123456784", "ups", nil},
+		{"", "9400111899223456789012", "usps", nil},
+		{"", "1123456789012", "chinapost", nil},
+		{"", "hello", "", ErrUnknownCarrier},
+	} {
+		code, _, err := ValidateTrackingNumber(tc.carrier, tc.number)
+		if code != tc.wantCode || err != tc.wantErr {
+			t.Errorf("ValidateTrackingNumber(%q, %q) = %q, %v; want %q, %v", tc.carrier, tc.number, code, err, tc.wantCode, tc.wantErr)
+		}
+	}
+}
+
+func TestTrackingSyncMerge(t *testing.T) {
+	entries, kis := newEntriesFixture(t, 1)
+	key := kis[0].Key
+	carrier, number := "upu", "RR123456785DE"
+	name := "收件人"
+	if err := entries.SaveData(key, EntryData{RecipientName: &name, Carrier: &carrier, TrackingNumber: &number}, StatusPosted); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	events := filepath.Join(dir, carrier, number+".json")
+	if err := os.MkdirAll(filepath.Dir(events), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tracking := NewTrackingService(entries, FileTrackingProvider{Dir: dir})
+
+	for _, step := range []struct {
+		name   string
+		events string
+		added  int
+		status EntryStatus
+	}{
+		{"no file", "", 0, StatusPosted},
+		{"first fetch", `[
+			{"id": "a1", "time": "2025-01-02T08:00:00Z", "status": "in_transit", "description": "已收寄"},
+			{"time": "2025-01-01T08:00:00Z", "description": "已下单"},
+			{"description": "没有时间的事件"}
+		]`, 3, StatusInTransit},
+		{"same events again", `[
+			{"id": "a1", "time": "2025-01-02T08:00:00Z", "status": "in_transit", "description": "已收寄"},
+			{"time": "2025-01-01T08:00:00Z", "description": "已下单"},
+			{"description": "没有时间的事件"}
+		]`, 0, StatusInTransit},
+		{"new event", `[
+			{"id": "a1", "time": "2025-01-02T08:00:00Z", "status": "in_transit", "description": "已收寄"},
+			{"id": "a2", "time": "2025-01-05T08:00:00Z", "status": "delivered", "description": "已妥投"}
+		]`, 1, StatusDelivered},
+	} {
+		if step.events != "" {
+			if err := os.WriteFile(events, []byte(step.events), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		before := time.Now()
+		n, err := tracking.Sync(context.Background(), key)
+		if err != nil || n != step.added {
+			t.Fatalf("%s: Sync = %d, %v; want %d", step.name, n, err, step.added)
+		}
+		env, err := entries.LoadData(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st := env.CurrentStatus(); st != step.status {
+			t.Fatalf("%s: status %s, want %s", step.name, st, step.status)
+		}
+		for _, ev := range env.Timeline {
+			if ev.Time.IsZero() {
+				t.Fatalf("%s: timeline has a zero-time event: %+v", step.name, ev)
+			}
+			if ev.Note == "没有时间的事件" && step.name == "first fetch" && ev.Time.Before(before) {
+				t.Fatalf("%s: zero-time event not stamped with the fetch time: %v", step.name, ev.Time)
+			}
+		}
+	}
+}
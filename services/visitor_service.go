@@ -0,0 +1,150 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 访问记录被折叠的原因
+const (
+	HiddenBot    = "bot"    // 爬虫、聊天软件的链接预览
+	HiddenRepeat = "repeat" // 同一访客在时间窗口内的重复查看
+)
+
+// 常见链接预览/爬虫的 UA 片段，useragent 库没识别为 Bot 时兜底
+var defaultUADenylist = []string{
+	"facebookexternalhit", "telegrambot", "whatsapp", "slackbot", "discordbot",
+	"twitterbot", "linkedinbot", "skypeuripreview", "applebot", "bytespider",
+}
+
+// VisitorFilter 把访问记录分类为有效查看、爬虫与重复查看
+type VisitorFilter struct {
+	uaDeny  []string
+	asnDeny []uint
+	window  time.Duration
+
+	mu    sync.Mutex
+	cache map[string]summaryCache
+}
+
+type summaryCache struct {
+	version string
+	sum     VisitSummary
+}
+
+// NewVisitorFilter uaDeny 为 UA 子串（不区分大小写），会追加到内置列表之后
+func NewVisitorFilter(uaDeny []string, asnDeny []uint, window time.Duration) *VisitorFilter {
+	deny := slices.Clone(defaultUADenylist)
+	for _, s := range uaDeny {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			deny = append(deny, s)
+		}
+	}
+	return &VisitorFilter{uaDeny: deny, asnDeny: asnDeny, window: window, cache: map[string]summaryCache{}}
+}
+
+// VisitSummary 访问统计
+type VisitSummary struct {
+	Total  int // 全部记录
+	Views  int // 排除爬虫后的查看次数
+	Unique int // 独立访客数
+	Hidden int // 默认折叠的记录数
+}
+
+// IsBot 根据 UA 解析结果、UA 黑名单与 ASN 黑名单判断
+func (f *VisitorFilter) IsBot(rec *HistoryRecord) bool {
+	if rec.UAObj != nil && rec.UAObj.Bot {
+		return true
+	}
+	ua := strings.ToLower(rec.UA)
+	for _, s := range f.uaDeny {
+		if strings.Contains(ua, s) {
+			return true
+		}
+	}
+	return rec.IPObj != nil && slices.Contains(f.asnDeny, rec.IPObj.ASN)
+}
+
+// Fingerprint 访客指纹：IP + UA。两者都已被匿名化清除时无法区分访客，返回空字符串
+func Fingerprint(rec *HistoryRecord) string {
+	if rec.IP == "" && rec.UA == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(rec.IP + "\x00" + rec.UA))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Window 重复查看的折叠窗口
+func (f *VisitorFilter) Window() time.Duration { return f.window }
+
+// classifier 按时间顺序（从旧到新）逐条分类
+type classifier struct {
+	f        *VisitorFilter
+	sum      VisitSummary
+	lastSeen map[string]time.Time // 指纹 -> 最近一次被计为有效查看的时间
+}
+
+func (f *VisitorFilter) newClassifier() *classifier {
+	return &classifier{f: f, lastSeen: map[string]time.Time{}}
+}
+
+// add 窗口内的后续访问折叠到第一次；没有指纹的（已匿名化）记录各自计为一次查看，不参与去重
+func (c *classifier) add(rec *HistoryRecord) {
+	c.sum.Total++
+	rec.Visitor = Fingerprint(rec)
+	if c.f.IsBot(rec) {
+		rec.Hidden = HiddenBot
+		c.sum.Hidden++
+		return
+	}
+	c.sum.Views++
+	if rec.Visitor == "" {
+		return
+	}
+	last, seen := c.lastSeen[rec.Visitor]
+	if !seen {
+		c.sum.Unique++
+	}
+	if seen && rec.Time.Sub(last) < c.f.window {
+		rec.Hidden = HiddenRepeat
+		c.sum.Hidden++
+		return
+	}
+	c.lastSeen[rec.Visitor] = rec.Time
+}
+
+// Classify 填写每条记录的 Visitor 与 Hidden，records 为倒序（最新在前）
+func (f *VisitorFilter) Classify(records []HistoryRecord) VisitSummary {
+	c := f.newClassifier()
+	for i := len(records) - 1; i >= 0; i-- {
+		c.add(&records[i])
+	}
+	return c.sum
+}
+
+// Summary 流式统计条目的全部访问记录；version 不变时直接返回缓存
+func (f *VisitorFilter) Summary(key, version string, scan func(fn func(rec HistoryRecord) bool) error) (VisitSummary, error) {
+	f.mu.Lock()
+	if e, ok := f.cache[key]; ok && e.version == version {
+		f.mu.Unlock()
+		return e.sum, nil
+	}
+	f.mu.Unlock()
+
+	c := f.newClassifier()
+	err := scan(func(rec HistoryRecord) bool {
+		c.add(&rec)
+		return true
+	})
+	if err != nil {
+		return c.sum, err
+	}
+	f.mu.Lock()
+	f.cache[key] = summaryCache{version: version, sum: c.sum}
+	f.mu.Unlock()
+	return c.sum, nil
+}
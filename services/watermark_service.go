@@ -0,0 +1,177 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// WatermarkConfig 水印设置；Text 与 ImagePath 都为空时不加水印
+type WatermarkConfig struct {
+	Text      string  // 文字模板，支持 {key} 与 {site} 占位
+	Site      string  // 站点名称，替换 {site}
+	ImagePath string  // PNG 水印图片路径，设置后优先于文字
+	FontPath  string  // TTF/OTF 字体路径；不设置时使用内置点阵字体（仅 ASCII）
+	Position  string  // top-left / top-right / bottom-left / bottom-right / center
+	Opacity   float64 // 0~1
+	Scale     float64 // 水印宽度占图片宽度的比例
+	MaxSize   int     // 派生图长边上限（像素）
+}
+
+// WatermarkService 为 /img 输出的派生图加水印，原图保持不变
+type WatermarkService struct {
+	cfg     WatermarkConfig
+	mark    image.Image // PNG 水印
+	font    *opentype.Font
+	version string // 配置指纹，配置变化后派生图自动失效
+}
+
+func NewWatermarkService(cfg WatermarkConfig) (*WatermarkService, error) {
+	if cfg.Opacity <= 0 || cfg.Opacity > 1 {
+		cfg.Opacity = 0.5
+	}
+	if cfg.Scale <= 0 || cfg.Scale > 1 {
+		cfg.Scale = 0.25
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 2560
+	}
+	if cfg.Position == "" {
+		cfg.Position = "bottom-right"
+	}
+	s := &WatermarkService{cfg: cfg}
+
+	var markBytes []byte
+	if cfg.ImagePath != "" {
+		b, err := os.ReadFile(cfg.ImagePath)
+		if err != nil {
+			return nil, fmt.Errorf("read watermark image: %w", err)
+		}
+		m, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("decode watermark image: %w", err)
+		}
+		s.mark, markBytes = m, b
+	}
+	if cfg.FontPath != "" {
+		b, err := os.ReadFile(cfg.FontPath)
+		if err != nil {
+			return nil, fmt.Errorf("read watermark font: %w", err)
+		}
+		f, err := opentype.Parse(b)
+		if err != nil {
+			return nil, fmt.Errorf("parse watermark font: %w", err)
+		}
+		s.font = f
+	}
+
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%+v", cfg)
+	h.Write(markBytes)
+	s.version = hex.EncodeToString(h.Sum(nil))[:12]
+	return s, nil
+}
+
+// Enabled 未配置水印时返回 false（nil 也视为未启用）
+func (s *WatermarkService) Enabled() bool {
+	return s != nil && (s.cfg.Text != "" || s.mark != nil)
+}
+
+// Version 当前配置的指纹
+func (s *WatermarkService) Version() string { return s.version }
+
+// Apply 缩放到派生图尺寸、按 EXIF 方向摆正后叠加水印，返回新图
+func (s *WatermarkService) Apply(src image.Image, orientation int, key string) image.Image {
+	dst := orient(fitRGBA(src, s.cfg.MaxSize), orientation)
+	b := dst.Bounds()
+
+	mark := s.mark
+	if mark == nil {
+		mark = s.textMark(strings.NewReplacer("{key}", key, "{site}", s.cfg.Site).Replace(s.cfg.Text))
+	}
+	mb := mark.Bounds()
+	if mb.Dx() == 0 || mb.Dy() == 0 {
+		return dst
+	}
+
+	// 按比例缩放水印
+	w := int(float64(b.Dx()) * s.cfg.Scale)
+	h := w * mb.Dy() / mb.Dx()
+	if w < 1 || h < 1 {
+		return dst
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), mark, mb, draw.Src, nil)
+
+	margin := min(b.Dx(), b.Dy()) / 40
+	var pt image.Point
+	switch s.cfg.Position {
+	case "top-left":
+		pt = image.Pt(margin, margin)
+	case "top-right":
+		pt = image.Pt(b.Dx()-w-margin, margin)
+	case "bottom-left":
+		pt = image.Pt(margin, b.Dy()-h-margin)
+	case "center":
+		pt = image.Pt((b.Dx()-w)/2, (b.Dy()-h)/2)
+	default:
+		pt = image.Pt(b.Dx()-w-margin, b.Dy()-h-margin)
+	}
+	alpha := image.NewUniform(color.Alpha{A: uint8(s.cfg.Opacity * 255)})
+	draw.DrawMask(dst, image.Rectangle{Min: pt, Max: pt.Add(image.Pt(w, h))}, scaled, image.Point{}, alpha, image.Point{}, draw.Over)
+	return dst
+}
+
+// textMark 把文字渲染成带阴影的白色文字图，之后再整体缩放
+func (s *WatermarkService) textMark(text string) image.Image {
+	var face font.Face = basicfont.Face7x13
+	if s.font != nil {
+		f, err := opentype.NewFace(s.font, &opentype.FaceOptions{Size: 64, DPI: 72, Hinting: font.HintingFull})
+		if err == nil {
+			face = f
+			defer f.Close()
+		}
+	}
+	m := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (m.Ascent + m.Descent).Ceil()
+	shadow := max(1, height/16)
+	img := image.NewRGBA(image.Rect(0, 0, width+shadow, height+shadow))
+
+	d := &font.Drawer{Dst: img, Face: face}
+	d.Src = image.NewUniform(color.RGBA{A: 160})
+	d.Dot = fixed.P(shadow, m.Ascent.Ceil()+shadow)
+	d.DrawString(text)
+	d.Src = image.White
+	d.Dot = fixed.P(0, m.Ascent.Ceil())
+	d.DrawString(text)
+	return img
+}
+
+// fitRGBA 复制到 RGBA，长边超过 maxSize 时等比缩小
+func fitRGBA(src image.Image, maxSize int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if long := max(w, h); long > maxSize {
+		w, h = w*maxSize/long, h*maxSize/long
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	if w == b.Dx() && h == b.Dy() {
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+		return dst
+	}
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,412 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailtrackerProject/helper"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 可订阅的 webhook 事件
+const (
+	WebhookEntryCreated = "entry.created"
+	WebhookEntryUpdated = "entry.updated"
+	WebhookEntryViewed  = "entry.viewed"
+	WebhookKeyGenerated = "key.generated"
+	WebhookKeyRevoked   = "key.revoked"
+)
+
+var WebhookEvents = []string{WebhookEntryCreated, WebhookEntryUpdated, WebhookEntryViewed, WebhookKeyGenerated, WebhookKeyRevoked}
+
+// WebhookEndpoint 管理员配置的接收端
+type WebhookEndpoint struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"` // HMAC-SHA256 签名密钥
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 投递状态
+const (
+	DeliveryPending = "pending"
+	DeliverySuccess = "success"
+	DeliveryFailed  = "failed" // 重试次数用尽
+)
+
+// WebhookDelivery 一次投递及其重试记录
+type WebhookDelivery struct {
+	ID          string          `json:"id"`
+	EndpointID  string          `json:"endpoint_id"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastCode    int             `json:"last_code,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	RedeliverOf string          `json:"redeliver_of,omitempty"` // 手动重发时指向原投递
+}
+
+const (
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+	webhookKeepLog     = 500 // 已结束的投递最多保留条数
+)
+
+// webhookBackoff 第 n 次失败后的等待时间：30s、1m、2m……封顶 6h
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookBaseBackoff << (attempts - 1)
+	if d <= 0 || d > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return d
+}
+
+// WebhookSignature 签名头的值：t=<unix 秒>,v1=hex(HMAC-SHA256(secret, "<t>.<body>"))
+func WebhookSignature(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "."))
+	mac.Write(body)
+	return "t=" + strconv.FormatInt(ts, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookService 保存接收端和投递队列，后台按指数退避重试。
+// 队列落盘在 webhooks/deliveries.json，重启后继续投递。
+type WebhookService struct {
+	dir    string
+	client *http.Client
+
+	mu         sync.Mutex
+	endpoints  []WebhookEndpoint
+	deliveries []WebhookDelivery
+	kick       chan struct{}
+}
+
+func NewWebhookService(dataDir string) *WebhookService {
+	return &WebhookService{
+		dir:    filepath.Join(dataDir, "webhooks"),
+		client: &http.Client{Timeout: 15 * time.Second},
+		kick:   make(chan struct{}, 1),
+	}
+}
+
+func (s *WebhookService) endpointsPath() string  { return filepath.Join(s.dir, "endpoints.json") }
+func (s *WebhookService) deliveriesPath() string { return filepath.Join(s.dir, "deliveries.json") }
+
+func (s *WebhookService) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := readJSONFile(s.endpointsPath(), &s.endpoints); err != nil {
+		return err
+	}
+	return readJSONFile(s.deliveriesPath(), &s.deliveries)
+}
+
+func readJSONFile(p string, v any) error {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+// 原子落盘：写入临时文件后 Rename 覆盖
+func writeJSONFile(p string, v any) error {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *WebhookService) Endpoints() []WebhookEndpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.endpoints)
+}
+
+func (s *WebhookService) AddEndpoint(url string, events []string) (WebhookEndpoint, error) {
+	if url == "" || len(events) == 0 {
+		return WebhookEndpoint{}, errors.New("url and events are required")
+	}
+	for _, e := range events {
+		if !slices.Contains(WebhookEvents, e) {
+			return WebhookEndpoint{}, fmt.Errorf("unknown event %q", e)
+		}
+	}
+	id, err := helper.RandKey(10)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	secret, err := helper.RandKey(32)
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	ep := WebhookEndpoint{ID: id, URL: url, Secret: secret, Events: events, CreatedAt: time.Now()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endpoints = append(s.endpoints, ep)
+	if err := writeJSONFile(s.endpointsPath(), s.endpoints); err != nil {
+		s.endpoints = s.endpoints[:len(s.endpoints)-1]
+		return WebhookEndpoint{}, err
+	}
+	return ep, nil
+}
+
+// RemoveEndpoint 删除接收端，未完成的投递一并取消
+func (s *WebhookService) RemoveEndpoint(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.endpoints, func(ep WebhookEndpoint) bool { return ep.ID == id })
+	if i < 0 {
+		return errors.New("endpoint not found")
+	}
+	s.endpoints = slices.Delete(s.endpoints, i, i+1)
+	for j := range s.deliveries {
+		if d := &s.deliveries[j]; d.EndpointID == id && d.Status == DeliveryPending {
+			d.Status, d.LastError, d.UpdatedAt = DeliveryFailed, "endpoint removed", time.Now()
+		}
+	}
+	if err := writeJSONFile(s.endpointsPath(), s.endpoints); err != nil {
+		return err
+	}
+	return writeJSONFile(s.deliveriesPath(), s.deliveries)
+}
+
+// Deliveries 最近的投递记录，新的在前
+func (s *WebhookService) Deliveries(limit int) []WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := slices.Clone(s.deliveries)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Emit 为订阅了该事件的每个接收端排入一次投递；nil 安全
+func (s *WebhookService) Emit(event string, data any) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queued := false
+	for _, ep := range s.endpoints {
+		if !slices.Contains(ep.Events, event) {
+			continue
+		}
+		id, err := helper.RandKey(16)
+		if err != nil {
+			log.Printf("webhook %s: %v", event, err)
+			return
+		}
+		payload, err := json.Marshal(map[string]any{
+			"id":         id,
+			"event":      event,
+			"created_at": now,
+			"data":       data,
+		})
+		if err != nil {
+			log.Printf("webhook %s: marshal payload: %v", event, err)
+			return
+		}
+		s.deliveries = append(s.deliveries, WebhookDelivery{
+			ID: id, EndpointID: ep.ID, Event: event, Payload: payload,
+			Status: DeliveryPending, NextAttempt: now, CreatedAt: now, UpdatedAt: now,
+		})
+		queued = true
+	}
+	if !queued {
+		return
+	}
+	if err := s.persistLocked(); err != nil {
+		log.Printf("webhook %s: persist queue: %v", event, err)
+	}
+	s.wake()
+}
+
+// Redeliver 复制一条历史投递重新排队，原记录保持不变
+func (s *WebhookService) Redeliver(id string) (WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.deliveries, func(d WebhookDelivery) bool { return d.ID == id })
+	if i < 0 {
+		return WebhookDelivery{}, errors.New("delivery not found")
+	}
+	newID, err := helper.RandKey(16)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	now := time.Now()
+	d := s.deliveries[i]
+	d.ID, d.RedeliverOf = newID, id
+	d.Status, d.Attempts, d.LastCode, d.LastError = DeliveryPending, 0, 0, ""
+	d.NextAttempt, d.CreatedAt, d.UpdatedAt = now, now, now
+	s.deliveries = append(s.deliveries, d)
+	if err := s.persistLocked(); err != nil {
+		return WebhookDelivery{}, err
+	}
+	s.wake()
+	return d, nil
+}
+
+func (s *WebhookService) wake() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// persistLocked 裁剪已结束的旧记录后落盘
+func (s *WebhookService) persistLocked() error {
+	done := 0
+	for _, d := range s.deliveries {
+		if d.Status != DeliveryPending {
+			done++
+		}
+	}
+	if drop := done - webhookKeepLog; drop > 0 {
+		kept := s.deliveries[:0]
+		for _, d := range s.deliveries {
+			if drop > 0 && d.Status != DeliveryPending {
+				drop--
+				continue
+			}
+			kept = append(kept, d)
+		}
+		s.deliveries = kept
+	}
+	return writeJSONFile(s.deliveriesPath(), s.deliveries)
+}
+
+// Start 后台投递：新事件立即尝试，失败的按 NextAttempt 轮询
+func (s *WebhookService) Start() {
+	go func() {
+		t := time.NewTicker(10 * time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-s.kick:
+			}
+			s.deliverDue()
+		}
+	}()
+}
+
+func (s *WebhookService) deliverDue() {
+	now := time.Now()
+	s.mu.Lock()
+	type job struct {
+		d  WebhookDelivery
+		ep WebhookEndpoint
+	}
+	var jobs []job
+	for _, d := range s.deliveries {
+		if d.Status != DeliveryPending || d.NextAttempt.After(now) {
+			continue
+		}
+		i := slices.IndexFunc(s.endpoints, func(ep WebhookEndpoint) bool { return ep.ID == d.EndpointID })
+		if i < 0 {
+			continue
+		}
+		jobs = append(jobs, job{d, s.endpoints[i]})
+	}
+	s.mu.Unlock()
+
+	// 投递过程中不持锁，结果回写时按 ID 查找
+	for _, j := range jobs {
+		code, err := s.send(j.ep, j.d)
+		s.mu.Lock()
+		if i := slices.IndexFunc(s.deliveries, func(d WebhookDelivery) bool { return d.ID == j.d.ID }); i >= 0 {
+			d := &s.deliveries[i]
+			d.Attempts++
+			d.LastCode, d.UpdatedAt = code, time.Now()
+			switch {
+			case err == nil:
+				d.Status, d.LastError = DeliverySuccess, ""
+			case d.Attempts >= webhookMaxAttempts:
+				d.Status, d.LastError = DeliveryFailed, err.Error()
+			default:
+				d.LastError = err.Error()
+				d.NextAttempt = d.UpdatedAt.Add(webhookBackoff(d.Attempts))
+			}
+			if perr := s.persistLocked(); perr != nil {
+				log.Printf("webhook persist queue: %v", perr)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// send 发送一次，非 2xx 视为失败
+func (s *WebhookService) send(ep WebhookEndpoint, d WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mailtracker-webhook/1")
+	req.Header.Set("X-Mailtracker-Event", d.Event)
+	req.Header.Set("X-Mailtracker-Delivery", d.ID)
+	req.Header.Set("X-Mailtracker-Signature", WebhookSignature(ep.Secret, time.Now().Unix(), d.Payload))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// EntryPayload webhook 中的条目数据，去掉查询凭据：密码，以及按收件人加密时作为口令的收件人姓名
+func EntryPayload(key string, env *EntryEnvelope) map[string]any {
+	cp := *env
+	if cp.Data.Encrypt != nil {
+		enc := *cp.Data.Encrypt
+		enc.Password = nil
+		cp.Data.Encrypt = &enc
+		if enc.Method != nil && *enc.Method == EncryptRecipient {
+			cp.Data.RecipientName = nil
+		}
+	}
+	return map[string]any{"key": key, "entry": cp}
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxMaxRows Excel 单个工作表的行数上限，超出后自动续到下一个同名工作表
+const xlsxMaxRows = 1 << 20
+
+// XLSXWriter 最小化的 xlsx 流式写入：逐行写入工作表 XML，不在内存里保留数据。
+// 只支持字符串（内联字符串）与数字单元格，时间按 RFC3339 文本写入。
+type XLSXWriter struct {
+	zw     *zip.Writer
+	sheet  *bufio.Writer
+	names  []string
+	base   string // 当前工作表的名称，续表时加序号
+	header []any  // 当前工作表的表头，续表时重复
+	rows   int    // 当前工作表已写行数
+	parts  int    // 当前表已续写的次数
+	closed bool
+}
+
+func NewXLSXWriter(w io.Writer) *XLSXWriter {
+	return &XLSXWriter{zw: zip.NewWriter(w)}
+}
+
+// Sheet 开始新的工作表，header 作为第一行写入
+func (x *XLSXWriter) Sheet(name string, header ...any) error {
+	x.base, x.header, x.parts = name, header, 1
+	return x.openSheet(name)
+}
+
+func (x *XLSXWriter) openSheet(name string) error {
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+	x.names = append(x.names, name)
+	f, err := x.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(x.names)))
+	if err != nil {
+		return err
+	}
+	x.sheet = bufio.NewWriter(f)
+	x.rows = 0
+	if _, err := x.sheet.WriteString(xml.Header +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return err
+	}
+	if len(x.header) > 0 {
+		return x.writeRow(x.header)
+	}
+	return nil
+}
+
+func (x *XLSXWriter) endSheet() error {
+	if x.sheet == nil {
+		return nil
+	}
+	if _, err := x.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	err := x.sheet.Flush()
+	x.sheet = nil
+	return err
+}
+
+// Row 写一行；nil 为空单元格
+func (x *XLSXWriter) Row(cells ...any) error {
+	if x.sheet == nil {
+		return fmt.Errorf("xlsx: no sheet")
+	}
+	if x.rows >= xlsxMaxRows {
+		x.parts++
+		if err := x.openSheet(fmt.Sprintf("%s (%d)", x.base, x.parts)); err != nil {
+			return err
+		}
+	}
+	return x.writeRow(cells)
+}
+
+func (x *XLSXWriter) writeRow(cells []any) error {
+	x.rows++
+	b := x.sheet
+	b.WriteString(`<row>`)
+	for _, v := range cells {
+		switch v := v.(type) {
+		case nil:
+			b.WriteString(`<c/>`)
+		case int:
+			b.WriteString(`<c><v>` + strconv.Itoa(v) + `</v></c>`)
+		case int64:
+			b.WriteString(`<c><v>` + strconv.FormatInt(v, 10) + `</v></c>`)
+		case uint:
+			b.WriteString(`<c><v>` + strconv.FormatUint(uint64(v), 10) + `</v></c>`)
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				b.WriteString(`<c/>`)
+			} else {
+				b.WriteString(`<c><v>` + strconv.FormatFloat(v, 'f', -1, 64) + `</v></c>`)
+			}
+		case bool:
+			if v {
+				b.WriteString(`<c t="b"><v>1</v></c>`)
+			} else {
+				b.WriteString(`<c t="b"><v>0</v></c>`)
+			}
+		case time.Time:
+			x.writeString(v.Format(time.RFC3339))
+		default:
+			x.writeString(fmt.Sprint(v))
+		}
+	}
+	_, err := b.WriteString(`</row>`)
+	return err
+}
+
+func (x *XLSXWriter) writeString(s string) {
+	x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	_ = xml.EscapeText(x.sheet, []byte(s)) // 非法的 XML 字符会被替换
+	x.sheet.WriteString(`</t></is></c>`)
+}
+
+// Close 写入工作簿结构并结束 zip
+func (x *XLSXWriter) Close() error {
+	if x.closed {
+		return nil
+	}
+	x.closed = true
+	if err := x.endSheet(); err != nil {
+		return err
+	}
+	if len(x.names) == 0 {
+		if err := x.Sheet("Sheet1"); err != nil {
+			return err
+		}
+		if err := x.endSheet(); err != nil {
+			return err
+		}
+	}
+
+	var types, sheets, rels strings.Builder
+	for i, name := range x.names {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxAttr(xlsxSheetName(name)), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+	stylesID := len(x.names) + 1
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, stylesID)
+
+	files := []struct{ name, body string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			types.String() + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			rels.String() + `</Relationships>`},
+		{"xl/styles.xml", `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/></cellXfs>` +
+			`</styleSheet>`},
+	}
+	for _, f := range files {
+		w, err := x.zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, xml.Header+f.body); err != nil {
+			return err
+		}
+	}
+	return x.zw.Close()
+}
+
+// xlsxSheetName 工作表名最长 31 个字符，且不能含 []:*?/\
+func xlsxSheetName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	if r := []rune(s); len(r) > 31 {
+		s = string(r[:31])
+	}
+	return s
+}
+
+func xlsxAttr(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return strings.ReplaceAll(b.String(), `"`, "&quot;")
+}